@@ -48,7 +48,11 @@ function initializeApp() {
 
     const filesGrid = document.getElementById("filesGrid");
     if (filesGrid) {
-        loadAvailableFiles();
+        if (currentMediaId && currentMediaType === "movie") {
+            loadMovieVersions(currentMediaId);
+        } else if (!currentMediaId) {
+            loadAvailableFiles();
+        }
     }
 }
 
@@ -307,12 +311,19 @@ async function loadSeasonEpisodes(tvId, seasonNumber) {
 
         updateEpisodesDisplay(data.episodes, availableFiles);
         updateEpisodesList(data.episodes, availableFiles);
+        updateFilesDisplay(seasonVersions(availableFiles));
     } catch (error) {
         console.error("Error loading season:", error);
         showToast("Failed to load episodes");
     }
 }
 
+function seasonVersions(availableFiles = {}) {
+    return Object.keys(availableFiles)
+        .sort((a, b) => a - b)
+        .flatMap((episode) => availableFiles[episode].versions || []);
+}
+
 function updateEpisodesDisplay(episodes, availableFiles = {}) {
     const heroEpisodesList = document.getElementById("heroEpisodesList");
     if (!heroEpisodesList) return;
@@ -379,6 +390,7 @@ async function loadMovieDetails(movieId) {
         if (availableFile.available) {
             addPlayButton(availableFile);
         }
+        updateFilesDisplay(availableFile.versions || []);
 
         if (data.recommendations && data.recommendations.results) {
             updateRecommendations(data.recommendations.results);
@@ -422,57 +434,19 @@ async function loadTrendingContent() {
 }
 
 async function loadRecentlyAdded() {
-    try {
-        const response = await fetch(`${API_BASE}/files?limit=16`);
-        const files = await response.json();
-
-        if (files && files.length > 0) {
-            const uniqueMedia = getUniqueMediaFromFiles(files);
-            await loadTMDBDetailsForRecent(uniqueMedia);
-        }
-    } catch (error) {
-        console.error("Error loading recently added:", error);
-    }
-}
-
-function getUniqueMediaFromFiles(files) {
-    const mediaMap = new Map();
-
-    files.forEach((file) => {
-        const key = `${file.media_type}-${file.tmdb_id}`;
-        if (!mediaMap.has(key)) {
-            mediaMap.set(key, {
-                tmdb_id: file.tmdb_id,
-                media_type: file.media_type,
-                title: file.title,
-                created_at: file.created_at,
-            });
-        }
-    });
-
-    return Array.from(mediaMap.values()).slice(0, 16);
-}
-
-async function loadTMDBDetailsForRecent(mediaList) {
     const recentlyAddedGrid = document.getElementById("recentlyAddedGrid");
     if (!recentlyAddedGrid) return;
 
-    recentlyAddedGrid.innerHTML = "";
-
-    for (const media of mediaList) {
-        try {
-            const endpoint = media.media_type === "tv" ? "tv" : "movie";
-            const response = await fetch(`${API_BASE}/${endpoint}/${media.tmdb_id}`);
-            const data = await response.json();
+    try {
+        const response = await fetch(`${API_BASE}/titles/recent?limit=16`);
+        const titles = await response.json();
 
-            if (data.id) {
-                data.media_type = media.media_type;
-                const card = createRecommendationCard(data);
-                recentlyAddedGrid.appendChild(card);
-            }
-        } catch (error) {
-            console.error(`Error loading TMDB details for ${media.title}:`, error);
-        }
+        recentlyAddedGrid.innerHTML = "";
+        (titles || []).forEach((title) => {
+            recentlyAddedGrid.appendChild(createRecommendationCard(title));
+        });
+    } catch (error) {
+        console.error("Error loading recently added:", error);
     }
 
     if (recentlyAddedGrid.children.length === 0) {
@@ -551,6 +525,17 @@ function createRecommendationCard(item) {
     return card;
 }
 
+async function loadMovieVersions(movieId) {
+    try {
+        const response = await fetch(`${API_BASE}/media/movie/${movieId}`);
+        const data = await response.json();
+
+        updateFilesDisplay(data.versions || []);
+    } catch (error) {
+        console.error("Error loading files:", error);
+    }
+}
+
 async function loadAvailableFiles() {
     try {
         const response = await fetch(`${API_BASE}/files`);
@@ -876,8 +861,9 @@ function setupPlayButton() {
                     fileToPlay = data;
                 }
             } else {
-                if (data.files && data.files.length > 0) {
-                    fileToPlay = data.files[0];
+                const versions = seasonVersions(data);
+                if (versions.length > 0) {
+                    fileToPlay = versions[0];
                 }
             }
 
@@ -911,5 +897,6 @@ window.StrixAPI = {
     loadMovieDetails,
     performSearch,
     loadAvailableFiles,
+    loadMovieVersions,
     fetchIMDBRating,
 };
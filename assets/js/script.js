@@ -563,6 +563,7 @@ document.addEventListener("DOMContentLoaded", function () {
 
     if (mobileSearchInput && mobileSearchContainer) {
         let mobileSearchTimeout;
+        let mobileTMDBTimeout;
         let mobileSearchDropdown = null;
 
         mobileSearchDropdown = document.createElement("div");
@@ -573,14 +574,16 @@ document.addEventListener("DOMContentLoaded", function () {
 
         mobileSearchInput.addEventListener("input", function (e) {
             clearTimeout(mobileSearchTimeout);
+            clearTimeout(mobileTMDBTimeout);
             const query = e.target.value.trim();
 
-            console.log("Mobile search input:", query);
-
             if (query.length >= 2) {
-                showMobileSearchLoading();
-                mobileSearchTimeout = setTimeout(() => performMobileSearch(query), 500);
+                // Library suggestions are cheap, so ask on nearly every
+                // keystroke; TMDB only once typing pauses.
+                mobileSearchTimeout = setTimeout(() => performMobileSearch(query, false), 120);
+                mobileTMDBTimeout = setTimeout(() => performMobileSearch(query, true), 500);
             } else {
+                mobileSearchSeq++;
                 hideMobileSearchResults();
             }
         });
@@ -589,7 +592,8 @@ document.addEventListener("DOMContentLoaded", function () {
             if (e.key === "Enter") {
                 const query = e.target.value.trim();
                 if (query && query.length >= 2) {
-                    performMobileSearch(query);
+                    clearTimeout(mobileTMDBTimeout);
+                    performMobileSearch(query, true);
                 }
             }
         });
@@ -624,68 +628,89 @@ document.addEventListener("DOMContentLoaded", function () {
         }
     }
 
-    async function performMobileSearch(query) {
+    let mobileSearchSeq = 0;
+
+    async function performMobileSearch(query, withTMDB) {
         if (!query) return;
 
-        console.log("Performing mobile search for:", query);
+        const seq = ++mobileSearchSeq;
 
         try {
             const response = await fetch(
-                `/api/search?q=${encodeURIComponent(query)}`
+                `/api/suggest?q=${encodeURIComponent(query)}${withTMDB ? "&tmdb=1" : ""}`
             );
             const data = await response.json();
 
-            console.log("Mobile search results:", data);
+            // A slower, older request must not overwrite newer results.
+            if (seq !== mobileSearchSeq) return;
 
-            if (data.results && data.results.length > 0) {
-                displayMobileSearchResults(data.results);
-            } else {
+            const library = data.library || [];
+            const tmdb = data.tmdb || [];
+
+            if (library.length > 0 || tmdb.length > 0) {
+                displayMobileSearchResults(library, tmdb);
+            } else if (withTMDB) {
                 displayMobileNoResults();
+            } else {
+                showMobileSearchLoading();
             }
         } catch (error) {
             console.error("Mobile search error:", error);
-            displayMobileSearchError();
+            if (seq === mobileSearchSeq) {
+                displayMobileSearchError();
+            }
         }
     }
 
-    function displayMobileSearchResults(results) {
+    function createSuggestionItem(result) {
+        const item = document.createElement("div");
+        item.className = "search-result-item";
+        if (!result.available) {
+            item.classList.add("unavailable");
+        }
+
+        const posterPath = result.poster_path
+            ? `https://image.tmdb.org/t/p/w92${result.poster_path}`
+            : 'data:image/svg+xml,%3Csvg xmlns="http://www.w3.org/2000/svg" width="92" height="138"%3E%3Crect fill="%23333" width="92" height="138"/%3E%3C/svg%3E';
+
+        const title = result.title || "Unknown";
+        const mediaType = result.media_type || "movie";
+        const typeLabel = mediaType === "tv" ? "TV Series" : "Movie";
+        const badge = result.available
+            ? '<span class="search-result-type">In Library</span>'
+            : '<span class="search-result-type unavailable">Not Available</span>';
+
+        item.innerHTML = `
+            <img src="${posterPath}" alt="${title}" class="search-result-poster">
+            <div class="search-result-info">
+                <div class="search-result-title">${title}</div>
+                <div class="search-result-meta">${badge} ${typeLabel} ${result.year ? `• ${result.year}` : ""}</div>
+            </div>
+        `;
+
+        item.addEventListener("click", () => {
+            window.location.href = `/${mediaType}/${result.id}`;
+        });
+
+        return item;
+    }
+
+    function displayMobileSearchResults(library, tmdb) {
         const dropdown = document.querySelector(".mobile-search-results-dropdown");
         if (!dropdown) return;
 
         dropdown.innerHTML = "";
 
-        results.slice(0, 8).forEach((result) => {
-            const item = document.createElement("div");
-            item.className = "search-result-item";
-
-            const posterPath = result.poster_path
-                ? `https://image.tmdb.org/t/p/w92${result.poster_path}`
-                : 'data:image/svg+xml,%3Csvg xmlns="http://www.w3.org/2000/svg" width="92" height="138"%3E%3Crect fill="%23333" width="92" height="138"/%3E%3C/svg%3E';
-
-            const title = result.title || result.name || "Unknown";
-            const year = result.release_date
-                ? result.release_date.split("-")[0]
-                : result.first_air_date
-                    ? result.first_air_date.split("-")[0]
-                    : "";
-            const mediaType = result.media_type || "movie";
-            const typeLabel = mediaType === "tv" ? "TV Series" : "Movie";
-
-            item.innerHTML = `
-                <img src="${posterPath}" alt="${title}" class="search-result-poster">
-                <div class="search-result-info">
-                    <div class="search-result-title">${title}</div>
-                    <div class="search-result-meta">${typeLabel} ${year ? `• ${year}` : ""
-                }</div>
-                </div>
-            `;
+        library.forEach((result) => dropdown.appendChild(createSuggestionItem(result)));
 
-            item.addEventListener("click", () => {
-                window.location.href = `/${mediaType}/${result.id}`;
-            });
+        if (tmdb.length > 0) {
+            const heading = document.createElement("div");
+            heading.className = "search-section-heading";
+            heading.textContent = "More on TMDB";
+            dropdown.appendChild(heading);
 
-            dropdown.appendChild(item);
-        });
+            tmdb.forEach((result) => dropdown.appendChild(createSuggestionItem(result)));
+        }
 
         dropdown.classList.add("visible");
     }
@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const chunkExt = ".chunk"
+
+type chunkEntry struct {
+	key  string
+	path string
+	size int64
+}
+
+// ChunkCache keeps downloaded file parts on disk, keyed by document ID and
+// part number, and evicts the least recently used parts once the total
+// size goes over the cap.
+type ChunkCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type ChunkStats struct {
+	Hits     int64
+	Misses   int64
+	Entries  int
+	SizeMB   float64
+	MaxMB    float64
+	HitRatio float64
+}
+
+func NewChunkCache(dir string, maxBytes int64) (*ChunkCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &ChunkCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[CACHE] %d chunk(s), %.1f MB in %s", c.lru.Len(), float64(c.size)/(1024*1024), dir)
+	return c, nil
+}
+
+// load rebuilds the LRU order from file modification times and removes
+// temporary files left behind by writes that never completed.
+func (c *ChunkCache) load() error {
+	type found struct {
+		entry   *chunkEntry
+		modTime time.Time
+	}
+	var files []found
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if strings.HasSuffix(path, ".tmp") {
+			os.Remove(path)
+			return nil
+		}
+		if !strings.HasSuffix(path, chunkExt) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		files = append(files, found{
+			entry: &chunkEntry{
+				key:  strings.TrimSuffix(filepath.Base(path), chunkExt),
+				path: path,
+				size: info.Size(),
+			},
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	for _, f := range files {
+		c.entries[f.entry.key] = c.lru.PushBack(f.entry)
+		c.size += f.entry.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return nil
+}
+
+func chunkKey(docID, part int64) string {
+	return strconv.FormatInt(docID, 10) + "_" + strconv.FormatInt(part, 10)
+}
+
+func (c *ChunkCache) chunkPath(docID int64, key string) string {
+	shard := fmt.Sprintf("%02x", uint64(docID)%256)
+	return filepath.Join(c.dir, shard, key+chunkExt)
+}
+
+func (c *ChunkCache) Get(docID, part int64) ([]byte, bool) {
+	key := chunkKey(docID, part)
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	entry := elem.Value.(*chunkEntry)
+	data, err := os.ReadFile(entry.path)
+	if err != nil {
+		c.remove(key)
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(entry.path, now, now)
+
+	c.hits.Add(1)
+	return data, true
+}
+
+// Put stores a part. The data is written to a temporary file, synced and
+// renamed into place so a crash never leaves a truncated chunk behind.
+func (c *ChunkCache) Put(docID, part int64, data []byte) error {
+	if int64(len(data)) > c.maxBytes {
+		return nil
+	}
+
+	key := chunkKey(docID, part)
+	path := c.chunkPath(docID, key)
+
+	c.mu.Lock()
+	_, exists := c.entries[key]
+	c.mu.Unlock()
+	if exists {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+"-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; exists {
+		return nil
+	}
+	c.entries[key] = c.lru.PushFront(&chunkEntry{key: key, path: path, size: int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// Invalidate drops every cached part of a document.
+func (c *ChunkCache) Invalidate(docID int64) {
+	prefix := strconv.FormatInt(docID, 10) + "_"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *ChunkCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// evict must be called with c.mu held.
+func (c *ChunkCache) evict() {
+	for c.size > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		c.removeElement(elem)
+	}
+}
+
+// removeElement must be called with c.mu held.
+func (c *ChunkCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*chunkEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	os.Remove(entry.path)
+}
+
+func (c *ChunkCache) Stats() ChunkStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	size := c.size
+	c.mu.Unlock()
+
+	stats := ChunkStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+		SizeMB:  float64(size) / (1024 * 1024),
+		MaxMB:   float64(c.maxBytes) / (1024 * 1024),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total) * 100
+	}
+	return stats
+}
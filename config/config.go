@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,19 +17,51 @@ type Config struct {
 	FilesDir     string
 	BaseURL      string
 
+	LLMProvider   string
+	LLMBatchSize  int
+	GeminiModel   string
+	OpenAIBaseURL string
+	OpenAIAPIKey  string
+	OpenAIModel   string
+
+	TMDBBaseURL  string
+	TMDBLanguage string
+	TMDBCacheTTL time.Duration
+
+	TitleRefreshInterval time.Duration
+
+	ProbeMedia bool
+
 	AppID    int
 	AppHash  string
 	BotToken string
 
-	CDNBots []string
+	CDNBots     []string
+	CDNStrategy string
+
+	StreamPrefetch   int
+	ChunkCacheMB     int64
+	MetadataCacheTTL time.Duration
 
-	LogChannel   int64
-	IndexChannel int64
-	OwnerID      int64
-	AuthUsers    []int64
+	LogChannel     int64
+	IndexChannel   int64
+	SourceChannels []int64
+	OwnerID        int64
+	AuthUsers      []int64
 
-	MongoURL string
-	DBName   string
+	MatchConfidence float64
+
+	DBBackend  string
+	MongoURL   string
+	DBName     string
+	SQLitePath string
+
+	AutoMigrate bool
+
+	StreamSecrets    []string
+	StreamTokenTTL   time.Duration
+	LegacyTokenUntil time.Time
+	LegacyTokensOff  bool
 }
 
 func Load() *Config {
@@ -44,8 +77,18 @@ func Load() *Config {
 		BaseURL:      getEnv("BASE_URL", "https://placeholder.com"),
 		AppHash:      getEnv("APP_HASH", ""),
 		BotToken:     getEnv("BOT_TOKEN", ""),
+		DBBackend:    strings.ToLower(getEnv("DB_BACKEND", "mongo")),
 		MongoURL:     getEnv("MONGO_URL", "mongodb://localhost:27017"),
 		DBName:       getEnv("DB_NAME", "strix"),
+		SQLitePath:   getEnv("SQLITE_PATH", "strix.sqlite"),
+		CDNStrategy:  getEnv("CDN_STRATEGY", "least-loaded"),
+		TMDBBaseURL:  getEnv("TMDB_BASE_URL", "https://api.themoviedb.org/3"),
+		TMDBLanguage: getEnv("TMDB_LANGUAGE", ""),
+
+		GeminiModel:   getEnv("GEMINI_MODEL", "gemini-flash-latest"),
+		OpenAIBaseURL: strings.TrimSuffix(getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1"), "/"),
+		OpenAIAPIKey:  getEnv("OPENAI_API_KEY", ""),
+		OpenAIModel:   getEnv("OPENAI_MODEL", "gpt-4o-mini"),
 	}
 
 	if cfg.TMDBAPIKey == "" {
@@ -61,6 +104,19 @@ func Load() *Config {
 	indexChannel, _ := strconv.ParseInt(getEnv("INDEX_CHANNEL", "0"), 10, 64)
 	cfg.IndexChannel = indexChannel
 
+	for _, idStr := range strings.Split(getEnv("SOURCE_CHANNELS", ""), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+		if err == nil && id != 0 {
+			cfg.SourceChannels = append(cfg.SourceChannels, id)
+		}
+	}
+
+	confidence, err := strconv.ParseFloat(getEnv("MATCH_CONFIDENCE", "0.8"), 64)
+	if err != nil || confidence <= 0 || confidence > 1 {
+		confidence = 0.8
+	}
+	cfg.MatchConfidence = confidence
+
 	ownerID, _ := strconv.ParseInt(getEnv("OWNER_ID", "0"), 10, 64)
 	cfg.OwnerID = ownerID
 
@@ -74,6 +130,83 @@ func Load() *Config {
 		}
 	}
 
+	for _, secret := range strings.Split(getEnv("STREAM_SECRET", ""), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			cfg.StreamSecrets = append(cfg.StreamSecrets, secret)
+		}
+	}
+
+	cfg.AutoMigrate = getEnv("AUTO_MIGRATE", "true") != "false"
+	cfg.ProbeMedia = getEnv("PROBE_MEDIA", "true") != "false"
+
+	// Gemini stays the default filename parser for existing setups that
+	// only set GEMINI_API_KEY.
+	cfg.LLMProvider = strings.ToLower(getEnv("LLM_PROVIDER", ""))
+	if cfg.LLMProvider == "" && cfg.GeminiAPIKey != "" {
+		cfg.LLMProvider = "gemini"
+	}
+
+	batchSize, err := strconv.Atoi(getEnv("LLM_BATCH_SIZE", "20"))
+	if err != nil || batchSize < 1 {
+		batchSize = 20
+	}
+	cfg.LLMBatchSize = batchSize
+
+	prefetch, err := strconv.Atoi(getEnv("STREAM_PREFETCH", "4"))
+	if err != nil || prefetch < 1 {
+		prefetch = 4
+	}
+	cfg.StreamPrefetch = prefetch
+
+	cacheMB, err := strconv.ParseInt(getEnv("CHUNK_CACHE_MB", "2048"), 10, 64)
+	if err != nil || cacheMB < 0 {
+		cacheMB = 2048
+	}
+	cfg.ChunkCacheMB = cacheMB
+
+	metadataTTL, err := time.ParseDuration(getEnv("METADATA_CACHE_TTL", "30m"))
+	if err != nil || metadataTTL <= 0 {
+		metadataTTL = 30 * time.Minute
+	}
+	cfg.MetadataCacheTTL = metadataTTL
+
+	tmdbTTL, err := time.ParseDuration(getEnv("TMDB_CACHE_TTL", "1h"))
+	if err != nil || tmdbTTL < 0 {
+		tmdbTTL = time.Hour
+	}
+	cfg.TMDBCacheTTL = tmdbTTL
+
+	refreshInterval, err := time.ParseDuration(getEnv("TITLE_REFRESH_INTERVAL", "24h"))
+	if err != nil || refreshInterval <= 0 {
+		refreshInterval = 24 * time.Hour
+	}
+	cfg.TitleRefreshInterval = refreshInterval
+
+	tokenTTL, err := time.ParseDuration(getEnv("STREAM_TOKEN_TTL", "24h"))
+	if err != nil || tokenTTL <= 0 {
+		log.Printf("Invalid STREAM_TOKEN_TTL, using 24h")
+		tokenTTL = 24 * time.Hour
+	}
+	cfg.StreamTokenTTL = tokenTTL
+
+	// Unsigned links from before tokens were signed keep working for a grace
+	// period unless LEGACY_TOKENS_UNTIL sets its end or turns them off.
+	switch until := getEnv("LEGACY_TOKENS_UNTIL", ""); strings.ToLower(until) {
+	case "":
+	case "off", "false", "0":
+		cfg.LegacyTokensOff = true
+	default:
+		deadline, err := time.Parse("2006-01-02", until)
+		if err != nil {
+			deadline, err = time.Parse(time.RFC3339, until)
+		}
+		if err != nil {
+			log.Printf("Invalid LEGACY_TOKENS_UNTIL %q, using the default grace period", until)
+		} else {
+			cfg.LegacyTokenUntil = deadline
+		}
+	}
+
 	for i := 1; i <= 10; i++ {
 		token := getEnv("CDN_BOT_"+strconv.Itoa(i), "")
 		if token != "" {
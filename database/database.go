@@ -2,21 +2,27 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
+	"strix/release"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DB struct {
+type MongoDB struct {
 	client *mongo.Client
 	db     *mongo.Database
 }
 
-func Init(mongoURL, dbName string) (*DB, error) {
+func InitMongo(mongoURL, dbName string) (*MongoDB, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -29,128 +35,129 @@ func Init(mongoURL, dbName string) (*DB, error) {
 		return nil, err
 	}
 
-	database := &DB{
+	database := &MongoDB{
 		client: client,
 		db:     client.Database(dbName),
 	}
 
-	if err := database.createIndexes(ctx); err != nil {
-		return nil, err
-	}
-
 	log.Printf("MongoDB connected: %s/%s", mongoURL, dbName)
 	return database, nil
 }
 
-func (d *DB) createIndexes(ctx context.Context) error {
-	mediaCollection := d.db.Collection("media")
-
-	indexes := []mongo.IndexModel{
-		{
-			Keys: bson.D{{Key: "tmdb_id", Value: 1}},
-		},
-		{
-			Keys: bson.D{{Key: "media_type", Value: 1}},
-		},
-		{
-			Keys: bson.D{{Key: "file_id", Value: 1}},
-		},
-		{
-			Keys: bson.D{{Key: "title", Value: 1}},
-		},
-		{
-			Keys: bson.D{{Key: "file_name", Value: 1}},
-		},
-		{
-			Keys: bson.D{
-				{Key: "title", Value: "text"},
-				{Key: "file_name", Value: "text"},
-			},
-		},
-		{
-			Keys: bson.D{
-				{Key: "tmdb_id", Value: 1},
-				{Key: "media_type", Value: 1},
-				{Key: "season", Value: 1},
-				{Key: "episode", Value: 1},
-			},
-			Options: options.Index().SetUnique(true),
-		},
-	}
+func (d *MongoDB) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return d.client.Disconnect(ctx)
+}
 
-	_, err := mediaCollection.Indexes().CreateMany(ctx, indexes)
-	if err != nil {
-		return err
-	}
+func (d *MongoDB) AddMedia(tmdbID int, mediaType, title, fileID string, messageID int, chatID int64, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, cdnBotIndex int, release *ReleaseInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	usersCollection := d.db.Collection("users")
-	userIndexes := []mongo.IndexModel{
-		{
-			Keys:    bson.D{{Key: "user_id", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
-	}
+	collection := d.db.Collection("media")
 
-	_, err = usersCollection.Indexes().CreateMany(ctx, userIndexes)
-	if err != nil {
-		return err
+	// Each message is one version of the title; re-adding the same message
+	// updates it instead of replacing other qualities.
+	filter := bson.M{
+		"chat_id":    chatID,
+		"message_id": messageID,
 	}
 
-	authUsersCollection := d.db.Collection("auth_users")
-	authIndexes := []mongo.IndexModel{
-		{
-			Keys:    bson.D{{Key: "user_id", Value: 1}},
-			Options: options.Index().SetUnique(true),
+	update := bson.M{
+		"$set": bson.M{
+			"tmdb_id":       tmdbID,
+			"media_type":    mediaType,
+			"season":        season,
+			"episode":       episode,
+			"last_episode":  lastEpisode,
+			"title":         title,
+			"file_id":       fileID,
+			"file_size":     fileSize,
+			"file_name":     fileName,
+			"quality":       quality,
+			"cdn_bot_index": cdnBotIndex,
+			"release":       release,
+			"updated_at":    time.Now(),
 		},
-	}
-
-	_, err = authUsersCollection.Indexes().CreateMany(ctx, authIndexes)
-	if err != nil {
-		return err
-	}
-
-	settingsCollection := d.db.Collection("settings")
-	settingsIndexes := []mongo.IndexModel{
-		{
-			Keys:    bson.D{{Key: "key", Value: 1}},
-			Options: options.Index().SetUnique(true),
+		"$setOnInsert": bson.M{
+			"created_at": time.Now(),
 		},
 	}
 
-	_, err = settingsCollection.Indexes().CreateMany(ctx, settingsIndexes)
+	opts := options.Update().SetUpsert(true)
+	_, err := collection.UpdateOne(ctx, filter, update, opts)
 	return err
 }
 
-func (d *DB) Close() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// AddMediaBatch stores many Telegram files in one round trip, with the same
+// upsert-by-message semantics as AddMedia.
+func (d *MongoDB) AddMediaBatch(files []MediaFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	return d.client.Disconnect(ctx)
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(files))
+	for _, f := range files {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{
+				"chat_id":    f.ChatID,
+				"message_id": f.MessageID,
+			}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"tmdb_id":       f.TMDBID,
+					"media_type":    f.MediaType,
+					"season":        f.Season,
+					"episode":       f.Episode,
+					"last_episode":  f.LastEpisode,
+					"title":         f.Title,
+					"file_id":       f.FileID,
+					"file_size":     f.FileSize,
+					"file_name":     f.FileName,
+					"quality":       f.Quality,
+					"cdn_bot_index": f.CDNBotIndex,
+					"release":       f.Release,
+					"updated_at":    now,
+				},
+				"$setOnInsert": bson.M{
+					"created_at": now,
+				},
+			}).
+			SetUpsert(true))
+	}
+
+	_, err := d.db.Collection("media").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
 }
 
-func (d *DB) AddMedia(tmdbID int, mediaType, title, fileID string, messageID int, chatID int64, fileSize int64, fileName string, season, episode int, quality string, cdnBotIndex int) error {
+func (d *MongoDB) AddLocalMedia(tmdbID int, mediaType, title, filePath string, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, release *ReleaseInfo) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	collection := d.db.Collection("media")
 
 	filter := bson.M{
-		"tmdb_id":    tmdbID,
-		"media_type": mediaType,
-		"season":     season,
-		"episode":    episode,
+		"source":    SourceLocal,
+		"file_path": filePath,
 	}
 
 	update := bson.M{
 		"$set": bson.M{
-			"title":         title,
-			"file_id":       fileID,
-			"message_id":    messageID,
-			"chat_id":       chatID,
-			"file_size":     fileSize,
-			"file_name":     fileName,
-			"quality":       quality,
-			"cdn_bot_index": cdnBotIndex,
-			"updated_at":    time.Now(),
+			"tmdb_id":      tmdbID,
+			"media_type":   mediaType,
+			"season":       season,
+			"episode":      episode,
+			"last_episode": lastEpisode,
+			"title":        title,
+			"file_size":    fileSize,
+			"file_name":    fileName,
+			"quality":      quality,
+			"release":      release,
+			"updated_at":   time.Now(),
 		},
 		"$setOnInsert": bson.M{
 			"created_at": time.Now(),
@@ -162,7 +169,7 @@ func (d *DB) AddMedia(tmdbID int, mediaType, title, fileID string, messageID int
 	return err
 }
 
-func (d *DB) GetMediaByTMDB(tmdbID int, mediaType string, season, episode int) (*MediaFile, error) {
+func (d *MongoDB) GetMediaByTMDB(tmdbID int, mediaType string, season, episode int) (*MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -172,8 +179,8 @@ func (d *DB) GetMediaByTMDB(tmdbID int, mediaType string, season, episode int) (
 		"tmdb_id":    tmdbID,
 		"media_type": mediaType,
 		"season":     season,
-		"episode":    episode,
 	}
+	matchEpisode(filter, episode)
 
 	var m MediaFile
 	err := collection.FindOne(ctx, filter).Decode(&m)
@@ -187,7 +194,45 @@ func (d *DB) GetMediaByTMDB(tmdbID int, mediaType string, season, episode int) (
 	return &m, nil
 }
 
-func (d *DB) GetSeasonEpisodes(tmdbID int, season int) ([]MediaFile, error) {
+// matchEpisode narrows filter to files holding the episode, on its own or
+// as part of a multi-episode file.
+func matchEpisode(filter bson.M, episode int) {
+	filter["$or"] = []bson.M{
+		{"episode": episode},
+		{"episode": bson.M{"$lt": episode}, "last_episode": bson.M{"$gte": episode}},
+	}
+}
+
+// GetMediaVersions returns every file stored for a movie or episode.
+func (d *MongoDB) GetMediaVersions(tmdbID int, mediaType string, season, episode int) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := d.db.Collection("media")
+
+	filter := bson.M{
+		"tmdb_id":    tmdbID,
+		"media_type": mediaType,
+		"season":     season,
+	}
+	matchEpisode(filter, episode)
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []MediaFile
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	SortVersions(versions)
+	return versions, nil
+}
+
+func (d *MongoDB) GetSeasonEpisodes(tmdbID int, season int) ([]MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -199,7 +244,7 @@ func (d *DB) GetSeasonEpisodes(tmdbID int, season int) ([]MediaFile, error) {
 		"media_type": "tv",
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "episode", Value: 1}})
+	opts := options.Find().SetSort(bson.D{{Key: "episode", Value: 1}, {Key: "file_size", Value: -1}})
 	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
@@ -214,7 +259,7 @@ func (d *DB) GetSeasonEpisodes(tmdbID int, season int) ([]MediaFile, error) {
 	return episodes, nil
 }
 
-func (d *DB) SearchMedia(query string) ([]MediaFile, error) {
+func (d *MongoDB) SearchMedia(query string) ([]MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -245,7 +290,7 @@ func (d *DB) SearchMedia(query string) ([]MediaFile, error) {
 	return results, nil
 }
 
-func (d *DB) GetAllMedia(limit, offset int) ([]MediaFile, error) {
+func (d *MongoDB) GetAllMedia(limit, offset int) ([]MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -270,6 +315,16 @@ func (d *DB) GetAllMedia(limit, offset int) ([]MediaFile, error) {
 	return media, nil
 }
 
+// Media sources a MediaFile can point at. Records without a source are
+// Telegram messages.
+const (
+	SourceTelegram = "telegram"
+	SourceLocal    = "local"
+)
+
+// MediaFile is one stored file. A file holding several episodes, such as a
+// double episode or a season pack, covers Episode through LastEpisode;
+// LastEpisode is zero otherwise.
 type MediaFile struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	TMDBID      int                `bson:"tmdb_id" json:"tmdb_id"`
@@ -282,12 +337,118 @@ type MediaFile struct {
 	FileName    string             `bson:"file_name" json:"file_name"`
 	Season      int                `bson:"season" json:"season"`
 	Episode     int                `bson:"episode" json:"episode"`
+	LastEpisode int                `bson:"last_episode,omitempty" json:"last_episode,omitempty"`
 	Quality     string             `bson:"quality" json:"quality"`
 	CDNBotIndex int                `bson:"cdn_bot_index" json:"cdn_bot_index"`
+	Source      string             `bson:"source,omitempty" json:"source,omitempty"`
+	FilePath    string             `bson:"file_path,omitempty" json:"file_path,omitempty"`
+	Probe       *MediaProbe        `bson:"probe,omitempty" json:"probe,omitempty"`
+	Release     *ReleaseInfo       `bson:"release,omitempty" json:"release,omitempty"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
+// MediaProbe is what was read from a file's container headers. Files that
+// couldn't be read keep the error. A failed read is tried again after
+// RetryAt, a Unix time, until Attempts runs out and RetryAt is left unset.
+type MediaProbe struct {
+	Container  string       `bson:"container" json:"container"`
+	Width      int          `bson:"width" json:"width"`
+	Height     int          `bson:"height" json:"height"`
+	Duration   int          `bson:"duration" json:"duration"`
+	VideoCodec string       `bson:"video_codec" json:"video_codec"`
+	HDR        string       `bson:"hdr,omitempty" json:"hdr,omitempty"`
+	Audio      []MediaTrack `bson:"audio" json:"audio"`
+	Subtitles  []MediaTrack `bson:"subtitles" json:"subtitles"`
+	Error      string       `bson:"error,omitempty" json:"error,omitempty"`
+	Attempts   int          `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	RetryAt    int64        `bson:"retry_at,omitempty" json:"retry_at,omitempty"`
+	ProbedAt   time.Time    `bson:"probed_at" json:"probed_at"`
+}
+
+// MediaTrack is an audio or subtitle stream inside a file. Number is the
+// container's own track number.
+type MediaTrack struct {
+	Number   int    `bson:"number" json:"number"`
+	Codec    string `bson:"codec" json:"codec"`
+	Language string `bson:"language,omitempty" json:"language,omitempty"`
+	Title    string `bson:"title,omitempty" json:"title,omitempty"`
+	Channels int    `bson:"channels,omitempty" json:"channels,omitempty"`
+	Default  bool   `bson:"default,omitempty" json:"default,omitempty"`
+	Forced   bool   `bson:"forced,omitempty" json:"forced,omitempty"`
+}
+
+// ReleaseInfo is what a file's release name says about it beyond title and
+// episode: where it was ripped from, how it was encoded and who released it.
+type ReleaseInfo struct {
+	Source        string   `bson:"source,omitempty" json:"source,omitempty"`
+	Service       string   `bson:"service,omitempty" json:"service,omitempty"`
+	VideoCodec    string   `bson:"video_codec,omitempty" json:"video_codec,omitempty"`
+	BitDepth      int      `bson:"bit_depth,omitempty" json:"bit_depth,omitempty"`
+	HDR           []string `bson:"hdr,omitempty" json:"hdr,omitempty"`
+	AudioCodec    string   `bson:"audio_codec,omitempty" json:"audio_codec,omitempty"`
+	AudioChannels string   `bson:"audio_channels,omitempty" json:"audio_channels,omitempty"`
+	Languages     []string `bson:"languages,omitempty" json:"languages,omitempty"`
+	MultiAudio    bool     `bson:"multi_audio,omitempty" json:"multi_audio,omitempty"`
+	Edition       string   `bson:"edition,omitempty" json:"edition,omitempty"`
+	Group         string   `bson:"group,omitempty" json:"group,omitempty"`
+}
+
+// NewReleaseInfo keeps the tags of a parsed release name, or returns nil
+// when it had none.
+func NewReleaseInfo(info release.Info) *ReleaseInfo {
+	r := &ReleaseInfo{
+		Source:        info.Source,
+		Service:       info.Service,
+		VideoCodec:    info.VideoCodec,
+		BitDepth:      info.BitDepth,
+		HDR:           info.HDR,
+		AudioCodec:    info.AudioCodec,
+		AudioChannels: info.AudioChannels,
+		Languages:     info.Languages,
+		MultiAudio:    info.MultiAudio,
+		Edition:       info.Edition,
+		Group:         info.Group,
+	}
+	if reflect.ValueOf(*r).IsZero() {
+		return nil
+	}
+	return r
+}
+
+// Codec names the video codec and bit depth, such as "HEVC 10bit".
+func (r *ReleaseInfo) Codec() string {
+	if r == nil {
+		return ""
+	}
+	var parts []string
+	if r.VideoCodec != "" {
+		parts = append(parts, r.VideoCodec)
+	}
+	if r.BitDepth > 0 {
+		parts = append(parts, strconv.Itoa(r.BitDepth)+"bit")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Covers reports whether the file holds the episode, on its own or as part
+// of a range.
+func (m *MediaFile) Covers(episode int) bool {
+	return m.Episode == episode || (m.Episode < episode && m.LastEpisode >= episode)
+}
+
+// Episodes lists every episode number the file holds.
+func (m *MediaFile) Episodes() []int {
+	if m.LastEpisode <= m.Episode {
+		return []int{m.Episode}
+	}
+	episodes := make([]int, 0, m.LastEpisode-m.Episode+1)
+	for e := m.Episode; e <= m.LastEpisode; e++ {
+		episodes = append(episodes, e)
+	}
+	return episodes
+}
+
 type User struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserID    int64              `bson:"user_id" json:"user_id"`
@@ -315,6 +476,136 @@ type Settings struct {
 	UpdatedBy int64              `bson:"updated_by" json:"updated_by"`
 }
 
+// Title is the TMDB metadata for a movie or show in the library, keyed by
+// tmdb_id and media_type like the media files it describes.
+type Title struct {
+	TMDBID        int           `bson:"tmdb_id" json:"tmdb_id"`
+	MediaType     string        `bson:"media_type" json:"media_type"`
+	Title         string        `bson:"title" json:"title"`
+	OriginalTitle string        `bson:"original_title" json:"original_title,omitempty"`
+	Year          int           `bson:"year" json:"year,omitempty"`
+	ReleaseDate   string        `bson:"release_date" json:"release_date,omitempty"`
+	Overview      string        `bson:"overview" json:"overview"`
+	PosterPath    string        `bson:"poster_path" json:"poster_path"`
+	BackdropPath  string        `bson:"backdrop_path" json:"backdrop_path"`
+	Genres        []string      `bson:"genres" json:"genres"`
+	Runtime       int           `bson:"runtime" json:"runtime,omitempty"`
+	IMDbID        string        `bson:"imdb_id" json:"imdb_id,omitempty"`
+	VoteAverage   float64       `bson:"vote_average" json:"vote_average"`
+	Episodes      []EpisodeMeta `bson:"episodes,omitempty" json:"episodes,omitempty"`
+	FetchedAt     time.Time     `bson:"fetched_at" json:"fetched_at"`
+}
+
+type EpisodeMeta struct {
+	Season  int    `bson:"season" json:"season"`
+	Episode int    `bson:"episode" json:"episode"`
+	Name    string `bson:"name" json:"name"`
+	AirDate string `bson:"air_date,omitempty" json:"air_date,omitempty"`
+}
+
+// EpisodeName returns the stored name of an episode, or "".
+func (t *Title) EpisodeName(season, episode int) string {
+	for _, e := range t.Episodes {
+		if e.Season == season && e.Episode == episode {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// HasSeason reports whether episode names for season are stored.
+func (t *Title) HasSeason(season int) bool {
+	for _, e := range t.Episodes {
+		if e.Season == season {
+			return true
+		}
+	}
+	return false
+}
+
+// LibraryGroup summarizes the files stored for one title, along with its
+// stored TMDB metadata. Titles whose metadata has not been fetched yet
+// carry the name saved with the files. Codecs are the probed video codecs,
+// or the ones named in the release for files not probed.
+type LibraryGroup struct {
+	TMDBID       int       `bson:"tmdb_id"`
+	MediaType    string    `bson:"media_type"`
+	Title        string    `bson:"title"`
+	Year         int       `bson:"year"`
+	Overview     string    `bson:"overview"`
+	PosterPath   string    `bson:"poster_path"`
+	BackdropPath string    `bson:"backdrop_path"`
+	Genres       []string  `bson:"genres"`
+	Rating       float64   `bson:"rating"`
+	Runtime      int       `bson:"runtime"`
+	AddedAt      time.Time `bson:"added_at"`
+	Files        int       `bson:"files"`
+	Qualities    []string  `bson:"qualities"`
+	Codecs       []string  `bson:"codecs"`
+}
+
+// LibraryQuery selects one page of the library. Sort is recent, title,
+// rating or year; ties fall back to TMDB ID and media type, so After, the
+// last group of the previous page, marks a stable place to continue from.
+// Text filters ignore case.
+type LibraryQuery struct {
+	MediaType  string
+	Genre      string
+	YearFrom   int
+	YearTo     int
+	Quality    string
+	Codec      string
+	AddedSince time.Time
+	Sort       string
+	After      *LibraryGroup
+	Limit      int
+}
+
+// ParsedName is what a language model read from a file name, cached under
+// the normalized name so the same release is only sent once.
+type ParsedName struct {
+	Key         string    `bson:"key" json:"key"`
+	Model       string    `bson:"model" json:"model"`
+	Title       string    `bson:"title" json:"title"`
+	Year        int       `bson:"year" json:"year"`
+	Season      int       `bson:"season" json:"season"`
+	Episode     int       `bson:"episode" json:"episode"`
+	LastEpisode int       `bson:"last_episode" json:"last_episode"`
+	Quality     string    `bson:"quality" json:"quality"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+}
+
+type TitleKey struct {
+	TMDBID    int    `bson:"tmdb_id" json:"tmdb_id"`
+	MediaType string `bson:"media_type" json:"media_type"`
+}
+
+// PendingMedia is a file the auto-indexer could not match to TMDB with
+// enough confidence. It waits in the review queue until an admin picks one
+// of the candidates or skips it.
+type PendingMedia struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ChatID      int64              `bson:"chat_id" json:"chat_id"`
+	MessageID   int                `bson:"message_id" json:"message_id"`
+	FileID      string             `bson:"file_id" json:"file_id"`
+	FileName    string             `bson:"file_name" json:"file_name"`
+	FileSize    int64              `bson:"file_size" json:"file_size"`
+	Season      int                `bson:"season" json:"season"`
+	Episode     int                `bson:"episode" json:"episode"`
+	LastEpisode int                `bson:"last_episode,omitempty" json:"last_episode,omitempty"`
+	Quality     string             `bson:"quality" json:"quality"`
+	Candidates  []MatchCandidate   `bson:"candidates" json:"candidates"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type MatchCandidate struct {
+	TMDBID     int     `bson:"tmdb_id" json:"tmdb_id"`
+	MediaType  string  `bson:"media_type" json:"media_type"`
+	Title      string  `bson:"title" json:"title"`
+	Year       string  `bson:"year" json:"year"`
+	Confidence float64 `bson:"confidence" json:"confidence"`
+}
+
 type DBStats struct {
 	TotalMovies   int64   `json:"total_movies"`
 	TotalTV       int64   `json:"total_tv"`
@@ -325,7 +616,7 @@ type DBStats struct {
 	FreeSpaceMB   float64 `json:"free_space_mb"`
 }
 
-func (d *DB) AddUser(userID int64, username, firstName, lastName string) error {
+func (d *MongoDB) AddUser(userID int64, username, firstName, lastName string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -349,7 +640,7 @@ func (d *DB) AddUser(userID int64, username, firstName, lastName string) error {
 	return err
 }
 
-func (d *DB) GetStats() (*DBStats, error) {
+func (d *MongoDB) GetStats() (*DBStats, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -441,7 +732,7 @@ func (d *DB) GetStats() (*DBStats, error) {
 }
 
 // Auth Users Management
-func (d *DB) AddAuthUser(userID int64, username, firstName string, addedBy int64) error {
+func (d *MongoDB) AddAuthUser(userID int64, username, firstName string, addedBy int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -462,7 +753,7 @@ func (d *DB) AddAuthUser(userID int64, username, firstName string, addedBy int64
 	return err
 }
 
-func (d *DB) RemoveAuthUser(userID int64) error {
+func (d *MongoDB) RemoveAuthUser(userID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -471,7 +762,7 @@ func (d *DB) RemoveAuthUser(userID int64) error {
 	return err
 }
 
-func (d *DB) IsAuthUser(userID int64) (bool, error) {
+func (d *MongoDB) IsAuthUser(userID int64) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -483,7 +774,7 @@ func (d *DB) IsAuthUser(userID int64) (bool, error) {
 	return count > 0, nil
 }
 
-func (d *DB) GetAllAuthUsers() ([]AuthUser, error) {
+func (d *MongoDB) GetAllAuthUsers() ([]AuthUser, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -502,7 +793,7 @@ func (d *DB) GetAllAuthUsers() ([]AuthUser, error) {
 }
 
 // Settings Management
-func (d *DB) SetSetting(key string, value interface{}, updatedBy int64) error {
+func (d *MongoDB) SetSetting(key string, value interface{}, updatedBy int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -522,7 +813,7 @@ func (d *DB) SetSetting(key string, value interface{}, updatedBy int64) error {
 	return err
 }
 
-func (d *DB) GetSetting(key string) (interface{}, error) {
+func (d *MongoDB) GetSetting(key string) (interface{}, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -539,7 +830,7 @@ func (d *DB) GetSetting(key string) (interface{}, error) {
 	return setting.Value, nil
 }
 
-func (d *DB) GetPublicAccess() (bool, error) {
+func (d *MongoDB) GetPublicAccess() (bool, error) {
 	val, err := d.GetSetting("public_access")
 	if err != nil {
 		return false, err
@@ -553,12 +844,12 @@ func (d *DB) GetPublicAccess() (bool, error) {
 	return false, nil
 }
 
-func (d *DB) SetPublicAccess(enabled bool, updatedBy int64) error {
+func (d *MongoDB) SetPublicAccess(enabled bool, updatedBy int64) error {
 	return d.SetSetting("public_access", enabled, updatedBy)
 }
 
 // Search by title (groups by title for series)
-func (d *DB) SearchByTitle(query string) ([]MediaFile, error) {
+func (d *MongoDB) SearchByTitle(query string) ([]MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -602,7 +893,7 @@ func (d *DB) SearchByTitle(query string) ([]MediaFile, error) {
 }
 
 // Get available seasons for a series
-func (d *DB) GetAvailableSeasons(tmdbID int) ([]int, error) {
+func (d *MongoDB) GetAvailableSeasons(tmdbID int) ([]int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -635,7 +926,7 @@ func (d *DB) GetAvailableSeasons(tmdbID int) ([]int, error) {
 }
 
 // Get available qualities for a specific episode/movie
-func (d *DB) GetAvailableQualities(tmdbID int, mediaType string, season, episode int) ([]string, error) {
+func (d *MongoDB) GetAvailableQualities(tmdbID int, mediaType string, season, episode int) ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -648,7 +939,7 @@ func (d *DB) GetAvailableQualities(tmdbID int, mediaType string, season, episode
 
 	if mediaType == "tv" {
 		filter["season"] = season
-		filter["episode"] = episode
+		matchEpisode(filter, episode)
 	}
 
 	pipeline := []bson.M{
@@ -680,7 +971,7 @@ func (d *DB) GetAvailableQualities(tmdbID int, mediaType string, season, episode
 }
 
 // Get all media by TMDB ID
-func (d *DB) GetMediaByTMDBID(tmdbID int, mediaType string) ([]MediaFile, error) {
+func (d *MongoDB) GetMediaByTMDBID(tmdbID int, mediaType string) ([]MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -691,7 +982,7 @@ func (d *DB) GetMediaByTMDBID(tmdbID int, mediaType string) ([]MediaFile, error)
 		"media_type": mediaType,
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "season", Value: 1}, {Key: "episode", Value: 1}})
+	opts := options.Find().SetSort(bson.D{{Key: "season", Value: 1}, {Key: "episode", Value: 1}, {Key: "file_size", Value: -1}})
 	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
@@ -707,7 +998,7 @@ func (d *DB) GetMediaByTMDBID(tmdbID int, mediaType string) ([]MediaFile, error)
 }
 
 // Get episodes by season
-func (d *DB) GetEpisodesBySeason(tmdbID, season int) ([]MediaFile, error) {
+func (d *MongoDB) GetEpisodesBySeason(tmdbID, season int) ([]MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -719,7 +1010,7 @@ func (d *DB) GetEpisodesBySeason(tmdbID, season int) ([]MediaFile, error) {
 		"season":     season,
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "episode", Value: 1}})
+	opts := options.Find().SetSort(bson.D{{Key: "episode", Value: 1}, {Key: "file_size", Value: -1}})
 	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
@@ -735,7 +1026,7 @@ func (d *DB) GetEpisodesBySeason(tmdbID, season int) ([]MediaFile, error) {
 }
 
 // Get media by specific quality
-func (d *DB) GetMediaByQuality(tmdbID int, mediaType string, season, episode int, quality string) (*MediaFile, error) {
+func (d *MongoDB) GetMediaByQuality(tmdbID int, mediaType string, season, episode int, quality string) (*MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -749,7 +1040,7 @@ func (d *DB) GetMediaByQuality(tmdbID int, mediaType string, season, episode int
 
 	if mediaType == "tv" {
 		filter["season"] = season
-		filter["episode"] = episode
+		matchEpisode(filter, episode)
 	}
 
 	var m MediaFile
@@ -764,7 +1055,75 @@ func (d *DB) GetMediaByQuality(tmdbID int, mediaType string, season, episode int
 	return &m, nil
 }
 
-func (d *DB) GetMediaByChatMessage(chatID int64, messageID int) (*MediaFile, error) {
+func (d *MongoDB) GetMediaByID(id string) (*MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+
+	var m MediaFile
+	err = d.db.Collection("media").FindOne(ctx, bson.M{"_id": objectID}).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// SetMediaProbe stores what was read from a file's headers, along with the
+// quality it showed when that is known.
+func (d *MongoDB) SetMediaProbe(id string, quality string, probe *MediaProbe) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{
+		"probe":      probe,
+		"updated_at": time.Now(),
+	}
+	if quality != "" {
+		set["quality"] = quality
+	}
+
+	_, err = d.db.Collection("media").UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": set})
+	return err
+}
+
+// GetUnprobedMedia returns files whose headers haven't been read yet, or
+// whose last failed read is due to be tried again, newest first.
+func (d *MongoDB) GetUnprobedMedia(limit int) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"probe": bson.M{"$exists": false}},
+		bson.M{"probe.retry_at": bson.M{"$gt": 0, "$lte": time.Now().Unix()}},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := d.db.Collection("media").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []MediaFile
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (d *MongoDB) GetMediaByChatMessage(chatID int64, messageID int) (*MediaFile, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -786,3 +1145,487 @@ func (d *DB) GetMediaByChatMessage(chatID int64, messageID int) (*MediaFile, err
 
 	return &m, nil
 }
+
+func (d *MongoDB) GetLocalMedia(filePath string) (*MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"source":    SourceLocal,
+		"file_path": filePath,
+	}
+
+	var m MediaFile
+	err := d.db.Collection("media").FindOne(ctx, filter).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// AddPendingMedia queues a file for review and returns its ID. Queuing the
+// same message again replaces the earlier entry.
+func (d *MongoDB) AddPendingMedia(p *PendingMedia) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := d.db.Collection("pending_media")
+
+	filter := bson.M{
+		"chat_id":    p.ChatID,
+		"message_id": p.MessageID,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"file_id":      p.FileID,
+			"file_name":    p.FileName,
+			"file_size":    p.FileSize,
+			"season":       p.Season,
+			"episode":      p.Episode,
+			"last_episode": p.LastEpisode,
+			"quality":      p.Quality,
+			"candidates":   p.Candidates,
+		},
+		"$setOnInsert": bson.M{
+			"created_at": time.Now(),
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var saved PendingMedia
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved); err != nil {
+		return "", err
+	}
+	return saved.ID.Hex(), nil
+}
+
+func (d *MongoDB) GetPendingMedia(id string) (*PendingMedia, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+
+	var p PendingMedia
+	err = d.db.Collection("pending_media").FindOne(ctx, bson.M{"_id": objectID}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (d *MongoDB) ListPendingMedia(limit int) ([]PendingMedia, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := d.db.Collection("pending_media").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var pending []PendingMedia
+	if err := cursor.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (d *MongoDB) DeletePendingMedia(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil
+	}
+
+	_, err = d.db.Collection("pending_media").DeleteOne(ctx, bson.M{"_id": objectID})
+	return err
+}
+
+func (d *MongoDB) UpsertTitle(t *Title) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"tmdb_id":    t.TMDBID,
+		"media_type": t.MediaType,
+	}
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := d.db.Collection("titles").ReplaceOne(ctx, filter, t, opts)
+	return err
+}
+
+func (d *MongoDB) GetTitle(tmdbID int, mediaType string) (*Title, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var t Title
+	err := d.db.Collection("titles").FindOne(ctx, bson.M{"tmdb_id": tmdbID, "media_type": mediaType}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// GetTitles loads the stored metadata for keys; titles not fetched yet are
+// missing from the map.
+func (d *MongoDB) GetTitles(keys []TitleKey) (map[TitleKey]*Title, error) {
+	titles := make(map[TitleKey]*Title, len(keys))
+	if len(keys) == 0 {
+		return titles, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	or := make([]bson.M, 0, len(keys))
+	for _, k := range keys {
+		or = append(or, bson.M{"tmdb_id": k.TMDBID, "media_type": k.MediaType})
+	}
+
+	cursor, err := d.db.Collection("titles").Find(ctx, bson.M{"$or": or})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var t Title
+		if err := cursor.Decode(&t); err != nil {
+			return nil, err
+		}
+		titles[TitleKey{t.TMDBID, t.MediaType}] = &t
+	}
+
+	return titles, cursor.Err()
+}
+
+// GetRecentTitles returns the titles that most recently got a new file,
+// newest first.
+func (d *MongoDB) GetRecentTitles(limit int) ([]TitleKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"tmdb_id": bson.M{"$gt": 0}}},
+		{"$group": bson.M{
+			"_id":   bson.M{"tmdb_id": "$tmdb_id", "media_type": "$media_type"},
+			"added": bson.M{"$max": "$created_at"},
+		}},
+		{"$sort": bson.M{"added": -1}},
+		{"$limit": limit},
+	}
+
+	return d.aggregateTitleKeys(ctx, pipeline)
+}
+
+// GetTitlesToRefresh returns titles with files but no stored metadata,
+// followed by titles last fetched before staleBefore, up to limit.
+func (d *MongoDB) GetTitlesToRefresh(staleBefore time.Time, limit int) ([]TitleKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"tmdb_id": bson.M{"$gt": 0}}},
+		{"$group": bson.M{"_id": bson.M{"tmdb_id": "$tmdb_id", "media_type": "$media_type"}}},
+		{"$lookup": bson.M{
+			"from": "titles",
+			"let":  bson.M{"id": "$_id.tmdb_id", "type": "$_id.media_type"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$and": []bson.M{
+					{"$eq": []string{"$tmdb_id", "$$id"}},
+					{"$eq": []string{"$media_type", "$$type"}},
+				}}}},
+				{"$project": bson.M{"_id": 1}},
+			},
+			"as": "stored",
+		}},
+		{"$match": bson.M{"stored": bson.M{"$size": 0}}},
+		{"$limit": limit},
+	}
+
+	keys, err := d.aggregateTitleKeys(ctx, pipeline)
+	if err != nil || len(keys) >= limit {
+		return keys, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "fetched_at", Value: 1}}).
+		SetLimit(int64(limit - len(keys))).
+		SetProjection(bson.M{"tmdb_id": 1, "media_type": 1})
+
+	cursor, err := d.db.Collection("titles").Find(ctx, bson.M{"fetched_at": bson.M{"$lt": staleBefore}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var k TitleKey
+		if err := cursor.Decode(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, cursor.Err()
+}
+
+func (d *MongoDB) aggregateTitleKeys(ctx context.Context, pipeline []bson.M) ([]TitleKey, error) {
+	cursor, err := d.db.Collection("media").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var keys []TitleKey
+	for cursor.Next(ctx) {
+		var result struct {
+			ID TitleKey `bson:"_id"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		keys = append(keys, result.ID)
+	}
+
+	return keys, cursor.Err()
+}
+
+// GetAllTitles returns stored metadata for every title, without episode
+// names.
+func (d *MongoDB) GetAllTitles() ([]Title, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"episodes": 0})
+	cursor, err := d.db.Collection("titles").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var titles []Title
+	if err := cursor.All(ctx, &titles); err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// mongoLibrarySorts gives the field and direction of each library sort.
+var mongoLibrarySorts = map[string]struct {
+	field string
+	order int
+}{
+	"recent": {"added_at", -1},
+	"title":  {"title", 1},
+	"rating": {"rating", -1},
+	"year":   {"year", -1},
+}
+
+// GetLibrary filters, sorts and pages the library in one aggregation. It
+// runs with a case-insensitive collation, which the text filters and the
+// title sort rely on. The total counts every group matching the filters,
+// not only those after the cursor.
+func (d *MongoDB) GetLibrary(q LibraryQuery) ([]LibraryGroup, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	order, ok := mongoLibrarySorts[q.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown library sort %q", q.Sort)
+	}
+
+	files := bson.M{"tmdb_id": bson.M{"$gt": 0}}
+	if q.MediaType != "" {
+		files["media_type"] = q.MediaType
+	}
+
+	filter := bson.M{}
+	if q.Genre != "" {
+		filter["genres"] = q.Genre
+	}
+	if q.YearFrom > 0 || q.YearTo > 0 {
+		years := bson.M{}
+		if q.YearFrom > 0 {
+			years["$gte"] = q.YearFrom
+		}
+		if q.YearTo > 0 {
+			years["$gt"] = 0
+			years["$lte"] = q.YearTo
+		}
+		filter["year"] = years
+	}
+	if q.Quality != "" {
+		filter["qualities"] = q.Quality
+	}
+	if q.Codec != "" {
+		filter["codecs"] = q.Codec
+	}
+	if !q.AddedSince.IsZero() {
+		filter["added_at"] = bson.M{"$gte": q.AddedSince}
+	}
+
+	page := bson.A{}
+	if a := q.After; a != nil {
+		var value any
+		switch q.Sort {
+		case "recent":
+			value = a.AddedAt
+		case "title":
+			value = a.Title
+		case "rating":
+			value = a.Rating
+		case "year":
+			value = a.Year
+		}
+		op := "$gt"
+		if order.order < 0 {
+			op = "$lt"
+		}
+		page = append(page, bson.M{"$match": bson.M{"$or": bson.A{
+			bson.M{order.field: bson.M{op: value}},
+			bson.M{order.field: value, "tmdb_id": bson.M{"$gt": a.TMDBID}},
+			bson.M{order.field: value, "tmdb_id": a.TMDBID, "media_type": bson.M{"$gt": a.MediaType}},
+		}}})
+	}
+	page = append(page,
+		bson.M{"$sort": bson.D{{Key: order.field, Value: order.order}, {Key: "tmdb_id", Value: 1}, {Key: "media_type", Value: 1}}},
+		bson.M{"$limit": q.Limit},
+	)
+
+	// A file's codec is its probed one, or the one its release name gives.
+	pipeline := bson.A{
+		bson.M{"$match": files},
+		bson.M{"$group": bson.M{
+			"_id":       bson.M{"tmdb_id": "$tmdb_id", "media_type": "$media_type"},
+			"title":     bson.M{"$first": "$title"},
+			"added_at":  bson.M{"$max": "$created_at"},
+			"files":     bson.M{"$sum": 1},
+			"qualities": bson.M{"$addToSet": "$quality"},
+			"codecs": bson.M{"$addToSet": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$probe.video_codec", ""}}, "$probe.video_codec", "$release.video_codec",
+			}}},
+		}},
+		bson.M{"$lookup": bson.M{
+			"from": "titles",
+			"let":  bson.M{"tmdb_id": "$_id.tmdb_id", "media_type": "$_id.media_type"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$tmdb_id", "$$tmdb_id"}},
+					bson.M{"$eq": bson.A{"$media_type", "$$media_type"}},
+				}}}},
+				bson.M{"$project": bson.M{"episodes": 0}},
+			},
+			"as": "meta",
+		}},
+		bson.M{"$addFields": bson.M{"meta": bson.M{"$arrayElemAt": bson.A{"$meta", 0}}}},
+		bson.M{"$addFields": bson.M{
+			"tmdb_id":       "$_id.tmdb_id",
+			"media_type":    "$_id.media_type",
+			"title":         bson.M{"$ifNull": bson.A{"$meta.title", "$title"}},
+			"year":          bson.M{"$ifNull": bson.A{"$meta.year", 0}},
+			"overview":      bson.M{"$ifNull": bson.A{"$meta.overview", ""}},
+			"poster_path":   bson.M{"$ifNull": bson.A{"$meta.poster_path", ""}},
+			"backdrop_path": bson.M{"$ifNull": bson.A{"$meta.backdrop_path", ""}},
+			"genres":        bson.M{"$ifNull": bson.A{"$meta.genres", bson.A{}}},
+			"rating":        bson.M{"$ifNull": bson.A{"$meta.vote_average", 0}},
+			"runtime":       bson.M{"$ifNull": bson.A{"$meta.runtime", 0}},
+		}},
+		bson.M{"$match": filter},
+		bson.M{"$facet": bson.M{
+			"total":  bson.A{bson.M{"$count": "n"}},
+			"groups": page,
+		}},
+	}
+
+	opts := options.Aggregate().SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	cursor, err := d.db.Collection("media").Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total []struct {
+			N int `bson:"n"`
+		} `bson:"total"`
+		Groups []LibraryGroup `bson:"groups"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+	if len(result) == 0 || len(result[0].Total) == 0 {
+		return nil, 0, nil
+	}
+	return result[0].Groups, result[0].Total[0].N, nil
+}
+
+// GetParsedNames returns the cached model output for each key that has one.
+func (d *MongoDB) GetParsedNames(keys []string) (map[string]ParsedName, error) {
+	parsed := make(map[string]ParsedName, len(keys))
+	if len(keys) == 0 {
+		return parsed, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := d.db.Collection("parsed_names").Find(ctx, bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var p ParsedName
+		if err := cursor.Decode(&p); err != nil {
+			return nil, err
+		}
+		parsed[p.Key] = p
+	}
+	return parsed, cursor.Err()
+}
+
+func (d *MongoDB) SaveParsedNames(names []ParsedName) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(names))
+	for _, p := range names {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"key": p.Key}).
+			SetReplacement(p).
+			SetUpsert(true))
+	}
+
+	_, err := d.db.Collection("parsed_names").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
@@ -0,0 +1,385 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"strix/release"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one numbered schema change. Run must be idempotent so a
+// step interrupted halfway can simply be run again. With dryRun set it
+// only reports what it would change.
+type Migration struct {
+	Version     int
+	Description string
+	Run         func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error)
+}
+
+type MigrationResult struct {
+	Version     int
+	Description string
+	Summary     string
+	// AlreadyApplied is set for steps recorded by an earlier run.
+	AlreadyApplied bool
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	Summary     string    `bson:"summary"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrations are applied in order and never renumbered; new steps go at
+// the end.
+var mongoMigrations = []Migration{
+	{1, "create initial indexes", createInitialIndexes},
+	{2, "replace unique episode index to allow multiple versions", replaceEpisodeIndex},
+	{3, "strip quality suffix from titles", stripTitleQualitySuffix},
+	{4, "remove stray text search score field", unsetScoreField},
+	{5, "index auto-index review queue", createPendingMediaIndexes},
+	{6, "index stored TMDB titles", createTitleIndexes},
+	{7, "index cached filename parses", createParsedNameIndexes},
+	{8, "parse release tags from file names", parseReleaseTags},
+}
+
+// Migrate applies every migration not yet recorded in schema_migrations.
+func (d *MongoDB) Migrate(dryRun bool) ([]MigrationResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	collection := d.db.Collection("schema_migrations")
+
+	if !dryRun {
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "version", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var applied []appliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	var results []MigrationResult
+	for _, m := range mongoMigrations {
+		result := MigrationResult{Version: m.Version, Description: m.Description}
+		if done[m.Version] {
+			result.AlreadyApplied = true
+			results = append(results, result)
+			continue
+		}
+
+		summary, err := m.Run(ctx, d.db, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		result.Summary = summary
+		results = append(results, result)
+
+		if dryRun {
+			continue
+		}
+
+		_, err = collection.InsertOne(ctx, appliedMigration{
+			Version:     m.Version,
+			Description: m.Description,
+			Summary:     summary,
+			AppliedAt:   time.Now(),
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return results, err
+		}
+		log.Printf("[MIGRATE] %d %s: %s", m.Version, m.Description, summary)
+	}
+
+	return results, nil
+}
+
+func createInitialIndexes(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	indexes := []struct {
+		collection string
+		models     []mongo.IndexModel
+	}{
+		{"media", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "tmdb_id", Value: 1}}},
+			{Keys: bson.D{{Key: "media_type", Value: 1}}},
+			{Keys: bson.D{{Key: "file_id", Value: 1}}},
+			{Keys: bson.D{{Key: "title", Value: 1}}},
+			{Keys: bson.D{{Key: "file_name", Value: 1}}},
+			{Keys: bson.D{{Key: "title", Value: "text"}, {Key: "file_name", Value: "text"}}},
+		}},
+		{"users", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		}},
+		{"auth_users", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		}},
+		{"settings", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		}},
+	}
+
+	total := 0
+	for _, index := range indexes {
+		total += len(index.models)
+		if dryRun {
+			continue
+		}
+		if _, err := db.Collection(index.collection).Indexes().CreateMany(ctx, index.models); err != nil {
+			return "", err
+		}
+	}
+
+	if dryRun {
+		return fmt.Sprintf("would ensure %d indexes", total), nil
+	}
+	return fmt.Sprintf("ensured %d indexes", total), nil
+}
+
+// episodeIndexName is the index that used to make every TMDB item hold a
+// single file. The non-unique replacement has the same name, so the old
+// one has to be dropped first.
+const episodeIndexName = "tmdb_id_1_media_type_1_season_1_episode_1"
+
+func replaceEpisodeIndex(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	indexes := db.Collection("media").Indexes()
+
+	cursor, err := indexes.List(ctx)
+	if err != nil {
+		return "", err
+	}
+	var existing []struct {
+		Name   string `bson:"name"`
+		Unique bool   `bson:"unique"`
+	}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return "", err
+	}
+
+	dropped := false
+	for _, index := range existing {
+		if index.Name != episodeIndexName || !index.Unique {
+			continue
+		}
+		if dryRun {
+			return "would drop unique index " + episodeIndexName + " and create version indexes", nil
+		}
+		if _, err := indexes.DropOne(ctx, episodeIndexName); err != nil {
+			return "", err
+		}
+		dropped = true
+	}
+
+	if dryRun {
+		return "would create version indexes", nil
+	}
+
+	_, err = indexes.CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{
+			{Key: "tmdb_id", Value: 1},
+			{Key: "media_type", Value: 1},
+			{Key: "season", Value: 1},
+			{Key: "episode", Value: 1},
+		}},
+		{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "message_id", Value: 1}}},
+		{Keys: bson.D{{Key: "file_path", Value: 1}}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if dropped {
+		return "dropped unique index " + episodeIndexName + " and created version indexes", nil
+	}
+	return "created version indexes", nil
+}
+
+// stripTitleQualitySuffix removes the " [1080p]" suffix that forwarded
+// files used to get appended to their title. Only a suffix matching the
+// record's own quality is removed, so titles that really end in brackets
+// are left alone.
+func stripTitleQualitySuffix(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	collection := db.Collection("media")
+
+	filter := bson.M{"title": bson.M{"$regex": `\s\[[^\]]+\]$`}}
+	opts := options.Find().SetProjection(bson.M{"title": 1, "quality": 1})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID      any    `bson:"_id"`
+			Title   string `bson:"title"`
+			Quality string `bson:"quality"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return "", err
+		}
+
+		suffix := " [" + doc.Quality + "]"
+		if doc.Quality == "" || !strings.HasSuffix(doc.Title, suffix) {
+			continue
+		}
+		count++
+
+		if dryRun {
+			continue
+		}
+		title := strings.TrimSuffix(doc.Title, suffix)
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"title": title}}); err != nil {
+			return "", err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		return fmt.Sprintf("would update %d titles", count), nil
+	}
+	return fmt.Sprintf("updated %d titles", count), nil
+}
+
+func unsetScoreField(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	collection := db.Collection("media")
+	filter := bson.M{"score": bson.M{"$exists": true}}
+
+	if dryRun {
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("would clean %d records", count), nil
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"score": ""}})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cleaned %d records", result.ModifiedCount), nil
+}
+
+func createPendingMediaIndexes(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	if dryRun {
+		return "would ensure 2 indexes", nil
+	}
+
+	_, err := db.Collection("pending_media").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "chat_id", Value: 1}, {Key: "message_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return "", err
+	}
+	return "ensured 2 indexes", nil
+}
+
+func createTitleIndexes(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	if dryRun {
+		return "would ensure 2 indexes", nil
+	}
+
+	_, err := db.Collection("titles").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tmdb_id", Value: 1}, {Key: "media_type", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "fetched_at", Value: 1}}},
+	})
+	if err != nil {
+		return "", err
+	}
+	return "ensured 2 indexes", nil
+}
+
+func createParsedNameIndexes(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	if dryRun {
+		return "would ensure 1 index", nil
+	}
+
+	_, err := db.Collection("parsed_names").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return "ensured 1 index", nil
+}
+
+// parseReleaseTags reads the source, codec, audio and group tags from the
+// names of files indexed before those were stored.
+func parseReleaseTags(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	collection := db.Collection("media")
+
+	filter := bson.M{"release": bson.M{"$exists": false}}
+	opts := options.Find().SetProjection(bson.M{"file_name": 1})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID       any    `bson:"_id"`
+			FileName string `bson:"file_name"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return "", err
+		}
+
+		tags := NewReleaseInfo(release.Parse(doc.FileName))
+		if tags == nil {
+			continue
+		}
+		count++
+
+		if dryRun {
+			continue
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"release": tags}}); err != nil {
+			return "", err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		return fmt.Sprintf("would tag %d files", count), nil
+	}
+	return fmt.Sprintf("tagged %d files", count), nil
+}
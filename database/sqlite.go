@@ -0,0 +1,1407 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"strix/release"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SQLiteDB stores everything in a single SQLite file. Record IDs are
+// ObjectID hex strings so IDs look the same as with MongoDB.
+type SQLiteDB struct {
+	db *sql.DB
+}
+
+// mediaTable is formatted with the table name so migrations can build a
+// replacement table with the current definition.
+const mediaTable = `
+CREATE TABLE IF NOT EXISTS %s (
+	id            TEXT PRIMARY KEY,
+	tmdb_id       INTEGER NOT NULL,
+	media_type    TEXT NOT NULL,
+	title         TEXT NOT NULL DEFAULT '',
+	file_id       TEXT NOT NULL DEFAULT '',
+	message_id    INTEGER NOT NULL DEFAULT 0,
+	chat_id       INTEGER NOT NULL DEFAULT 0,
+	file_size     INTEGER NOT NULL DEFAULT 0,
+	file_name     TEXT NOT NULL DEFAULT '',
+	season        INTEGER NOT NULL DEFAULT 0,
+	episode       INTEGER NOT NULL DEFAULT 0,
+	last_episode  INTEGER NOT NULL DEFAULT 0,
+	quality       TEXT NOT NULL DEFAULT '',
+	cdn_bot_index INTEGER NOT NULL DEFAULT 0,
+	source        TEXT NOT NULL DEFAULT '',
+	file_path     TEXT NOT NULL DEFAULT '',
+	probe         TEXT NOT NULL DEFAULT '',
+	release_info  TEXT NOT NULL DEFAULT '',
+	created_at    TIMESTAMP NOT NULL,
+	updated_at    TIMESTAMP NOT NULL
+);
+`
+
+const sqliteSchema = `
+CREATE INDEX IF NOT EXISTS media_episode ON media (tmdb_id, media_type, season, episode);
+CREATE UNIQUE INDEX IF NOT EXISTS media_message ON media (chat_id, message_id) WHERE source = '';
+CREATE UNIQUE INDEX IF NOT EXISTS media_local_path ON media (file_path) WHERE source = 'local';
+CREATE INDEX IF NOT EXISTS media_title ON media (title);
+CREATE INDEX IF NOT EXISTS media_file_name ON media (file_name);
+CREATE INDEX IF NOT EXISTS media_file_id ON media (file_id);
+CREATE INDEX IF NOT EXISTS media_created_at ON media (created_at);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS media_fts USING fts4(content="media", title, file_name, tokenize=porter);
+
+CREATE TRIGGER IF NOT EXISTS media_fts_bu BEFORE UPDATE ON media BEGIN
+	DELETE FROM media_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER IF NOT EXISTS media_fts_bd BEFORE DELETE ON media BEGIN
+	DELETE FROM media_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER IF NOT EXISTS media_fts_au AFTER UPDATE ON media BEGIN
+	INSERT INTO media_fts (docid, title, file_name) VALUES (new.rowid, new.title, new.file_name);
+END;
+CREATE TRIGGER IF NOT EXISTS media_fts_ai AFTER INSERT ON media BEGIN
+	INSERT INTO media_fts (docid, title, file_name) VALUES (new.rowid, new.title, new.file_name);
+END;
+
+CREATE TABLE IF NOT EXISTS users (
+	id         TEXT PRIMARY KEY,
+	user_id    INTEGER NOT NULL UNIQUE,
+	username   TEXT NOT NULL DEFAULT '',
+	first_name TEXT NOT NULL DEFAULT '',
+	last_name  TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS auth_users (
+	id         TEXT PRIMARY KEY,
+	user_id    INTEGER NOT NULL UNIQUE,
+	username   TEXT NOT NULL DEFAULT '',
+	first_name TEXT NOT NULL DEFAULT '',
+	added_by   INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pending_media (
+	id           TEXT PRIMARY KEY,
+	chat_id      INTEGER NOT NULL,
+	message_id   INTEGER NOT NULL,
+	file_id      TEXT NOT NULL DEFAULT '',
+	file_name    TEXT NOT NULL DEFAULT '',
+	file_size    INTEGER NOT NULL DEFAULT 0,
+	season       INTEGER NOT NULL DEFAULT 0,
+	episode      INTEGER NOT NULL DEFAULT 0,
+	last_episode INTEGER NOT NULL DEFAULT 0,
+	quality      TEXT NOT NULL DEFAULT '',
+	candidates   TEXT NOT NULL DEFAULT '[]',
+	created_at   TIMESTAMP NOT NULL,
+	UNIQUE (chat_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS titles (
+	tmdb_id        INTEGER NOT NULL,
+	media_type     TEXT NOT NULL,
+	title          TEXT NOT NULL DEFAULT '',
+	original_title TEXT NOT NULL DEFAULT '',
+	year           INTEGER NOT NULL DEFAULT 0,
+	release_date   TEXT NOT NULL DEFAULT '',
+	overview       TEXT NOT NULL DEFAULT '',
+	poster_path    TEXT NOT NULL DEFAULT '',
+	backdrop_path  TEXT NOT NULL DEFAULT '',
+	genres         TEXT NOT NULL DEFAULT '[]',
+	runtime        INTEGER NOT NULL DEFAULT 0,
+	imdb_id        TEXT NOT NULL DEFAULT '',
+	vote_average   REAL NOT NULL DEFAULT 0,
+	episodes       TEXT NOT NULL DEFAULT '[]',
+	fetched_at     TIMESTAMP NOT NULL,
+	PRIMARY KEY (tmdb_id, media_type)
+);
+CREATE INDEX IF NOT EXISTS titles_fetched_at ON titles (fetched_at);
+
+CREATE TABLE IF NOT EXISTS parsed_names (
+	key          TEXT PRIMARY KEY,
+	model        TEXT NOT NULL DEFAULT '',
+	title        TEXT NOT NULL DEFAULT '',
+	year         INTEGER NOT NULL DEFAULT 0,
+	season       INTEGER NOT NULL DEFAULT 0,
+	episode      INTEGER NOT NULL DEFAULT 0,
+	last_episode INTEGER NOT NULL DEFAULT 0,
+	quality      TEXT NOT NULL DEFAULT '',
+	created_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+	id         TEXT PRIMARY KEY,
+	key        TEXT NOT NULL UNIQUE,
+	value      TEXT,
+	updated_at TIMESTAMP NOT NULL,
+	updated_by INTEGER NOT NULL DEFAULT 0
+);
+`
+
+const mediaColumns = `id, tmdb_id, media_type, title, file_id, message_id, chat_id, file_size, file_name,
+	season, episode, last_episode, quality, cdn_bot_index, source, file_path, probe, release_info, created_at, updated_at`
+
+func InitSQLite(path string) (*SQLiteDB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	d := &SQLiteDB{db: db}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(mediaTable, "media")+sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	log.Printf("SQLite opened: %s", path)
+	return d, nil
+}
+
+func (d *SQLiteDB) Close() error {
+	return d.db.Close()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMedia(row rowScanner) (*MediaFile, error) {
+	var m MediaFile
+	var id, probe, release string
+	err := row.Scan(&id, &m.TMDBID, &m.MediaType, &m.Title, &m.FileID, &m.MessageID, &m.ChatID, &m.FileSize, &m.FileName,
+		&m.Season, &m.Episode, &m.LastEpisode, &m.Quality, &m.CDNBotIndex, &m.Source, &m.FilePath, &probe, &release, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.ID, _ = primitive.ObjectIDFromHex(id)
+	if err := decodeJSONColumns(&m, probe, release); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// decodeJSONColumns reads the probe and release_info columns, which are
+// empty for files not probed yet and names without release tags.
+func decodeJSONColumns(m *MediaFile, probe, release string) error {
+	if probe != "" {
+		if err := json.Unmarshal([]byte(probe), &m.Probe); err != nil {
+			return err
+		}
+	}
+	if release != "" {
+		return json.Unmarshal([]byte(release), &m.Release)
+	}
+	return nil
+}
+
+// encodeRelease is the release_info column for a file's release tags.
+func encodeRelease(release *ReleaseInfo) (string, error) {
+	if release == nil {
+		return "", nil
+	}
+	encoded, err := json.Marshal(release)
+	return string(encoded), err
+}
+
+func (d *SQLiteDB) queryMedia(ctx context.Context, query string, args ...any) ([]MediaFile, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []MediaFile
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *m)
+	}
+	return results, rows.Err()
+}
+
+func (d *SQLiteDB) queryOneMedia(ctx context.Context, query string, args ...any) (*MediaFile, error) {
+	m, err := scanMedia(d.db.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+const upsertMessageMedia = `
+	INSERT INTO media (id, tmdb_id, media_type, title, file_id, message_id, chat_id, file_size, file_name,
+		season, episode, last_episode, quality, cdn_bot_index, source, file_path, release_info, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '', '', ?, ?, ?)
+	ON CONFLICT (chat_id, message_id) WHERE source = '' DO UPDATE SET
+		tmdb_id = excluded.tmdb_id, media_type = excluded.media_type, season = excluded.season,
+		episode = excluded.episode, last_episode = excluded.last_episode, title = excluded.title, file_id = excluded.file_id,
+		file_size = excluded.file_size, file_name = excluded.file_name, quality = excluded.quality,
+		cdn_bot_index = excluded.cdn_bot_index, release_info = excluded.release_info, updated_at = excluded.updated_at`
+
+func (d *SQLiteDB) AddMedia(tmdbID int, mediaType, title, fileID string, messageID int, chatID int64, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, cdnBotIndex int, release *ReleaseInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	encoded, err := encodeRelease(release)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = d.db.ExecContext(ctx, upsertMessageMedia,
+		primitive.NewObjectID().Hex(), tmdbID, mediaType, title, fileID, messageID, chatID, fileSize, fileName,
+		season, episode, lastEpisode, quality, cdnBotIndex, encoded, now, now)
+	return err
+}
+
+func (d *SQLiteDB) AddMediaBatch(files []MediaFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, upsertMessageMedia)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, f := range files {
+		encoded, err := encodeRelease(f.Release)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx,
+			primitive.NewObjectID().Hex(), f.TMDBID, f.MediaType, f.Title, f.FileID, f.MessageID, f.ChatID, f.FileSize, f.FileName,
+			f.Season, f.Episode, f.LastEpisode, f.Quality, f.CDNBotIndex, encoded, now, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *SQLiteDB) AddLocalMedia(tmdbID int, mediaType, title, filePath string, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, release *ReleaseInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	encoded, err := encodeRelease(release)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO media (id, tmdb_id, media_type, title, file_size, file_name,
+			season, episode, last_episode, quality, source, file_path, release_info, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (file_path) WHERE source = 'local' DO UPDATE SET
+			tmdb_id = excluded.tmdb_id, media_type = excluded.media_type, season = excluded.season,
+			episode = excluded.episode, last_episode = excluded.last_episode, title = excluded.title, file_size = excluded.file_size,
+			file_name = excluded.file_name, quality = excluded.quality, release_info = excluded.release_info,
+			updated_at = excluded.updated_at`,
+		primitive.NewObjectID().Hex(), tmdbID, mediaType, title, fileSize, fileName,
+		season, episode, lastEpisode, quality, SourceLocal, filePath, encoded, now, now)
+	return err
+}
+
+// coversEpisode matches files holding the episode, on its own or as part of
+// a multi-episode file. It takes the episode number three times.
+const coversEpisode = `(episode = ? OR (episode < ? AND last_episode >= ?))`
+
+func (d *SQLiteDB) GetMediaByTMDB(tmdbID int, mediaType string, season, episode int) (*MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.queryOneMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		WHERE tmdb_id = ? AND media_type = ? AND season = ? AND `+coversEpisode,
+		tmdbID, mediaType, season, episode, episode, episode)
+}
+
+func (d *SQLiteDB) GetMediaVersions(tmdbID int, mediaType string, season, episode int) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	versions, err := d.queryMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		WHERE tmdb_id = ? AND media_type = ? AND season = ? AND `+coversEpisode,
+		tmdbID, mediaType, season, episode, episode, episode)
+	if err != nil {
+		return nil, err
+	}
+
+	SortVersions(versions)
+	return versions, nil
+}
+
+func (d *SQLiteDB) GetSeasonEpisodes(tmdbID int, season int) ([]MediaFile, error) {
+	return d.GetEpisodesBySeason(tmdbID, season)
+}
+
+// ftsQuery turns free text into an FTS4 query matching any of its words,
+// which is how MongoDB's $text treats a plain query.
+func ftsQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, `"`+w+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// textSearch runs a full-text query and orders results by how many
+// distinct query terms matched, then by the total number of matches.
+func (d *SQLiteDB) textSearch(ctx context.Context, query string) ([]MediaFile, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	rows, err := d.db.QueryContext(ctx, `SELECT `+mediaColumns+`, hits.offsets FROM media
+		JOIN (SELECT docid, offsets(media_fts) AS offsets FROM media_fts WHERE media_fts MATCH ?) AS hits
+		ON hits.docid = media.rowid`, match)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type scored struct {
+		media MediaFile
+		terms int
+		hits  int
+	}
+
+	var results []scored
+	for rows.Next() {
+		var m MediaFile
+		var id, probe, release, offsets string
+		err := rows.Scan(&id, &m.TMDBID, &m.MediaType, &m.Title, &m.FileID, &m.MessageID, &m.ChatID, &m.FileSize, &m.FileName,
+			&m.Season, &m.Episode, &m.LastEpisode, &m.Quality, &m.CDNBotIndex, &m.Source, &m.FilePath, &probe, &release, &m.CreatedAt, &m.UpdatedAt, &offsets)
+		if err != nil {
+			return nil, err
+		}
+		m.ID, _ = primitive.ObjectIDFromHex(id)
+		if err := decodeJSONColumns(&m, probe, release); err != nil {
+			return nil, err
+		}
+
+		// offsets() yields "column term byte size" per match.
+		fields := strings.Fields(offsets)
+		terms := make(map[string]bool)
+		for i := 1; i < len(fields); i += 4 {
+			terms[fields[i]] = true
+		}
+		results = append(results, scored{media: m, terms: len(terms), hits: len(fields) / 4})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].terms != results[j].terms {
+			return results[i].terms > results[j].terms
+		}
+		return results[i].hits > results[j].hits
+	})
+
+	if len(results) > 100 {
+		results = results[:100]
+	}
+
+	media := make([]MediaFile, len(results))
+	for i, r := range results {
+		media[i] = r.media
+	}
+	return media, nil
+}
+
+func (d *SQLiteDB) SearchMedia(query string) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.textSearch(ctx, query)
+}
+
+func (d *SQLiteDB) SearchByTitle(query string) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	log.Printf("[SEARCH] Searching by title: %s", query)
+
+	results, err := d.textSearch(ctx, query)
+	if err != nil {
+		log.Printf("[SEARCH] Error executing query: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[SEARCH] Found %d results for query: %s", len(results), query)
+	return results, nil
+}
+
+func (d *SQLiteDB) GetAllMedia(limit, offset int) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.queryMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		ORDER BY created_at DESC LIMIT ? OFFSET ?`, limit, offset)
+}
+
+func (d *SQLiteDB) GetAvailableSeasons(tmdbID int) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT DISTINCT season FROM media
+		WHERE tmdb_id = ? AND media_type = 'tv' ORDER BY season`, tmdbID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seasons []int
+	for rows.Next() {
+		var season int
+		if err := rows.Scan(&season); err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, season)
+	}
+	return seasons, rows.Err()
+}
+
+func (d *SQLiteDB) GetAvailableQualities(tmdbID int, mediaType string, season, episode int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT DISTINCT quality FROM media WHERE tmdb_id = ? AND media_type = ? AND quality != ''`
+	args := []any{tmdbID, mediaType}
+	if mediaType == "tv" {
+		query += ` AND season = ? AND ` + coversEpisode
+		args = append(args, season, episode, episode, episode)
+	}
+	query += ` ORDER BY quality DESC`
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var qualities []string
+	for rows.Next() {
+		var quality string
+		if err := rows.Scan(&quality); err != nil {
+			return nil, err
+		}
+		qualities = append(qualities, quality)
+	}
+	return qualities, rows.Err()
+}
+
+func (d *SQLiteDB) GetMediaByTMDBID(tmdbID int, mediaType string) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.queryMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		WHERE tmdb_id = ? AND media_type = ? ORDER BY season, episode, file_size DESC`, tmdbID, mediaType)
+}
+
+func (d *SQLiteDB) GetEpisodesBySeason(tmdbID, season int) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.queryMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		WHERE tmdb_id = ? AND media_type = 'tv' AND season = ? ORDER BY episode, file_size DESC`, tmdbID, season)
+}
+
+func (d *SQLiteDB) GetMediaByQuality(tmdbID int, mediaType string, season, episode int, quality string) (*MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + mediaColumns + ` FROM media WHERE tmdb_id = ? AND media_type = ? AND quality = ?`
+	args := []any{tmdbID, mediaType, quality}
+	if mediaType == "tv" {
+		query += ` AND season = ? AND ` + coversEpisode
+		args = append(args, season, episode, episode, episode)
+	}
+
+	return d.queryOneMedia(ctx, query+` LIMIT 1`, args...)
+}
+
+func (d *SQLiteDB) GetMediaByID(id string) (*MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, nil
+	}
+
+	return d.queryOneMedia(ctx, `SELECT `+mediaColumns+` FROM media WHERE id = ?`, id)
+}
+
+func (d *SQLiteDB) SetMediaProbe(id string, quality string, probe *MediaProbe) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	encoded, err := json.Marshal(probe)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx, `UPDATE media SET probe = ?, quality = COALESCE(NULLIF(?, ''), quality), updated_at = ? WHERE id = ?`,
+		string(encoded), quality, time.Now(), id)
+	return err
+}
+
+func (d *SQLiteDB) GetUnprobedMedia(limit int) ([]MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return d.queryMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		WHERE probe = '' OR json_extract(probe, '$.retry_at') BETWEEN 1 AND ?
+		ORDER BY created_at DESC LIMIT ?`, time.Now().Unix(), limit)
+}
+
+func (d *SQLiteDB) GetMediaByChatMessage(chatID int64, messageID int) (*MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.queryOneMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		WHERE chat_id = ? AND message_id = ? LIMIT 1`, chatID, messageID)
+}
+
+func (d *SQLiteDB) GetLocalMedia(filePath string) (*MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.queryOneMedia(ctx, `SELECT `+mediaColumns+` FROM media
+		WHERE source = ? AND file_path = ?`, SourceLocal, filePath)
+}
+
+func (d *SQLiteDB) AddUser(userID int64, username, firstName, lastName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO users (id, user_id, username, first_name, last_name, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			username = excluded.username, first_name = excluded.first_name,
+			last_name = excluded.last_name, updated_at = excluded.updated_at`,
+		primitive.NewObjectID().Hex(), userID, username, firstName, lastName, now, now)
+	return err
+}
+
+func (d *SQLiteDB) GetStats() (*DBStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stats := &DBStats{}
+
+	err := d.db.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM media),
+		(SELECT COUNT(DISTINCT tmdb_id) FROM media WHERE media_type = 'movie'),
+		(SELECT COUNT(DISTINCT tmdb_id) FROM media WHERE media_type = 'tv'),
+		(SELECT COUNT(*) FROM users)`).
+		Scan(&stats.TotalFiles, &stats.TotalMovies, &stats.TotalTV, &stats.TotalUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	var pageSize, pageCount, freePages int64
+	if err := d.db.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return stats, nil
+	}
+	if err := d.db.QueryRowContext(ctx, `PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return stats, nil
+	}
+	if err := d.db.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&freePages); err != nil {
+		return stats, nil
+	}
+
+	// There is no quota like on a hosted MongoDB, so free space is the
+	// unused pages inside the file that a VACUUM would reclaim.
+	stats.StorageSizeMB = float64(pageCount*pageSize) / (1024 * 1024)
+	stats.DBSizeMB = float64((pageCount-freePages)*pageSize) / (1024 * 1024)
+	stats.FreeSpaceMB = float64(freePages*pageSize) / (1024 * 1024)
+
+	return stats, nil
+}
+
+func (d *SQLiteDB) AddAuthUser(userID int64, username, firstName string, addedBy int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO auth_users (id, user_id, username, first_name, added_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO NOTHING`,
+		primitive.NewObjectID().Hex(), userID, username, firstName, addedBy, time.Now())
+	return err
+}
+
+func (d *SQLiteDB) RemoveAuthUser(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `DELETE FROM auth_users WHERE user_id = ?`, userID)
+	return err
+}
+
+func (d *SQLiteDB) IsAuthUser(userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := d.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth_users WHERE user_id = ?)`, userID).Scan(&exists)
+	return exists, err
+}
+
+func (d *SQLiteDB) GetAllAuthUsers() ([]AuthUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT id, user_id, username, first_name, added_by, created_at FROM auth_users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var authUsers []AuthUser
+	for rows.Next() {
+		var u AuthUser
+		var id string
+		if err := rows.Scan(&id, &u.UserID, &u.Username, &u.FirstName, &u.AddedBy, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		u.ID, _ = primitive.ObjectIDFromHex(id)
+		authUsers = append(authUsers, u)
+	}
+	return authUsers, rows.Err()
+}
+
+func (d *SQLiteDB) AddPendingMedia(p *PendingMedia) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	candidates, err := json.Marshal(p.Candidates)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = d.db.QueryRowContext(ctx, `
+		INSERT INTO pending_media (id, chat_id, message_id, file_id, file_name, file_size,
+			season, episode, last_episode, quality, candidates, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id, message_id) DO UPDATE SET
+			file_id = excluded.file_id, file_name = excluded.file_name, file_size = excluded.file_size,
+			season = excluded.season, episode = excluded.episode, last_episode = excluded.last_episode, quality = excluded.quality,
+			candidates = excluded.candidates
+		RETURNING id`,
+		primitive.NewObjectID().Hex(), p.ChatID, p.MessageID, p.FileID, p.FileName, p.FileSize,
+		p.Season, p.Episode, p.LastEpisode, p.Quality, string(candidates), time.Now()).Scan(&id)
+	return id, err
+}
+
+const pendingColumns = `id, chat_id, message_id, file_id, file_name, file_size, season, episode, last_episode, quality, candidates, created_at`
+
+func scanPendingMedia(row rowScanner) (*PendingMedia, error) {
+	var p PendingMedia
+	var id, candidates string
+	err := row.Scan(&id, &p.ChatID, &p.MessageID, &p.FileID, &p.FileName, &p.FileSize,
+		&p.Season, &p.Episode, &p.LastEpisode, &p.Quality, &candidates, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.ID, _ = primitive.ObjectIDFromHex(id)
+	if err := json.Unmarshal([]byte(candidates), &p.Candidates); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (d *SQLiteDB) GetPendingMedia(id string) (*PendingMedia, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := scanPendingMedia(d.db.QueryRowContext(ctx, `SELECT `+pendingColumns+` FROM pending_media WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (d *SQLiteDB) ListPendingMedia(limit int) ([]PendingMedia, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT `+pendingColumns+` FROM pending_media ORDER BY created_at LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []PendingMedia
+	for rows.Next() {
+		p, err := scanPendingMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, *p)
+	}
+	return pending, rows.Err()
+}
+
+func (d *SQLiteDB) DeletePendingMedia(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `DELETE FROM pending_media WHERE id = ?`, id)
+	return err
+}
+
+func (d *SQLiteDB) UpsertTitle(t *Title) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	genres, err := json.Marshal(t.Genres)
+	if err != nil {
+		return err
+	}
+	episodes, err := json.Marshal(t.Episodes)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO titles (`+titleColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.TMDBID, t.MediaType, t.Title, t.OriginalTitle, t.Year, t.ReleaseDate, t.Overview, t.PosterPath,
+		t.BackdropPath, string(genres), t.Runtime, t.IMDbID, t.VoteAverage, string(episodes), t.FetchedAt)
+	return err
+}
+
+const titleColumns = `tmdb_id, media_type, title, original_title, year, release_date, overview, poster_path,
+	backdrop_path, genres, runtime, imdb_id, vote_average, episodes, fetched_at`
+
+func scanTitle(row rowScanner) (*Title, error) {
+	var t Title
+	var genres, episodes string
+	err := row.Scan(&t.TMDBID, &t.MediaType, &t.Title, &t.OriginalTitle, &t.Year, &t.ReleaseDate, &t.Overview,
+		&t.PosterPath, &t.BackdropPath, &genres, &t.Runtime, &t.IMDbID, &t.VoteAverage, &episodes, &t.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(genres), &t.Genres); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(episodes), &t.Episodes); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (d *SQLiteDB) GetTitle(tmdbID int, mediaType string) (*Title, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t, err := scanTitle(d.db.QueryRowContext(ctx, `SELECT `+titleColumns+` FROM titles
+		WHERE tmdb_id = ? AND media_type = ?`, tmdbID, mediaType))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (d *SQLiteDB) GetTitles(keys []TitleKey) (map[TitleKey]*Title, error) {
+	titles := make(map[TitleKey]*Title, len(keys))
+	for _, k := range keys {
+		t, err := d.GetTitle(k.TMDBID, k.MediaType)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			titles[k] = t
+		}
+	}
+	return titles, nil
+}
+
+func (d *SQLiteDB) GetRecentTitles(limit int) ([]TitleKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return d.queryTitleKeys(ctx, `SELECT tmdb_id, media_type FROM media WHERE tmdb_id > 0
+		GROUP BY tmdb_id, media_type ORDER BY MAX(created_at) DESC LIMIT ?`, limit)
+}
+
+func (d *SQLiteDB) GetTitlesToRefresh(staleBefore time.Time, limit int) ([]TitleKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return d.queryTitleKeys(ctx, `
+		SELECT tmdb_id, media_type FROM (
+			SELECT DISTINCT m.tmdb_id, m.media_type, 0 AS stale, NULL AS fetched_at FROM media m
+			LEFT JOIN titles t ON t.tmdb_id = m.tmdb_id AND t.media_type = m.media_type
+			WHERE m.tmdb_id > 0 AND t.tmdb_id IS NULL
+			UNION ALL
+			SELECT tmdb_id, media_type, 1, fetched_at FROM titles WHERE fetched_at < ?
+		) ORDER BY stale, fetched_at LIMIT ?`, staleBefore, limit)
+}
+
+func (d *SQLiteDB) queryTitleKeys(ctx context.Context, query string, args ...any) ([]TitleKey, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []TitleKey
+	for rows.Next() {
+		var k TitleKey
+		if err := rows.Scan(&k.TMDBID, &k.MediaType); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (d *SQLiteDB) GetAllTitles() ([]Title, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT `+strings.Replace(titleColumns, "episodes", "'[]'", 1)+` FROM titles`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var titles []Title
+	for rows.Next() {
+		t, err := scanTitle(rows)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, *t)
+	}
+	return titles, rows.Err()
+}
+
+// sqliteLibrarySorts gives the column and direction of each library sort,
+// and the comparison that finds groups after a cursor.
+var sqliteLibrarySorts = map[string]struct{ column, order, after string }{
+	"recent": {"added_at", "DESC", "<"},
+	"title":  {"title COLLATE NOCASE", "ASC", ">"},
+	"rating": {"rating", "DESC", "<"},
+	"year":   {"year", "DESC", "<"},
+}
+
+// sqliteLibrary groups files by title and joins the stored metadata. A
+// file's codec is its probed one, or the one its release name gives.
+const sqliteLibrary = `
+	WITH library AS (
+		SELECT g.tmdb_id, g.media_type, COALESCE(NULLIF(t.title, ''), g.title) AS title,
+			COALESCE(t.year, 0) AS year, COALESCE(t.overview, '') AS overview,
+			COALESCE(t.poster_path, '') AS poster_path, COALESCE(t.backdrop_path, '') AS backdrop_path,
+			COALESCE(t.genres, '[]') AS genres, COALESCE(t.vote_average, 0) AS rating,
+			COALESCE(t.runtime, 0) AS runtime, g.added_at, g.files, g.qualities, g.codecs
+		FROM (
+			SELECT tmdb_id, media_type, MIN(title) AS title, MAX(created_at) AS added_at, COUNT(*) AS files,
+				json_group_array(DISTINCT quality) AS qualities,
+				json_group_array(DISTINCT COALESCE(
+					NULLIF(json_extract(NULLIF(probe, ''), '$.video_codec'), ''),
+					json_extract(NULLIF(release_info, ''), '$.video_codec'),
+					'')) AS codecs
+			FROM media WHERE tmdb_id > 0 GROUP BY tmdb_id, media_type
+		) AS g
+		LEFT JOIN titles t ON t.tmdb_id = g.tmdb_id AND t.media_type = g.media_type
+	)`
+
+// GetLibrary filters, sorts and pages the library in one query. The total
+// counts every group matching the filters, not only those after the cursor.
+func (d *SQLiteDB) GetLibrary(q LibraryQuery) ([]LibraryGroup, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	order, ok := sqliteLibrarySorts[q.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown library sort %q", q.Sort)
+	}
+
+	var where []string
+	var args []any
+	if q.MediaType != "" {
+		where = append(where, `media_type = ?`)
+		args = append(args, q.MediaType)
+	}
+	if q.Genre != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM json_each(genres) WHERE value = ? COLLATE NOCASE)`)
+		args = append(args, q.Genre)
+	}
+	if q.YearFrom > 0 {
+		where = append(where, `year >= ?`)
+		args = append(args, q.YearFrom)
+	}
+	if q.YearTo > 0 {
+		where = append(where, `year > 0 AND year <= ?`)
+		args = append(args, q.YearTo)
+	}
+	if q.Quality != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM json_each(qualities) WHERE value = ? COLLATE NOCASE)`)
+		args = append(args, q.Quality)
+	}
+	if q.Codec != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM json_each(codecs) WHERE value = ? COLLATE NOCASE)`)
+		args = append(args, q.Codec)
+	}
+	if !q.AddedSince.IsZero() {
+		where = append(where, `added_at >= ?`)
+		args = append(args, q.AddedSince)
+	}
+	filter := ""
+	if len(where) > 0 {
+		filter = `WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	after := ""
+	pageArgs := slices.Clip(args)
+	if a := q.After; a != nil {
+		var value any
+		switch q.Sort {
+		case "recent":
+			value = a.AddedAt
+		case "title":
+			value = a.Title
+		case "rating":
+			value = a.Rating
+		case "year":
+			value = a.Year
+		}
+		after = fmt.Sprintf(`WHERE %[1]s %[2]s ? OR (%[1]s = ? AND (tmdb_id > ? OR (tmdb_id = ? AND media_type > ?)))`,
+			order.column, order.after)
+		pageArgs = append(pageArgs, value, value, a.TMDBID, a.TMDBID, a.MediaType)
+	}
+	pageArgs = append(pageArgs, q.Limit)
+
+	// Aggregates lose the column type, so the newest created_at is read
+	// again with a correlated subquery to keep it a timestamp.
+	rows, err := d.db.QueryContext(ctx, sqliteLibrary+`
+		SELECT tmdb_id, media_type, title, year, overview, poster_path, backdrop_path, genres, rating, runtime,
+			(SELECT created_at FROM media m WHERE m.tmdb_id = page.tmdb_id AND m.media_type = page.media_type
+				ORDER BY created_at DESC LIMIT 1),
+			files, qualities, codecs, total
+		FROM (SELECT *, COUNT(*) OVER () AS total FROM library `+filter+`) AS page
+		`+after+`
+		ORDER BY `+order.column+` `+order.order+`, tmdb_id, media_type LIMIT ?`, pageArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var groups []LibraryGroup
+	total := 0
+	for rows.Next() {
+		var g LibraryGroup
+		var genres, qualities, codecs string
+		err := rows.Scan(&g.TMDBID, &g.MediaType, &g.Title, &g.Year, &g.Overview, &g.PosterPath, &g.BackdropPath,
+			&genres, &g.Rating, &g.Runtime, &g.AddedAt, &g.Files, &qualities, &codecs, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal([]byte(genres), &g.Genres); err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal([]byte(qualities), &g.Qualities); err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal([]byte(codecs), &g.Codecs); err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// A page after the last match has no row to carry the total.
+	if len(groups) == 0 && q.After != nil {
+		err := d.db.QueryRowContext(ctx, sqliteLibrary+`SELECT COUNT(*) FROM library `+filter, args...).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return groups, total, nil
+}
+
+func (d *SQLiteDB) GetParsedNames(keys []string) (map[string]ParsedName, error) {
+	parsed := make(map[string]ParsedName, len(keys))
+	if len(keys) == 0 {
+		return parsed, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	args := make([]any, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+
+	rows, err := d.db.QueryContext(ctx, `SELECT key, model, title, year, season, episode, last_episode, quality, created_at
+		FROM parsed_names WHERE key IN (?`+strings.Repeat(", ?", len(keys)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p ParsedName
+		if err := rows.Scan(&p.Key, &p.Model, &p.Title, &p.Year, &p.Season, &p.Episode, &p.LastEpisode, &p.Quality, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		parsed[p.Key] = p
+	}
+	return parsed, rows.Err()
+}
+
+func (d *SQLiteDB) SaveParsedNames(names []ParsedName) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT OR REPLACE INTO parsed_names (key, model, title, year, season, episode, last_episode, quality, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, p := range names {
+		_, err := stmt.ExecContext(ctx, p.Key, p.Model, p.Title, p.Year, p.Season, p.Episode, p.LastEpisode, p.Quality, p.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Settings are stored as JSON, so numbers come back as float64.
+func (d *SQLiteDB) SetSetting(key string, value interface{}, updatedBy int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO settings (id, key, value, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			value = excluded.value, updated_at = excluded.updated_at, updated_by = excluded.updated_by`,
+		primitive.NewObjectID().Hex(), key, string(encoded), time.Now(), updatedBy)
+	return err
+}
+
+func (d *SQLiteDB) GetSetting(key string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var encoded sql.NullString
+	err := d.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, key).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !encoded.Valid {
+		return nil, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(encoded.String), &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (d *SQLiteDB) GetPublicAccess() (bool, error) {
+	val, err := d.GetSetting("public_access")
+	if err != nil {
+		return false, err
+	}
+	b, _ := val.(bool)
+	return b, nil
+}
+
+func (d *SQLiteDB) SetPublicAccess(enabled bool, updatedBy int64) error {
+	return d.SetSetting("public_access", enabled, updatedBy)
+}
+
+// The SQLite schema is created up front by InitSQLite, so its migrations
+// only cover data fixes. They are recorded in schema_migrations like the
+// MongoDB ones but numbered separately.
+var sqliteMigrations = []struct {
+	Version     int
+	Description string
+	Run         func(ctx context.Context, tx *sql.Tx, dryRun bool) (string, error)
+}{
+	// These used to run whenever the database was opened, so they come
+	// before the steps recorded ahead of them, which read the new columns.
+	{4, "add last episode columns", sqliteAddColumns(
+		sqliteColumn{"media", "last_episode", "INTEGER NOT NULL DEFAULT 0"},
+		sqliteColumn{"pending_media", "last_episode", "INTEGER NOT NULL DEFAULT 0"},
+	)},
+	{5, "add media probe column", sqliteAddColumns(
+		sqliteColumn{"media", "probe", "TEXT NOT NULL DEFAULT ''"},
+	)},
+	{6, "add release tags column", sqliteAddColumns(
+		sqliteColumn{"media", "release_info", "TEXT NOT NULL DEFAULT ''"},
+	)},
+	{3, "rebuild media table to allow multiple versions", sqliteDropEpisodeConstraint},
+	{1, "strip quality suffix from titles", sqliteStripTitleQualitySuffix},
+	{2, "parse release tags from file names", sqliteParseReleaseTags},
+}
+
+func (d *SQLiteDB) Migrate(dryRun bool) ([]MigrationResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		summary     TEXT NOT NULL,
+		applied_at  TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MigrationResult
+	for _, m := range sqliteMigrations {
+		result := MigrationResult{Version: m.Version, Description: m.Description}
+
+		err := d.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`, m.Version).
+			Scan(&result.AlreadyApplied)
+		if err != nil {
+			return results, err
+		}
+		if result.AlreadyApplied {
+			results = append(results, result)
+			continue
+		}
+
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return results, err
+		}
+
+		result.Summary, err = m.Run(ctx, tx, dryRun)
+		if err == nil && !dryRun {
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, description, summary, applied_at) VALUES (?, ?, ?, ?)`,
+				m.Version, m.Description, result.Summary, time.Now())
+		}
+		if err != nil {
+			tx.Rollback()
+			return results, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		if dryRun {
+			tx.Rollback()
+		} else if err := tx.Commit(); err != nil {
+			return results, err
+		} else {
+			log.Printf("[MIGRATE] %d %s: %s", m.Version, m.Description, result.Summary)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// sqliteColumn is a column added to a table after its first release.
+type sqliteColumn struct{ table, column, definition string }
+
+// sqliteAddColumns returns a step adding the columns older databases are
+// missing.
+func sqliteAddColumns(columns ...sqliteColumn) func(ctx context.Context, tx *sql.Tx, dryRun bool) (string, error) {
+	return func(ctx context.Context, tx *sql.Tx, dryRun bool) (string, error) {
+		count := 0
+		for _, c := range columns {
+			exists, err := sqliteHasColumn(ctx, tx, c.table, c.column)
+			if err != nil {
+				return "", err
+			}
+			if exists {
+				continue
+			}
+			count++
+
+			if dryRun {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `ALTER TABLE `+c.table+` ADD COLUMN `+c.column+` `+c.definition); err != nil {
+				return "", err
+			}
+		}
+
+		if dryRun {
+			return fmt.Sprintf("would add %d columns", count), nil
+		}
+		return fmt.Sprintf("added %d columns", count), nil
+	}
+}
+
+func sqliteHasColumn(ctx context.Context, tx *sql.Tx, table, column string) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`, table, column).Scan(&exists)
+	return exists, err
+}
+
+// sqliteDropEpisodeConstraint rebuilds media tables created when every
+// TMDB item could hold a single file. SQLite cannot drop a table
+// constraint, so the rows are copied into a table with the current
+// definition and the full-text index is rebuilt afterwards.
+func sqliteDropEpisodeConstraint(ctx context.Context, tx *sql.Tx, dryRun bool) (string, error) {
+	var definition string
+	err := tx.QueryRowContext(ctx, `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'media'`).Scan(&definition)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(definition, "UNIQUE (tmdb_id, media_type, season, episode)") {
+		return "media table already allows multiple versions", nil
+	}
+	if dryRun {
+		return "would rebuild media table", nil
+	}
+
+	steps := []string{
+		fmt.Sprintf(mediaTable, "media_rebuild"),
+		`INSERT INTO media_rebuild (` + mediaColumns + `) SELECT ` + mediaColumns + ` FROM media`,
+		`DROP TABLE media`,
+		`ALTER TABLE media_rebuild RENAME TO media`,
+		sqliteSchema,
+		`INSERT INTO media_fts (media_fts) VALUES ('rebuild')`,
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step); err != nil {
+			return "", err
+		}
+	}
+	return "rebuilt media table", nil
+}
+
+func sqliteStripTitleQualitySuffix(ctx context.Context, tx *sql.Tx, dryRun bool) (string, error) {
+	const where = `quality != '' AND substr(title, -length(quality) - 3) = ' [' || quality || ']'`
+
+	if dryRun {
+		var count int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM media WHERE `+where).Scan(&count); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("would update %d titles", count), nil
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE media SET title = substr(title, 1, length(title) - length(quality) - 3) WHERE `+where)
+	if err != nil {
+		return "", err
+	}
+	count, _ := result.RowsAffected()
+	return fmt.Sprintf("updated %d titles", count), nil
+}
+
+func sqliteParseReleaseTags(ctx context.Context, tx *sql.Tx, dryRun bool) (string, error) {
+	// A dry run on an old database reaches this before the column exists.
+	where := `release_info = ''`
+	if exists, err := sqliteHasColumn(ctx, tx, "media", "release_info"); err != nil {
+		return "", err
+	} else if !exists {
+		where = `1`
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, file_name FROM media WHERE `+where)
+	if err != nil {
+		return "", err
+	}
+
+	tags := make(map[string]string)
+	for rows.Next() {
+		var id, fileName string
+		if err := rows.Scan(&id, &fileName); err != nil {
+			rows.Close()
+			return "", err
+		}
+		encoded, err := encodeRelease(NewReleaseInfo(release.Parse(fileName)))
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		if encoded != "" {
+			tags[id] = encoded
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		return fmt.Sprintf("would tag %d files", len(tags)), nil
+	}
+	for id, encoded := range tags {
+		if _, err := tx.ExecContext(ctx, `UPDATE media SET release_info = ? WHERE id = ?`, encoded, id); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("tagged %d files", len(tags)), nil
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage backends selectable through DB_BACKEND.
+const (
+	BackendMongo  = "mongo"
+	BackendSQLite = "sqlite"
+)
+
+// DB is the storage used by the bot and the HTTP server. MongoDB is the
+// default backend; SQLite lets small deployments run without a server.
+type DB interface {
+	Close() error
+	Migrate(dryRun bool) ([]MigrationResult, error)
+
+	AddMedia(tmdbID int, mediaType, title, fileID string, messageID int, chatID int64, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, cdnBotIndex int, release *ReleaseInfo) error
+	AddMediaBatch(files []MediaFile) error
+	AddLocalMedia(tmdbID int, mediaType, title, filePath string, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, release *ReleaseInfo) error
+	GetMediaByTMDB(tmdbID int, mediaType string, season, episode int) (*MediaFile, error)
+	GetMediaVersions(tmdbID int, mediaType string, season, episode int) ([]MediaFile, error)
+	GetSeasonEpisodes(tmdbID int, season int) ([]MediaFile, error)
+	SearchMedia(query string) ([]MediaFile, error)
+	SearchByTitle(query string) ([]MediaFile, error)
+	GetAllMedia(limit, offset int) ([]MediaFile, error)
+	GetAvailableSeasons(tmdbID int) ([]int, error)
+	GetAvailableQualities(tmdbID int, mediaType string, season, episode int) ([]string, error)
+	GetMediaByTMDBID(tmdbID int, mediaType string) ([]MediaFile, error)
+	GetEpisodesBySeason(tmdbID, season int) ([]MediaFile, error)
+	GetMediaByQuality(tmdbID int, mediaType string, season, episode int, quality string) (*MediaFile, error)
+	GetMediaByID(id string) (*MediaFile, error)
+	GetMediaByChatMessage(chatID int64, messageID int) (*MediaFile, error)
+	GetLocalMedia(filePath string) (*MediaFile, error)
+	SetMediaProbe(id string, quality string, probe *MediaProbe) error
+	GetUnprobedMedia(limit int) ([]MediaFile, error)
+
+	UpsertTitle(t *Title) error
+	GetTitle(tmdbID int, mediaType string) (*Title, error)
+	GetTitles(keys []TitleKey) (map[TitleKey]*Title, error)
+	GetRecentTitles(limit int) ([]TitleKey, error)
+	GetTitlesToRefresh(staleBefore time.Time, limit int) ([]TitleKey, error)
+	GetAllTitles() ([]Title, error)
+	GetLibrary(q LibraryQuery) ([]LibraryGroup, int, error)
+
+	AddUser(userID int64, username, firstName, lastName string) error
+	GetStats() (*DBStats, error)
+
+	AddAuthUser(userID int64, username, firstName string, addedBy int64) error
+	RemoveAuthUser(userID int64) error
+	IsAuthUser(userID int64) (bool, error)
+	GetAllAuthUsers() ([]AuthUser, error)
+
+	AddPendingMedia(p *PendingMedia) (string, error)
+	GetPendingMedia(id string) (*PendingMedia, error)
+	ListPendingMedia(limit int) ([]PendingMedia, error)
+	DeletePendingMedia(id string) error
+
+	GetParsedNames(keys []string) (map[string]ParsedName, error)
+	SaveParsedNames(names []ParsedName) error
+
+	SetSetting(key string, value interface{}, updatedBy int64) error
+	GetSetting(key string) (interface{}, error)
+	GetPublicAccess() (bool, error)
+	SetPublicAccess(enabled bool, updatedBy int64) error
+}
+
+var (
+	_ DB = (*MongoDB)(nil)
+	_ DB = (*SQLiteDB)(nil)
+)
+
+// Open connects to the configured backend. mongoURL and dbName are used by
+// the MongoDB backend, sqlitePath by SQLite.
+func Open(backend, mongoURL, dbName, sqlitePath string) (DB, error) {
+	switch backend {
+	case "", BackendMongo:
+		db, err := InitMongo(mongoURL, dbName)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case BackendSQLite:
+		db, err := InitSQLite(sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", backend)
+	}
+}
+
+// QualityRank orders quality labels by vertical resolution so "2160p" sorts
+// above "1080p". Unknown labels rank lowest.
+func QualityRank(quality string) int {
+	q := strings.ToLower(strings.TrimSpace(quality))
+	switch q {
+	case "4k", "uhd":
+		return 2160
+	case "8k":
+		return 4320
+	case "hd":
+		return 720
+	case "sd":
+		return 480
+	}
+	if n, err := strconv.Atoi(strings.TrimSuffix(q, "p")); err == nil {
+		return n
+	}
+	return 0
+}
+
+// SortVersions puts the best version first: highest resolution, then the
+// largest file.
+func SortVersions(versions []MediaFile) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		ri, rj := QualityRank(versions[i].Quality), QualityRank(versions[j].Quality)
+		if ri != rj {
+			return ri > rj
+		}
+		return versions[i].FileSize > versions[j].FileSize
+	})
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"strix/database"
+	"strix/search"
+	"strix/source"
 	"strix/telegram"
+	"strix/tmdb"
 
 	"github.com/gorilla/mux"
 )
@@ -23,89 +30,128 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url := fmt.Sprintf("%s/search/multi?api_key=%s&query=%s", tmdbBaseURL, s.config.TMDBAPIKey, url.QueryEscape(query))
-	resp, err := http.Get(url)
-	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+	s.proxyTMDB(w, r, "/search/multi", url.Values{"query": {query}})
+}
+
+// handleSuggest answers search-as-you-type from the in-memory index. With
+// tmdb=1 it also searches TMDB and lists titles missing from the library
+// separately, marked unavailable.
+func (s *Server) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
-		return
+	limit := 8
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		fmt.Sscanf(limitParam, "%d", &limit)
 	}
+	limit = max(1, min(limit, 20))
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(body)
-}
+	library := s.search.Suggest(query, limit)
+	response := map[string]any{
+		"query":   query,
+		"library": library,
+	}
 
-func (s *Server) handleTVDetails(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	if r.URL.Query().Get("tmdb") == "1" {
+		external, err := s.tmdb.SearchMulti(r.Context(), query)
+		if err != nil {
+			log.Printf("[SUGGEST] TMDB search failed: %v", err)
+		}
 
-	url := fmt.Sprintf("%s/tv/%s?api_key=%s&append_to_response=credits,videos,recommendations,external_ids",
-		tmdbBaseURL, id, s.config.TMDBAPIKey)
+		seen := make(map[database.TitleKey]bool)
+		for _, item := range library {
+			seen[database.TitleKey{TMDBID: item.TMDBID, MediaType: item.MediaType}] = true
+		}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
+		missing := []search.Suggestion{}
+		if external != nil {
+			for _, result := range external.Results {
+				key := database.TitleKey{TMDBID: result.ID, MediaType: result.MediaType}
+				if (key.MediaType != "movie" && key.MediaType != "tv") || seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				// TMDB may match a library title the index missed, e.g. by an
+				// alternative name.
+				if item, ok := s.search.LibrarySuggestion(key, result.DisplayTitle()); ok {
+					if len(library) < limit {
+						library = append(library, item)
+					}
+					continue
+				}
+				if len(missing) >= limit {
+					continue
+				}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
-		return
+				year, _ := strconv.Atoi(result.Year())
+				missing = append(missing, search.Suggestion{
+					TMDBID:     result.ID,
+					MediaType:  result.MediaType,
+					Title:      result.DisplayTitle(),
+					Year:       year,
+					PosterPath: result.PosterPath,
+				})
+			}
+		}
+
+		response["library"] = library
+		response["tmdb"] = missing
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(body)
+	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) handleSeasonDetails(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleTVDetails(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
-	season := vars["season"]
-
-	url := fmt.Sprintf("%s/tv/%s/season/%s?api_key=%s", tmdbBaseURL, id, season, s.config.TMDBAPIKey)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
+	s.proxyTMDB(w, r, "/tv/"+id, url.Values{"append_to_response": {tmdb.DetailsAppend}})
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
-		return
-	}
+func (s *Server) handleSeasonDetails(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	season := vars["season"]
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(body)
+	s.proxyTMDB(w, r, "/tv/"+id+"/season/"+season, nil)
 }
 
 func (s *Server) handleMovieDetails(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	url := fmt.Sprintf("%s/movie/%s?api_key=%s&append_to_response=credits,videos,recommendations,external_ids",
-		tmdbBaseURL, id, s.config.TMDBAPIKey)
+	s.proxyTMDB(w, r, "/movie/"+id, url.Values{"append_to_response": {tmdb.DetailsAppend}})
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+func (s *Server) handleTrending(w http.ResponseWriter, r *http.Request) {
+	mediaType := r.URL.Query().Get("type")
+	if mediaType == "" {
+		mediaType = "all"
+	}
+
+	timeWindow := r.URL.Query().Get("time")
+	if timeWindow == "" {
+		timeWindow = "week"
+	}
+
+	if (mediaType != "all" && mediaType != "movie" && mediaType != "tv") || (timeWindow != "day" && timeWindow != "week") {
+		http.Error(w, "Invalid type or time", http.StatusBadRequest)
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	s.proxyTMDB(w, r, "/trending/"+mediaType+"/"+timeWindow, nil)
+}
+
+// proxyTMDB passes a TMDB response through to the browser unchanged.
+func (s *Server) proxyTMDB(w http.ResponseWriter, r *http.Request, path string, params url.Values) {
+	body, err := s.tmdb.Raw(r.Context(), path, params)
 	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
+		tmdbError(w, err)
 		return
 	}
 
@@ -113,34 +159,68 @@ func (s *Server) handleMovieDetails(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-func (s *Server) handleTrending(w http.ResponseWriter, r *http.Request) {
-	mediaType := r.URL.Query().Get("type")
-	if mediaType == "" {
-		mediaType = "all"
+func tmdbError(w http.ResponseWriter, err error) {
+	if tmdb.IsNotFound(err) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
 	}
+	log.Printf("[TMDB] %v", err)
+	http.Error(w, "Failed to fetch data", http.StatusBadGateway)
+}
 
-	timeWindow := r.URL.Query().Get("time")
-	if timeWindow == "" {
-		timeWindow = "week"
+// handleRecentTitles lists the titles that most recently got a file, from
+// stored metadata only. Items are shaped like TMDB results so the frontend
+// can render them with the same cards.
+func (s *Server) handleRecentTitles(w http.ResponseWriter, r *http.Request) {
+	limit := 16
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		fmt.Sscanf(limitParam, "%d", &limit)
 	}
+	limit = max(1, min(limit, 50))
 
-	url := fmt.Sprintf("%s/trending/%s/%s?api_key=%s", tmdbBaseURL, mediaType, timeWindow, s.config.TMDBAPIKey)
-
-	resp, err := http.Get(url)
+	keys, err := s.db.GetRecentTitles(limit)
 	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		http.Error(w, "Failed to list titles", http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	titles, err := s.db.GetTitles(keys)
 	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
+		http.Error(w, "Failed to list titles", http.StatusInternalServerError)
 		return
 	}
 
+	items := []map[string]any{}
+	for _, key := range keys {
+		if title := titles[key]; title != nil {
+			items = append(items, titleCard(title))
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(body)
+	json.NewEncoder(w).Encode(items)
+}
+
+func titleCard(t *database.Title) map[string]any {
+	item := map[string]any{
+		"id":            t.TMDBID,
+		"media_type":    t.MediaType,
+		"overview":      t.Overview,
+		"poster_path":   t.PosterPath,
+		"backdrop_path": t.BackdropPath,
+		"vote_average":  t.VoteAverage,
+		"genres":        t.Genres,
+		"runtime":       t.Runtime,
+		"imdb_id":       t.IMDbID,
+	}
+	if t.MediaType == "tv" {
+		item["name"] = t.Title
+		item["first_air_date"] = t.ReleaseDate
+	} else {
+		item["title"] = t.Title
+		item["release_date"] = t.ReleaseDate
+	}
+	return item
 }
 
 func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
@@ -150,6 +230,11 @@ func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
 	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
 		fmt.Sscanf(limitParam, "%d", &limit)
 	}
+	if offsetParam := r.URL.Query().Get("offset"); offsetParam != "" {
+		fmt.Sscanf(offsetParam, "%d", &offset)
+	}
+	limit = max(1, min(limit, 200))
+	offset = max(0, offset)
 
 	media, err := s.db.GetAllMedia(limit, offset)
 	if err != nil {
@@ -181,16 +266,20 @@ func (s *Server) handleSearchFiles(w http.ResponseWriter, r *http.Request) {
 		MediaType   string `json:"media_type"`
 		Season      int    `json:"season,omitempty"`
 		Episode     int    `json:"episode,omitempty"`
+		LastEpisode int    `json:"last_episode,omitempty"`
 		Quality     string `json:"quality"`
 		FileSize    int64  `json:"file_size"`
 		StreamToken string `json:"stream_token"`
 		StreamURL   string `json:"stream_url"`
 		TMDBID      int    `json:"tmdb_id"`
+
+		Probe   *database.MediaProbe  `json:"probe,omitempty"`
+		Release *database.ReleaseInfo `json:"release,omitempty"`
 	}
 
 	searchResults := make([]SearchResult, 0, len(results))
 	for _, media := range results {
-		streamToken := telegram.GenerateStreamToken(media.ChatID, media.MessageID)
+		streamToken := telegram.MediaStreamToken(&media, 0)
 		searchResults = append(searchResults, SearchResult{
 			ID:          media.ID.Hex(),
 			Title:       media.Title,
@@ -198,11 +287,14 @@ func (s *Server) handleSearchFiles(w http.ResponseWriter, r *http.Request) {
 			MediaType:   media.MediaType,
 			Season:      media.Season,
 			Episode:     media.Episode,
+			LastEpisode: media.LastEpisode,
 			Quality:     media.Quality,
 			FileSize:    media.FileSize,
 			StreamToken: streamToken,
 			StreamURL:   fmt.Sprintf("/stream/%s", streamToken),
 			TMDBID:      media.TMDBID,
+			Probe:       media.Probe,
+			Release:     media.Release,
 		})
 	}
 
@@ -223,13 +315,13 @@ func (s *Server) handleGetMovieFiles(w http.ResponseWriter, r *http.Request) {
 	var id int
 	fmt.Sscanf(tmdbID, "%d", &id)
 
-	media, err := s.db.GetMediaByTMDB(id, "movie", 0, 0)
+	versions, err := s.db.GetMediaVersions(id, "movie", 0, 0)
 	if err != nil {
 		http.Error(w, "Failed to fetch media", http.StatusInternalServerError)
 		return
 	}
 
-	if media == nil {
+	if len(versions) == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{
 			"available": false,
@@ -239,15 +331,50 @@ func (s *Server) handleGetMovieFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	json.NewEncoder(w).Encode(availableVersions(versions))
+}
+
+// availableVersions describes every stored version of a movie or episode.
+// The top-level fields are the best version, for clients that only play
+// one file. A version holding several episodes is marked shared and lists
+// them.
+func availableVersions(versions []database.MediaFile) map[string]any {
+	best := versions[0]
+
+	list := make([]map[string]any, 0, len(versions))
+	for _, v := range versions {
+		version := map[string]any{
+			"id":           v.ID.Hex(),
+			"quality":      v.Quality,
+			"codec":        v.Release.Codec(),
+			"file_name":    v.FileName,
+			"file_size":    v.FileSize,
+			"stream_token": telegram.MediaStreamToken(&v, 0),
+		}
+		if v.Release != nil {
+			version["release"] = v.Release
+		}
+		if v.Probe != nil && v.Probe.Error == "" {
+			version["codec"] = v.Probe.VideoCodec
+			version["probe"] = v.Probe
+		}
+		if v.LastEpisode > v.Episode {
+			version["shared"] = true
+			version["episodes"] = v.Episodes()
+		}
+		list = append(list, version)
+	}
+
+	return map[string]any{
 		"available":     true,
-		"message_id":    media.MessageID,
-		"chat_id":       media.ChatID,
-		"cdn_bot_index": media.CDNBotIndex,
-		"title":         media.Title,
-		"quality":       media.Quality,
-		"stream_token":  telegram.GenerateStreamToken(media.ChatID, media.MessageID),
-	})
+		"message_id":    best.MessageID,
+		"chat_id":       best.ChatID,
+		"cdn_bot_index": best.CDNBotIndex,
+		"title":         best.Title,
+		"quality":       best.Quality,
+		"stream_token":  list[0]["stream_token"],
+		"versions":      list,
+	}
 }
 
 func (s *Server) handleGetSeasonFiles(w http.ResponseWriter, r *http.Request) {
@@ -265,19 +392,20 @@ func (s *Server) handleGetSeasonFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	episodeMap := make(map[int]interface{})
+	// A multi-episode file is listed under every episode it holds.
+	byEpisode := make(map[int][]database.MediaFile)
 	for _, ep := range episodes {
-		episodeMap[ep.Episode] = map[string]interface{}{
-			"available":     true,
-			"message_id":    ep.MessageID,
-			"chat_id":       ep.ChatID,
-			"cdn_bot_index": ep.CDNBotIndex,
-			"title":         ep.Title,
-			"quality":       ep.Quality,
-			"stream_token":  telegram.GenerateStreamToken(ep.ChatID, ep.MessageID),
+		for _, episode := range ep.Episodes() {
+			byEpisode[episode] = append(byEpisode[episode], ep)
 		}
 	}
 
+	episodeMap := make(map[int]interface{})
+	for episode, versions := range byEpisode {
+		database.SortVersions(versions)
+		episodeMap[episode] = availableVersions(versions)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(episodeMap)
 }
@@ -293,13 +421,13 @@ func (s *Server) handleGetEpisodeFile(w http.ResponseWriter, r *http.Request) {
 	fmt.Sscanf(season, "%d", &seasonNum)
 	fmt.Sscanf(episode, "%d", &episodeNum)
 
-	media, err := s.db.GetMediaByTMDB(id, "tv", seasonNum, episodeNum)
+	versions, err := s.db.GetMediaVersions(id, "tv", seasonNum, episodeNum)
 	if err != nil {
 		http.Error(w, "Failed to fetch episode", http.StatusInternalServerError)
 		return
 	}
 
-	if media == nil {
+	if len(versions) == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{
 			"available": false,
@@ -309,32 +437,25 @@ func (s *Server) handleGetEpisodeFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"available":     true,
-		"message_id":    media.MessageID,
-		"chat_id":       media.ChatID,
-		"cdn_bot_index": media.CDNBotIndex,
-		"title":         media.Title,
-		"quality":       media.Quality,
-		"stream_token":  telegram.GenerateStreamToken(media.ChatID, media.MessageID),
-	})
+	json.NewEncoder(w).Encode(availableVersions(versions))
 }
 
 func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	token := vars["token"]
+	req, ok := streamRequest(w, r, "STREAM")
+	if !ok {
+		return
+	}
 
-	req, err := telegram.ParseStreamToken(token)
+	src, err := s.mediaSource(req)
 	if err != nil {
-		log.Printf("[STREAM] Invalid token")
-		http.Error(w, "Invalid or missing token", http.StatusUnauthorized)
+		log.Printf("[STREAM] Invalid source: %v", err)
+		http.Error(w, "No media found", http.StatusNotFound)
 		return
 	}
 
-	fileInfo, err := telegram.GetMediaInfo(req.ChatID, req.MessageID)
+	fileInfo, err := src.Stat(r.Context())
 	if err != nil {
-		log.Printf("[STREAM] Media not found")
-		http.Error(w, "No media found", http.StatusNotFound)
+		sourceError(w, "STREAM", err)
 		return
 	}
 
@@ -355,132 +476,93 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var start, end int64
-	var status int
-	rangeHeader := r.Header.Get("Range")
-
-	if rangeHeader != "" {
-		rangeStr := strings.TrimPrefix(rangeHeader, "bytes=")
-		parts := strings.Split(rangeStr, "-")
-
-		if parts[0] != "" {
-			start, _ = strconv.ParseInt(parts[0], 10, 64)
-		}
-
-		if len(parts) > 1 && parts[1] != "" {
-			end, _ = strconv.ParseInt(parts[1], 10, 64)
-		} else {
-			end = fileSize - 1
-		}
-
-		if start >= fileSize || end >= fileSize || start > end {
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
-			http.Error(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
-			return
-		}
-		status = http.StatusPartialContent
-	} else {
-		start = 0
-		end = fileSize - 1
-		status = http.StatusOK
-	}
+	etag := fileInfo.ETag
 
-	contentLength := end - start + 1
-
-	rangeDisplay := rangeHeader
-	if rangeDisplay == "" {
-		rangeDisplay = "full"
-	}
-	log.Printf("[STREAM] %s | %s", displayName, rangeDisplay)
-
-	w.Header().Set("Content-Type", mimeType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, fileName))
 	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.Header().Set("ETag", etag)
 	w.Header().Set("Cache-Control", "public, max-age=31536000")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Length,Content-Range")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length,Content-Range,ETag")
 
-	if status == http.StatusPartialContent {
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
-	}
-
-	if r.Method == http.MethodHead {
-		w.WriteHeader(status)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag, false) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	w.WriteHeader(status)
-
-	chunkSize := int64(1024 * 1024)
-	startChunk := start / chunkSize
-	endChunk := end / chunkSize
-	offsetInFirstChunk := start % chunkSize
-	bytesInLastChunk := (end % chunkSize) + 1
-
-	bytesSent := int64(0)
-	currentChunk := startChunk
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !etagMatches(ifRange, etag, true) {
+		// The client's copy is stale (or If-Range carries a date we cannot
+		// validate), so it must get the whole file.
+		rangeHeader = ""
+	}
 
-	clientContext := r.Context()
+	ranges, err := parseRange(rangeHeader, fileSize)
+	if err == errRangeNotSatisfiable {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
+		http.Error(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
 
-	err = telegram.StreamMediaChunks(req.ChatID, req.MessageID, startChunk, func(chunkData []byte) error {
-		select {
-		case <-clientContext.Done():
-			return io.EOF
-		default:
-		}
+	rangeDisplay := rangeHeader
+	if len(ranges) == 0 {
+		rangeDisplay = "full"
+	}
+	if req.UserID != 0 {
+		log.Printf("[STREAM] %s | %s | user %d", displayName, rangeDisplay, req.UserID)
+	} else if req.Legacy {
+		log.Printf("[STREAM] %s | %s | legacy token", displayName, rangeDisplay)
+	} else {
+		log.Printf("[STREAM] %s | %s", displayName, rangeDisplay)
+	}
 
-		chunkToSend := chunkData
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, fileName))
 
-		if currentChunk == startChunk {
-			if int64(len(chunkToSend)) > offsetInFirstChunk {
-				chunkToSend = chunkToSend[offsetInFirstChunk:]
-			} else {
-				currentChunk++
-				return nil
-			}
+	ctx := r.Context()
+	flush := func() {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
 		}
-
-		if currentChunk == endChunk {
-			if currentChunk == startChunk {
-
-				if int64(len(chunkToSend)) > bytesInLastChunk-offsetInFirstChunk {
-					chunkToSend = chunkToSend[:bytesInLastChunk-offsetInFirstChunk]
-				}
-			} else {
-
-				if int64(len(chunkToSend)) > bytesInLastChunk {
-					chunkToSend = chunkToSend[:bytesInLastChunk]
-				}
+	}
+	body := func(dst io.Writer, br byteRange) error {
+		return src.ReadRange(ctx, br.start, br.length, func(chunk []byte) error {
+			if _, err := dst.Write(chunk); err != nil {
+				return err
 			}
-		}
+			flush()
+			return nil
+		})
+	}
 
-		if bytesSent+int64(len(chunkToSend)) > contentLength {
-			chunkToSend = chunkToSend[:contentLength-bytesSent]
+	switch len(ranges) {
+	case 0:
+		w.Header().Set("Content-Type", mimeType)
+		w.Header().Set("Content-Length", strconv.FormatInt(fileSize, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead || fileSize == 0 {
+			return
 		}
-
-		if len(chunkToSend) > 0 {
-			n, err := w.Write(chunkToSend)
-			if err != nil {
-				return io.EOF
-			}
-			bytesSent += int64(n)
-
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
-			}
+		err = body(w, byteRange{start: 0, length: fileSize})
+	case 1:
+		w.Header().Set("Content-Type", mimeType)
+		w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		w.Header().Set("Content-Range", ranges[0].contentRange(fileSize))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return
 		}
-
-		currentChunk++
-
-		if bytesSent >= contentLength || currentChunk > endChunk {
-			return io.EOF
+		err = body(w, ranges[0])
+	default:
+		layout := newMultipartLayout(ranges, mimeType, fileSize)
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+layout.boundary)
+		w.Header().Set("Content-Length", strconv.FormatInt(layout.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return
 		}
+		err = layout.write(w, ranges, body)
+	}
 
-		return nil
-	})
-
-	if err != nil && err != io.EOF {
+	if err != nil && err != io.EOF && !errors.Is(err, context.Canceled) {
 		if !strings.Contains(err.Error(), "broken pipe") &&
 			!strings.Contains(err.Error(), "connection reset") {
 			log.Printf("[STREAM] ✗ Error streaming %s", displayName)
@@ -489,6 +571,50 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// streamRequest reads the stream token in the URL, answering the request
+// itself when the token is expired or invalid.
+func streamRequest(w http.ResponseWriter, r *http.Request, tag string) (*telegram.StreamRequest, bool) {
+	req, err := telegram.ParseStreamToken(mux.Vars(r)["token"])
+	if errors.Is(err, telegram.ErrExpiredToken) {
+		log.Printf("[%s] Expired token", tag)
+		http.Error(w, "Stream link has expired", http.StatusGone)
+		return nil, false
+	}
+	if errors.Is(err, telegram.ErrRevokedToken) {
+		log.Printf("[%s] Revoked token", tag)
+		http.Error(w, "Stream link has been revoked", http.StatusForbidden)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[%s] Invalid token: %v", tag, err)
+		http.Error(w, "Invalid or missing token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return req, true
+}
+
+// mediaSource picks the backend a stream token points at.
+func (s *Server) mediaSource(req *telegram.StreamRequest) (source.MediaSource, error) {
+	if req.Path != "" {
+		return s.local.Open(req.Path)
+	}
+	return telegram.NewSource(req.ChatID, req.MessageID), nil
+}
+
+// sourceError answers a request whose media couldn't be opened: 503 while
+// every Telegram client is cooling down, 404 otherwise.
+func sourceError(w http.ResponseWriter, tag string, err error) {
+	var busy *telegram.BusyError
+	if errors.As(err, &busy) {
+		log.Printf("[%s] %v", tag, err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(busy.RetryAfter.Seconds()))))
+		http.Error(w, "All download clients are busy, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("[%s] Media not found: %v", tag, err)
+	http.Error(w, "No media found", http.StatusNotFound)
+}
+
 func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "index.html")
 }
@@ -501,19 +627,10 @@ func (s *Server) handleTVPage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	url := fmt.Sprintf("%s/tv/%s?api_key=%s&append_to_response=credits,videos,recommendations,external_ids",
-		tmdbBaseURL, id, s.config.TMDBAPIKey)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
 	var tvDetails TVDetails
-	if err := json.NewDecoder(resp.Body).Decode(&tvDetails); err != nil {
-		http.Error(w, "Failed to parse data", http.StatusInternalServerError)
+	err := s.tmdb.Get(r.Context(), "/tv/"+id, url.Values{"append_to_response": {tmdb.DetailsAppend}}, &tvDetails)
+	if err != nil {
+		tmdbError(w, err)
 		return
 	}
 
@@ -539,19 +656,10 @@ func (s *Server) handleMoviePage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	url := fmt.Sprintf("%s/movie/%s?api_key=%s&append_to_response=credits,videos,recommendations,external_ids",
-		tmdbBaseURL, id, s.config.TMDBAPIKey)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
 	var movieDetails MovieDetails
-	if err := json.NewDecoder(resp.Body).Decode(&movieDetails); err != nil {
-		http.Error(w, "Failed to parse data", http.StatusInternalServerError)
+	err := s.tmdb.Get(r.Context(), "/movie/"+id, url.Values{"append_to_response": {tmdb.DetailsAppend}}, &movieDetails)
+	if err != nil {
+		tmdbError(w, err)
 		return
 	}
 
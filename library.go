@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"strix/database"
+	"strix/release"
+)
+
+const (
+	libraryDefaultLimit = 24
+	libraryMaxLimit     = 100
+)
+
+// LibraryItem is the public shape of a title in /api/library; it carries no
+// Telegram chat, message or file IDs.
+type LibraryItem struct {
+	TMDBID       int       `json:"tmdb_id"`
+	MediaType    string    `json:"media_type"`
+	Title        string    `json:"title"`
+	Year         int       `json:"year,omitempty"`
+	Overview     string    `json:"overview,omitempty"`
+	PosterPath   string    `json:"poster_path,omitempty"`
+	BackdropPath string    `json:"backdrop_path,omitempty"`
+	Genres       []string  `json:"genres"`
+	Rating       float64   `json:"rating"`
+	Runtime      int       `json:"runtime,omitempty"`
+	AddedAt      time.Time `json:"added_at"`
+	Files        int       `json:"files"`
+	Qualities    []string  `json:"qualities"`
+	Codecs       []string  `json:"codecs"`
+}
+
+// librarySorts are the orders /api/library accepts.
+var librarySorts = []string{"recent", "title", "rating", "year"}
+
+// libraryCursor marks the last item of a page. It holds the fields every
+// sort compares on, so the next page starts right after it even if titles
+// were added in between.
+type libraryCursor struct {
+	Sort      string    `json:"s"`
+	TMDBID    int       `json:"i"`
+	MediaType string    `json:"m"`
+	Title     string    `json:"t,omitempty"`
+	Year      int       `json:"y,omitempty"`
+	Rating    float64   `json:"r,omitempty"`
+	AddedAt   time.Time `json:"a"`
+}
+
+func encodeLibraryCursor(sortBy string, g *database.LibraryGroup) string {
+	data, _ := json.Marshal(libraryCursor{
+		Sort:      sortBy,
+		TMDBID:    g.TMDBID,
+		MediaType: g.MediaType,
+		Title:     g.Title,
+		Year:      g.Year,
+		Rating:    g.Rating,
+		AddedAt:   g.AddedAt,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeLibraryCursor(raw string) (*libraryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var c libraryCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *libraryCursor) group() *database.LibraryGroup {
+	return &database.LibraryGroup{
+		TMDBID:    c.TMDBID,
+		MediaType: c.MediaType,
+		Title:     c.Title,
+		Year:      c.Year,
+		Rating:    c.Rating,
+		AddedAt:   c.AddedAt,
+	}
+}
+
+func libraryItem(g database.LibraryGroup) *LibraryItem {
+	item := &LibraryItem{
+		TMDBID:       g.TMDBID,
+		MediaType:    g.MediaType,
+		Title:        g.Title,
+		Year:         g.Year,
+		Overview:     g.Overview,
+		PosterPath:   g.PosterPath,
+		BackdropPath: g.BackdropPath,
+		Genres:       []string{},
+		Rating:       g.Rating,
+		Runtime:      g.Runtime,
+		AddedAt:      g.AddedAt,
+		Files:        g.Files,
+		Qualities:    []string{},
+		Codecs:       []string{},
+	}
+	if g.Genres != nil {
+		item.Genres = g.Genres
+	}
+	for _, q := range g.Qualities {
+		if q != "" && !containsFold(item.Qualities, q) {
+			item.Qualities = append(item.Qualities, q)
+		}
+	}
+	for _, c := range g.Codecs {
+		if c != "" && !containsFold(item.Codecs, c) {
+			item.Codecs = append(item.Codecs, c)
+		}
+	}
+	sort.Strings(item.Qualities)
+	sort.Strings(item.Codecs)
+	return item
+}
+
+// handleLibrary lists the library grouped by title:
+//
+//	GET /api/library?type=movie&genre=Drama&year_from=1990&year_to=1999
+//	    &quality=1080p&codec=hevc&added_since=7d&sort=rating&limit=24&cursor=…
+//
+// added_since takes a date, an RFC 3339 time or a duration such as 7d or
+// 48h. The response's next_cursor is empty on the last page.
+func (s *Server) handleLibrary(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseLibraryFilter(query.Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := libraryDefaultLimit
+	if limitParam := query.Get("limit"); limitParam != "" {
+		if limit, err = strconv.Atoi(limitParam); err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	limit = max(1, min(limit, libraryMaxLimit))
+	// One extra row tells whether another page follows.
+	filter.Limit = limit + 1
+
+	if raw := query.Get("cursor"); raw != "" {
+		cursor, err := decodeLibraryCursor(raw)
+		if err != nil || cursor.Sort != filter.Sort {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		filter.After = cursor.group()
+	}
+
+	groups, total, err := s.db.GetLibrary(filter)
+	if err != nil {
+		http.Error(w, "Failed to load library", http.StatusInternalServerError)
+		return
+	}
+
+	nextCursor := ""
+	if len(groups) > limit {
+		groups = groups[:limit]
+		nextCursor = encodeLibraryCursor(filter.Sort, &groups[limit-1])
+	}
+
+	items := make([]*LibraryItem, 0, len(groups))
+	for _, g := range groups {
+		items = append(items, libraryItem(g))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"items":       items,
+		"total":       total,
+		"next_cursor": nextCursor,
+	})
+}
+
+func parseLibraryFilter(get func(string) string) (database.LibraryQuery, error) {
+	f := database.LibraryQuery{
+		MediaType: get("type"),
+		Genre:     get("genre"),
+		Quality:   get("quality"),
+		Sort:      get("sort"),
+	}
+
+	if f.MediaType != "" && f.MediaType != "movie" && f.MediaType != "tv" {
+		return f, fmt.Errorf("type must be movie or tv")
+	}
+	if f.Sort == "" {
+		f.Sort = "recent"
+	}
+	if !slices.Contains(librarySorts, f.Sort) {
+		return f, fmt.Errorf("sort must be recent, title, rating or year")
+	}
+
+	// Accept codec aliases like x265 or h264 by running them through the
+	// same detection as release names.
+	if codec := get("codec"); codec != "" {
+		f.Codec = codec
+		if detected := release.Parse(codec).VideoCodec; detected != "" {
+			f.Codec = detected
+		}
+	}
+
+	var err error
+	for param, year := range map[string]*int{"year_from": &f.YearFrom, "year_to": &f.YearTo} {
+		if v := get(param); v != "" {
+			if *year, err = strconv.Atoi(v); err != nil {
+				return f, fmt.Errorf("invalid %s", param)
+			}
+		}
+	}
+
+	if v := get("added_since"); v != "" {
+		if f.AddedSince, err = parseSince(v, time.Now()); err != nil {
+			return f, fmt.Errorf("invalid added_since")
+		}
+	}
+	return f, nil
+}
+
+// parseSince reads a date (2006-01-02), an RFC 3339 time, or a duration back
+// from now such as 48h or 7d.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid duration %q", v)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid duration %q", v)
+	}
+	return now.Add(-d), nil
+}
+
+func containsFold(values []string, want string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, want) {
+			return true
+		}
+	}
+	return false
+}
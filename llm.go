@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"strix/config"
+	"strix/database"
+	"strix/telegram"
+)
+
+const llmTimeout = 60 * time.Second
+
+// LLMParser reads release names with a language model.
+type LLMParser interface {
+	// Model names the provider and model, recorded with cached results.
+	Model() string
+	// ParseNames reads a batch of file names and returns one entry per
+	// name, in order. An entry is nil when the model skipped that name.
+	ParseNames(ctx context.Context, names []string) ([]*LLMMetadata, error)
+}
+
+// LLMMetadata is what a model read from one file name.
+type LLMMetadata struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Year        int    `json:"year"`
+	Season      int    `json:"season"`
+	Episode     int    `json:"episode"`
+	LastEpisode int    `json:"last_episode"`
+	Quality     string `json:"quality"`
+}
+
+// llmResponseSchema is sent to providers that support structured output,
+// and every response is checked against it whether they do or not.
+var llmResponseSchema = map[string]any{
+	"type":                 "object",
+	"additionalProperties": false,
+	"required":             []any{"files"},
+	"properties": map[string]any{
+		"files": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"required":             []any{"id", "title", "year", "season", "episode", "last_episode", "quality"},
+				"properties": map[string]any{
+					"id":           map[string]any{"type": "integer", "minimum": 0},
+					"title":        map[string]any{"type": "string"},
+					"year":         map[string]any{"type": "integer", "minimum": 0, "maximum": 2100},
+					"season":       map[string]any{"type": "integer", "minimum": 0, "maximum": 100},
+					"episode":      map[string]any{"type": "integer", "minimum": 0, "maximum": 5000},
+					"last_episode": map[string]any{"type": "integer", "minimum": 0, "maximum": 5000},
+					"quality":      map[string]any{"type": "string", "enum": []any{"2160p", "1080p", "720p", "480p", ""}},
+				},
+			},
+		},
+	},
+}
+
+func newLLMParser(cfg *config.Config) LLMParser {
+	switch cfg.LLMProvider {
+	case "":
+		return nil
+	case "gemini":
+		if cfg.GeminiAPIKey == "" {
+			log.Printf("[LLM] LLM_PROVIDER is gemini but GEMINI_API_KEY is not set")
+			return nil
+		}
+		return NewGeminiParser(cfg.GeminiAPIKey, cfg.GeminiModel)
+	case "openai":
+		return NewOpenAIParser(cfg.OpenAIBaseURL, cfg.OpenAIAPIKey, cfg.OpenAIModel)
+	default:
+		log.Printf("[LLM] Unknown LLM_PROVIDER %q, using the release-name parser only", cfg.LLMProvider)
+		return nil
+	}
+}
+
+// llmPrompt asks for every name in one request; ids tie the answers back
+// to the names.
+func llmPrompt(names []string) string {
+	var list strings.Builder
+	for i, name := range names {
+		list.WriteString(fmt.Sprintf("%d: %s\n", i, name))
+	}
+
+	return fmt.Sprintf(`Extract metadata from each of these %d filenames:
+
+%s
+Return ONLY a valid JSON object with one entry per filename, using the number before the filename as its id:
+{
+  "files": [
+    {"id": 0, "title": "", "year": 0, "season": 0, "episode": 0, "last_episode": 0, "quality": ""}
+  ]
+}
+
+Rules:
+- title: clean title only, remove year, quality, codecs, release groups, torrent sites
+- title: remove usernames, like @Adrama_Lovers (case insensitive), and other usernames like that
+- title: remove dots, underscores, hyphens; replace with spaces
+- title: remove texts like remastered, extended, director's cut, dual audio, multi audio
+- title: purpose is to search the title on TMDB/IMDB, so title should be clean
+- title: remove E01, S01E01, 1080p, 720p, HDRip, WEB-DL, BluRay, x264, x265, etc.
+- title: remove Episode numbers from title, Season numbers too
+- title: remove everything that is not part of the actual title
+- title: remove episode name also if present
+- title: remove everything inside brackets (), [], {}
+- year: 0 if unknown or not present else return the year
+- season/episode: 0 if it's a movie, actual numbers if it's a TV show
+- episode: 0 for a whole-season pack with no episode number
+- season: 0 when an anime release counts episodes from the start of the show, like "[Group] Title - 137", with episode set to that number
+- last_episode: the last episode of a multi-episode file like S01E01E02 or S01E01-E03, else 0
+- quality: one of "2160p", "1080p", "720p", "480p", or ""
+- Return ONLY the JSON, no explanation`, len(names), list.String())
+}
+
+// decodeLLMResponse validates a model's answer against llmResponseSchema
+// and lines the entries up with the n names that were sent.
+func decodeLLMResponse(text string, n int) ([]*LLMMetadata, error) {
+	text = cleanJSONResponse(text)
+
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := validateSchema(llmResponseSchema, doc, "$"); err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Files []LLMMetadata `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(text), &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]*LLMMetadata, n)
+	for i := range resp.Files {
+		f := &resp.Files[i]
+		if f.ID >= n {
+			return nil, fmt.Errorf("$.files[%d].id: %d is out of range", i, f.ID)
+		}
+		if f.LastEpisode <= f.Episode {
+			f.LastEpisode = 0
+		}
+		results[f.ID] = f
+	}
+	return results, nil
+}
+
+// validateSchema checks a decoded JSON value against the subset of JSON
+// Schema that llmResponseSchema uses.
+func validateSchema(schema map[string]any, value any, path string) error {
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, key := range required {
+			if _, ok := obj[key.(string)]; !ok {
+				return fmt.Errorf("%s: missing %q", path, key)
+			}
+		}
+		for key, v := range obj {
+			sub, ok := properties[key].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected %q", path, key)
+				}
+				continue
+			}
+			if err := validateSchema(sub, v, path+"."+key); err != nil {
+				return err
+			}
+		}
+
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, v := range arr {
+			if err := validateSchema(items, v, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+		if enum, ok := schema["enum"].([]any); ok && !contains(enum, s) {
+			return fmt.Errorf("%s: %q is not one of %v", path, s, enum)
+		}
+
+	case "integer":
+		num, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+		n, err := num.Int64()
+		if err != nil {
+			return fmt.Errorf("%s: %s is not an integer", path, num)
+		}
+		if minimum, ok := schema["minimum"].(int); ok && n < int64(minimum) {
+			return fmt.Errorf("%s: %d is below %d", path, n, minimum)
+		}
+		if maximum, ok := schema["maximum"].(int); ok && n > int64(maximum) {
+			return fmt.Errorf("%s: %d is above %d", path, n, maximum)
+		}
+	}
+	return nil
+}
+
+func contains(values []any, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanJSONResponse cuts the JSON object out of replies that wrap it in
+// prose or code fences.
+func cleanJSONResponse(text string) string {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start == -1 || end < start {
+		return text
+	}
+	return text[start : end+1]
+}
+
+// filenameParser reads file names with the release-name parser and, when a
+// model is configured, with the model too. Model results are cached in the
+// database by normalized name.
+type filenameParser struct {
+	llm       LLMParser
+	db        database.DB
+	batchSize int
+}
+
+func newFilenameParser(cfg *config.Config, db database.DB) *filenameParser {
+	p := &filenameParser{
+		llm:       newLLMParser(cfg),
+		db:        db,
+		batchSize: cfg.LLMBatchSize,
+	}
+	if p.llm != nil {
+		log.Printf("[LLM] Parsing filenames with %s, %d per request", p.llm.Model(), p.batchSize)
+	}
+	return p
+}
+
+func (p *filenameParser) Parse(name string) *telegram.FileMetadata {
+	return p.ParseAll([]string{name})[0]
+}
+
+// ParseAll reads many names at once: cached model results are used as is,
+// and the rest go to the model batchSize names per request. Names the model
+// can't answer for fall back to the release-name parser.
+func (p *filenameParser) ParseAll(names []string) []*telegram.FileMetadata {
+	results := make([]*telegram.FileMetadata, len(names))
+	parsed := make([]*telegram.FileMetadata, len(names))
+	for i, name := range names {
+		parsed[i] = parseReleaseName(name)
+		results[i] = parsed[i]
+	}
+	if p.llm == nil {
+		return results
+	}
+
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = filenameCacheKey(name)
+	}
+
+	cached, err := p.db.GetParsedNames(keys)
+	if err != nil {
+		log.Printf("[LLM] Failed to load cached parses: %v", err)
+		cached = make(map[string]database.ParsedName)
+	}
+
+	var missing []int
+	queued := make(map[string]bool)
+	for i, key := range keys {
+		if _, ok := cached[key]; ok || queued[key] {
+			continue
+		}
+		queued[key] = true
+		missing = append(missing, i)
+	}
+
+	for start := 0; start < len(missing); start += p.batchSize {
+		batch := missing[start:min(start+p.batchSize, len(missing))]
+		for _, name := range p.parseBatch(names, keys, batch) {
+			cached[name.Key] = name
+		}
+	}
+
+	for i, key := range keys {
+		if c, ok := cached[key]; ok && c.Title != "" {
+			results[i] = crossCheck(names[i], c, parsed[i])
+		}
+	}
+	return results
+}
+
+// parseBatch sends the names at the given indexes to the model and caches
+// what comes back.
+func (p *filenameParser) parseBatch(names, keys []string, batch []int) []database.ParsedName {
+	batchNames := make([]string, len(batch))
+	for j, i := range batch {
+		batchNames[j] = names[i]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), llmTimeout)
+	defer cancel()
+
+	start := time.Now()
+	metadata, err := p.llm.ParseNames(ctx, batchNames)
+	if err != nil {
+		log.Printf("[LLM] Failed to parse %d names with %s: %v", len(batchNames), p.llm.Model(), err)
+		return nil
+	}
+
+	now := time.Now()
+	var results []database.ParsedName
+	for j, m := range metadata {
+		if m == nil {
+			continue
+		}
+		results = append(results, database.ParsedName{
+			Key:         keys[batch[j]],
+			Model:       p.llm.Model(),
+			Title:       m.Title,
+			Year:        m.Year,
+			Season:      m.Season,
+			Episode:     m.Episode,
+			LastEpisode: m.LastEpisode,
+			Quality:     m.Quality,
+			CreatedAt:   now,
+		})
+	}
+	log.Printf("[LLM] Parsed %d/%d names in %v", len(results), len(batchNames), time.Since(start).Round(time.Millisecond))
+
+	if err := p.db.SaveParsedNames(results); err != nil {
+		log.Printf("[LLM] Failed to cache parses: %v", err)
+	}
+	return results
+}
+
+// filenameCacheKey normalizes a name so copies that differ only in case,
+// separators or container share a cache entry.
+func filenameCacheKey(name string) string {
+	if isVideoFile(name) {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	var key bytes.Buffer
+	space := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && key.Len() > 0 {
+				key.WriteByte(' ')
+			}
+			key.WriteRune(r)
+			space = false
+		} else {
+			space = true
+		}
+	}
+	return key.String()
+}
+
+// crossCheck merges a model's reading of a name with the release-name
+// parser's. The model is better at titles, but years, episode numbers and
+// resolution the parser read straight from the name win when they
+// disagree, and a year that isn't in the name at all is dropped.
+func crossCheck(name string, m database.ParsedName, r *telegram.FileMetadata) *telegram.FileMetadata {
+	result := &telegram.FileMetadata{
+		Title:       m.Title,
+		Year:        m.Year,
+		Season:      m.Season,
+		Episode:     m.Episode,
+		LastEpisode: m.LastEpisode,
+		Quality:     m.Quality,
+		Release:     r.Release,
+	}
+
+	disagree := func(field string, model, parser any) {
+		log.Printf("[LLM] %s: model read %s %v, name says %v", name, field, model, parser)
+	}
+
+	switch {
+	case r.Year > 0 && m.Year != r.Year:
+		disagree("year", m.Year, r.Year)
+		result.Year = r.Year
+	case r.Year == 0 && m.Year > 0 && !strings.Contains(name, strconv.Itoa(m.Year)):
+		result.Year = 0
+	}
+
+	if r.Season > 0 || r.Episode > 0 {
+		if m.Season != r.Season || m.Episode != r.Episode || m.LastEpisode != r.LastEpisode {
+			disagree("episode", []int{m.Season, m.Episode, m.LastEpisode}, []int{r.Season, r.Episode, r.LastEpisode})
+			result.Season, result.Episode, result.LastEpisode = r.Season, r.Episode, r.LastEpisode
+		}
+	}
+	result.Absolute = result.Season == 0 && result.Episode > 0
+
+	if r.Quality != "" && m.Quality != r.Quality {
+		disagree("quality", m.Quality, r.Quality)
+		result.Quality = r.Quality
+	}
+	return result
+}
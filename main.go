@@ -4,38 +4,67 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 
+	"strix/cache"
 	"strix/config"
 	"strix/database"
+	"strix/search"
+	"strix/source"
 	"strix/telegram"
+	"strix/tmdb"
 
 	"github.com/gorilla/mux"
 )
 
-const (
-	tmdbBaseURL = "https://api.themoviedb.org/3"
-)
-
 type Server struct {
 	config *config.Config
-	db     *database.DB
+	db     database.DB
 	router *mux.Router
+	local  *source.LocalDir
+	tmdb   *tmdb.Client
+	search *search.IndexedDB
 }
 
 func main() {
 	cfg := config.Load()
 
-	db, err := database.Init(cfg.MongoURL, cfg.DBName)
+	db, err := database.Open(cfg.DBBackend, cfg.MongoURL, cfg.DBName, cfg.SQLitePath)
 
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
-	telegram.ParseFilenameFunc = parseFilename
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(db, os.Args[2:])
+		return
+	}
+
+	if cfg.AutoMigrate {
+		if _, err := db.Migrate(false); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	indexed, err := search.Wrap(db)
+	if err != nil {
+		log.Fatal("Failed to build search index:", err)
+	}
+	db = indexed
+
+	names := newFilenameParser(cfg, db)
+	telegram.ParseFilenameFunc = names.Parse
+	telegram.ParseFilenamesFunc = names.ParseAll
 	telegram.IsVideoFileFunc = isVideoFile
-	telegram.ExtractCodecFunc = extractCodec
-	telegram.GeminiAPIKey = cfg.GeminiAPIKey
+	telegram.ParseReleaseFunc = parseRelease
+
+	tmdbClient := tmdb.New(cfg.TMDBAPIKey, tmdb.Options{
+		BaseURL:  cfg.TMDBBaseURL,
+		Language: cfg.TMDBLanguage,
+		CacheTTL: cfg.TMDBCacheTTL,
+	})
+	telegram.TMDB = tmdbClient
 
 	if err := telegram.InitBot(cfg, db); err != nil {
 		log.Fatal("Failed to initialize Telegram bot:", err)
@@ -45,13 +74,28 @@ func main() {
 		log.Fatal("Failed to create files directory:", err)
 	}
 
+	localFiles := source.NewLocalDir(cfg.FilesDir, "cache")
+	telegram.LocalFiles = localFiles
+
+	if cfg.ChunkCacheMB > 0 {
+		chunkCache, err := cache.NewChunkCache(filepath.Join(cfg.FilesDir, "cache"), cfg.ChunkCacheMB*1024*1024)
+		if err != nil {
+			log.Fatal("Failed to initialize chunk cache:", err)
+		}
+		telegram.ChunkCache = chunkCache
+	}
+
 	server := &Server{
 		config: cfg,
 		db:     db,
 		router: mux.NewRouter(),
+		local:  localFiles,
+		tmdb:   tmdbClient,
+		search: indexed,
 	}
 
 	server.setupRoutes()
+	server.startSubtitleSweeper()
 
 	log.Printf("→ Server starting on port %s", cfg.Port)
 	log.Fatal(http.ListenAndServe(":"+cfg.Port, server.router))
@@ -66,20 +110,25 @@ func (s *Server) setupRoutes() {
 
 	api := s.router.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/search", s.handleSearch).Methods("GET")
+	api.HandleFunc("/suggest", s.handleSuggest).Methods("GET")
 	api.HandleFunc("/tv/{id:[0-9]+}", s.handleTVDetails).Methods("GET")
 	api.HandleFunc("/tv/{id:[0-9]+}/season/{season:[0-9]+}", s.handleSeasonDetails).Methods("GET")
 	api.HandleFunc("/movie/{id:[0-9]+}", s.handleMovieDetails).Methods("GET")
 	api.HandleFunc("/trending", s.handleTrending).Methods("GET")
+	api.HandleFunc("/titles/recent", s.handleRecentTitles).Methods("GET")
+	api.HandleFunc("/library", s.handleLibrary).Methods("GET")
 	api.HandleFunc("/files", s.handleListFiles).Methods("GET")
 	api.HandleFunc("/files/search", s.handleSearchFiles).Methods("GET")
 	api.HandleFunc("/imdb/{imdb_id}", s.handleIMDBRating).Methods("GET")
 	api.HandleFunc("/media/movie/{tmdb_id:[0-9]+}", s.handleGetMovieFiles).Methods("GET")
 	api.HandleFunc("/media/tv/{tmdb_id:[0-9]+}/season/{season:[0-9]+}", s.handleGetSeasonFiles).Methods("GET")
 	api.HandleFunc("/media/tv/{tmdb_id:[0-9]+}/season/{season:[0-9]+}/episode/{episode:[0-9]+}", s.handleGetEpisodeFile).Methods("GET")
+	api.HandleFunc("/tracks/{token}", s.handleTracks).Methods("GET")
 
 	s.router.HandleFunc("/search", s.handleSearchFiles).Methods("GET")
 
-	s.router.HandleFunc("/stream/{token}", s.handleStream).Methods("GET")
+	s.router.HandleFunc("/stream/{token}", s.handleStream).Methods("GET", "HEAD")
+	s.router.HandleFunc("/subtitles/{token}/{track:[0-9]+}.vtt", s.handleSubtitles).Methods("GET")
 	s.router.HandleFunc("/play", s.handleStreamPage).Methods("GET")
 	s.router.HandleFunc("/", s.handleHome).Methods("GET")
 	s.router.HandleFunc("/ffmpeg", s.handleFFmpeg).Methods("GET")
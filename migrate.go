@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"strix/database"
+)
+
+// runMigrate implements `strix migrate [-dry-run]`.
+func runMigrate(db database.DB, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report pending migrations without applying them")
+	flags.Parse(args)
+
+	results, err := db.Migrate(*dryRun)
+	for _, r := range results {
+		switch {
+		case r.AlreadyApplied:
+			fmt.Printf("%3d  %-60s already applied\n", r.Version, r.Description)
+		case *dryRun:
+			fmt.Printf("%3d  %-60s pending: %s\n", r.Version, r.Description, r.Summary)
+		default:
+			fmt.Printf("%3d  %-60s %s\n", r.Version, r.Description, r.Summary)
+		}
+	}
+	if err != nil {
+		log.Fatal("Migration failed:", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type OpenAIRequest struct {
+	Model          string               `json:"model"`
+	Messages       []OpenAIMessage      `json:"messages"`
+	Temperature    float64              `json:"temperature"`
+	ResponseFormat OpenAIResponseFormat `json:"response_format"`
+}
+
+type OpenAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type OpenAIResponseFormat struct {
+	Type       string           `json:"type"`
+	JSONSchema OpenAIJSONSchema `json:"json_schema"`
+}
+
+type OpenAIJSONSchema struct {
+	Name   string         `json:"name"`
+	Strict bool           `json:"strict"`
+	Schema map[string]any `json:"schema"`
+}
+
+type OpenAIResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// OpenAIParser parses file names with any server speaking the OpenAI chat
+// completions API, such as OpenAI itself, OpenRouter, Ollama or llama.cpp.
+type OpenAIParser struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func NewOpenAIParser(baseURL, apiKey, model string) *OpenAIParser {
+	return &OpenAIParser{baseURL: baseURL, apiKey: apiKey, model: model, client: &http.Client{}}
+}
+
+func (o *OpenAIParser) Model() string {
+	return "openai/" + o.model
+}
+
+func (o *OpenAIParser) ParseNames(ctx context.Context, names []string) ([]*LLMMetadata, error) {
+	reqBody := OpenAIRequest{
+		Model: o.model,
+		Messages: []OpenAIMessage{
+			{Role: "user", Content: llmPrompt(names)},
+		},
+		ResponseFormat: OpenAIResponseFormat{
+			Type: "json_schema",
+			JSONSchema: OpenAIJSONSchema{
+				Name:   "filenames",
+				Strict: true,
+				Schema: llmResponseSchema,
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("openai API error: %s", string(body))
+	}
+
+	var openaiResp OpenAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&openaiResp); err != nil {
+		return nil, err
+	}
+
+	if len(openaiResp.Choices) == 0 {
+		return nil, fmt.Errorf("no response from %s", o.model)
+	}
+
+	metadata, err := decodeLLMResponse(openaiResp.Choices[0].Message.Content, len(names))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse openai response: %w", err)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,464 @@
+package probe
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// Matroska element IDs, with their length marker bits kept.
+const (
+	idEBML          = 0x1A45DFA3
+	idDocType       = 0x4282
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114D9B74
+	idSeek          = 0x4DBB
+	idSeekID        = 0x53AB
+	idSeekPosition  = 0x53AC
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackNumber   = 0xD7
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idLanguage      = 0x22B59C
+	idLanguageIETF  = 0x22B59D
+	idName          = 0x536E
+	idFlagDefault   = 0x88
+	idFlagForced    = 0x55AA
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+	idColour        = 0x55B0
+	idTransfer      = 0x55BA
+	idAudio         = 0xE1
+	idChannels      = 0x9F
+	idBlockAddMap   = 0x41E4
+	idBlockAddType  = 0x41E7
+	idCluster       = 0x1F43B675
+)
+
+const (
+	trackVideo    = 1
+	trackAudio    = 2
+	trackSubtitle = 17
+)
+
+// Dolby Vision configuration records in BlockAdditionMapping.
+const (
+	blockAddDVCC = 0x64766343
+	blockAddDVVC = 0x64767643
+)
+
+// unknownSize marks a live-written element whose size wasn't filled in.
+const unknownSize = -1
+
+// defaultScale is the timecode tick, in nanoseconds, of segments that
+// don't set one.
+const defaultScale = 1000000
+
+// maxTopLevel bounds the walk over a segment's children before the first
+// cluster.
+const maxTopLevel = 64
+
+var mkvCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "AVC",
+	"V_MPEGH/ISO/HEVC": "HEVC",
+	"V_AV1":            "AV1",
+	"V_VP9":            "VP9",
+	"V_VP8":            "VP8",
+	"V_MPEG4/ISO/ASP":  "MPEG-4",
+	"V_MPEG2":          "MPEG2",
+	"V_MS/VFW/FOURCC":  "VFW",
+	"A_AAC":            "AAC",
+	"A_AC3":            "AC3",
+	"A_EAC3":           "EAC3",
+	"A_DTS":            "DTS",
+	"A_TRUEHD":         "TrueHD",
+	"A_MLP":            "TrueHD",
+	"A_FLAC":           "FLAC",
+	"A_OPUS":           "Opus",
+	"A_VORBIS":         "Vorbis",
+	"A_MPEG/L3":        "MP3",
+	"A_MPEG/L2":        "MP2",
+	"A_PCM/INT/LIT":    "PCM",
+	"A_PCM/INT/BIG":    "PCM",
+	"A_PCM/FLOAT/IEEE": "PCM",
+	"S_TEXT/UTF8":      "SRT",
+	"S_TEXT/ASCII":     "SRT",
+	"S_TEXT/ASS":       "ASS",
+	"S_ASS":            "ASS",
+	"S_TEXT/SSA":       "SSA",
+	"S_SSA":            "SSA",
+	"S_TEXT/WEBVTT":    "WebVTT",
+	"S_HDMV/PGS":       "PGS",
+	"S_VOBSUB":         "VobSub",
+	"S_DVBSUB":         "DVB",
+}
+
+func mkvCodec(id string) string {
+	if codec, ok := mkvCodecs[id]; ok {
+		return codec
+	}
+	// AAC used to be written with its profile, as in A_AAC/MPEG4/LC.
+	if strings.HasPrefix(id, "A_AAC") {
+		return "AAC"
+	}
+	if strings.HasPrefix(id, "A_DTS") {
+		return "DTS"
+	}
+	return id
+}
+
+// vint reads an EBML variable-length integer. IDs keep their length marker,
+// sizes don't; a size with every value bit set is unknown.
+func vint(b []byte, marker bool) (value int64, n int, ok bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false
+	}
+	n = 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || len(b) < n {
+		return 0, 0, false
+	}
+
+	first := b[0]
+	if !marker {
+		first &= 0xFF >> n
+	}
+	value = int64(first)
+	allOnes := first == 0xFF>>n
+	for _, c := range b[1:n] {
+		value = value<<8 | int64(c)
+		allOnes = allOnes && c == 0xFF
+	}
+	if !marker && allOnes {
+		return unknownSize, n, true
+	}
+	return value, n, true
+}
+
+// elementHeader reads an element's ID and size, returning the header
+// length.
+func elementHeader(b []byte) (id uint32, size int64, n int, ok bool) {
+	rawID, idLen, ok := vint(b, true)
+	if !ok || idLen > 4 {
+		return 0, 0, 0, false
+	}
+	size, sizeLen, ok := vint(b[idLen:], false)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return uint32(rawID), size, idLen + sizeLen, true
+}
+
+// children calls fn for each element in b. An element running past the end
+// of b is passed truncated.
+func children(b []byte, fn func(id uint32, data []byte)) {
+	for len(b) > 0 {
+		id, size, n, ok := elementHeader(b)
+		if !ok {
+			return
+		}
+		b = b[n:]
+		if size == unknownSize || size > int64(len(b)) {
+			size = int64(len(b))
+		}
+		fn(id, b[:size])
+		b = b[size:]
+	}
+}
+
+func readUint(b []byte) int64 {
+	if len(b) > 8 {
+		return 0
+	}
+	var v int64
+	for _, c := range b {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func readFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func readString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
+
+// mkvFile is the layout of a Matroska segment, as read from its headers.
+type mkvFile struct {
+	r            *reader
+	info         *Info
+	segmentStart int64
+	segmentEnd   int64
+	// body is where the walk over the segment's headers stopped, at or
+	// before the first cluster.
+	body  int64
+	seeks map[uint32]int64
+	// scale is the length of a timecode tick in nanoseconds.
+	scale  int64
+	tracks []byte
+}
+
+func probeMKV(r *reader) (*Info, error) {
+	f, err := openMKV(r)
+	if err != nil {
+		return nil, err
+	}
+	return f.info, nil
+}
+
+func openMKV(r *reader) (*mkvFile, error) {
+	id, size, n, ok := elementHeader(r.head)
+	if !ok || id != idEBML || size == unknownSize || int64(n)+size > int64(len(r.head)) {
+		return nil, ErrUnsupported
+	}
+
+	f := &mkvFile{
+		r:     r,
+		info:  &Info{Container: "mkv"},
+		seeks: make(map[uint32]int64),
+		scale: defaultScale,
+	}
+	children(r.head[n:int64(n)+size], func(id uint32, data []byte) {
+		if id == idDocType && readString(data) == "webm" {
+			f.info.Container = "webm"
+		}
+	})
+
+	segment := int64(n) + size
+	header, err := r.read(segment, 12)
+	if err != nil {
+		return nil, err
+	}
+	id, size, n, ok = elementHeader(header)
+	if !ok || id != idSegment {
+		return nil, ErrUnsupported
+	}
+	f.segmentStart = segment + int64(n)
+	f.segmentEnd = r.size
+	if size != unknownSize {
+		f.segmentEnd = min(f.segmentStart+size, r.size)
+	}
+
+	var gotInfo, gotTracks bool
+
+	parse := func(id uint32, data []byte) {
+		switch id {
+		case idSeekHead:
+			parseSeekHead(data, f.seeks)
+		case idInfo:
+			f.scale = parseSegmentInfo(data, f.info)
+			gotInfo = true
+		case idTracks:
+			parseTracks(data, f.info)
+			f.tracks = data
+			gotTracks = true
+		}
+	}
+
+	f.body = f.segmentStart
+	for i := 0; i < maxTopLevel && f.body < f.segmentEnd && !(gotInfo && gotTracks); i++ {
+		header, err := r.read(f.body, 12)
+		if err != nil {
+			return nil, err
+		}
+		id, size, n, ok := elementHeader(header)
+		if !ok || id == idCluster || size == unknownSize {
+			break
+		}
+
+		if id == idSeekHead || id == idInfo || id == idTracks {
+			data, err := r.read(f.body+int64(n), size)
+			if err != nil {
+				return nil, err
+			}
+			parse(id, data)
+		}
+		f.body += int64(n) + size
+	}
+
+	// Info and Tracks written after the clusters are found through the
+	// SeekHead instead.
+	for _, id := range []uint32{idInfo, idTracks} {
+		if (id == idInfo && gotInfo) || (id == idTracks && gotTracks) {
+			continue
+		}
+		data, err := f.element(id)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			parse(id, data)
+		}
+	}
+
+	if !gotTracks {
+		return nil, ErrUnsupported
+	}
+	return f, nil
+}
+
+// element reads the body of the top-level element the SeekHead points to,
+// or returns nil if it doesn't list one.
+func (f *mkvFile) element(id uint32) ([]byte, error) {
+	offset, ok := f.seeks[id]
+	if !ok {
+		return nil, nil
+	}
+	header, err := f.r.read(f.segmentStart+offset, 12)
+	if err != nil {
+		return nil, err
+	}
+	found, size, n, ok := elementHeader(header)
+	if !ok || found != id || size == unknownSize {
+		return nil, nil
+	}
+	return f.r.read(f.segmentStart+offset+int64(n), size)
+}
+
+func parseSeekHead(b []byte, seeks map[uint32]int64) {
+	children(b, func(id uint32, data []byte) {
+		if id != idSeek {
+			return
+		}
+		var target uint32
+		position := int64(-1)
+		children(data, func(id uint32, data []byte) {
+			switch id {
+			case idSeekID:
+				target = uint32(readUint(data))
+			case idSeekPosition:
+				position = readUint(data)
+			}
+		})
+		if _, seen := seeks[target]; target != 0 && position >= 0 && !seen {
+			seeks[target] = position
+		}
+	})
+}
+
+// parseSegmentInfo reads the duration into info and returns the timecode
+// scale.
+func parseSegmentInfo(b []byte, info *Info) int64 {
+	scale := int64(defaultScale)
+	var duration float64
+	children(b, func(id uint32, data []byte) {
+		switch id {
+		case idTimecodeScale:
+			if s := readUint(data); s > 0 {
+				scale = s
+			}
+		case idDuration:
+			duration = readFloat(data)
+		}
+	})
+	info.Duration = time.Duration(duration * float64(scale))
+	return scale
+}
+
+func parseTracks(b []byte, info *Info) {
+	children(b, func(id uint32, data []byte) {
+		if id == idTrackEntry {
+			parseTrackEntry(data, info)
+		}
+	})
+}
+
+func parseTrackEntry(b []byte, info *Info) {
+	track := Track{Default: true}
+	var kind int64
+	var codecID, legacyLanguage, ietfLanguage string
+	var width, height, transfer int
+	var dolbyVision bool
+	channels := 1
+	legacyLanguage = "eng"
+
+	children(b, func(id uint32, data []byte) {
+		switch id {
+		case idTrackNumber:
+			track.Number = int(readUint(data))
+		case idTrackType:
+			kind = readUint(data)
+		case idCodecID:
+			codecID = readString(data)
+		case idLanguage:
+			legacyLanguage = readString(data)
+		case idLanguageIETF:
+			ietfLanguage = readString(data)
+		case idName:
+			track.Title = readString(data)
+		case idFlagDefault:
+			track.Default = readUint(data) == 1
+		case idFlagForced:
+			track.Forced = readUint(data) == 1
+		case idVideo:
+			children(data, func(id uint32, data []byte) {
+				switch id {
+				case idPixelWidth:
+					width = int(readUint(data))
+				case idPixelHeight:
+					height = int(readUint(data))
+				case idColour:
+					children(data, func(id uint32, data []byte) {
+						if id == idTransfer {
+							transfer = int(readUint(data))
+						}
+					})
+				}
+			})
+		case idAudio:
+			children(data, func(id uint32, data []byte) {
+				if id == idChannels {
+					channels = int(readUint(data))
+				}
+			})
+		case idBlockAddMap:
+			children(data, func(id uint32, data []byte) {
+				if id == idBlockAddType {
+					t := readUint(data)
+					dolbyVision = dolbyVision || t == blockAddDVCC || t == blockAddDVVC
+				}
+			})
+		}
+	})
+
+	track.Codec = mkvCodec(codecID)
+	track.Language = language(legacyLanguage)
+	if ietfLanguage != "" {
+		track.Language = language(ietfLanguage)
+	}
+
+	switch kind {
+	case trackVideo:
+		// The first video track is the movie; later ones are usually cover
+		// art or a Dolby Vision enhancement layer.
+		if info.VideoCodec != "" {
+			return
+		}
+		info.VideoCodec = track.Codec
+		info.Width, info.Height = width, height
+		info.HDR = hdrLabel(dolbyVision, transfer)
+	case trackAudio:
+		track.Channels = channels
+		info.Audio = append(info.Audio, track)
+	case trackSubtitle:
+		info.Subtitles = append(info.Subtitles, track)
+	}
+}
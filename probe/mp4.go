@@ -0,0 +1,340 @@
+package probe
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// maxBoxes bounds the walk over an MP4's top-level boxes.
+const maxBoxes = 64
+
+var mp4Codecs = map[string]string{
+	"avc1": "AVC", "avc3": "AVC", "dva1": "AVC", "dvav": "AVC",
+	"hvc1": "HEVC", "hev1": "HEVC", "dvh1": "HEVC", "dvhe": "HEVC",
+	"av01": "AV1", "vp09": "VP9", "vp08": "VP8", "mp4v": "MPEG-4",
+	"mp4a": "AAC", "ac-3": "AC3", "ec-3": "EAC3", "Opus": "Opus",
+	"fLaC": "FLAC", "alac": "ALAC", "mlpa": "TrueHD", ".mp3": "MP3",
+	"dtsc": "DTS", "dtsh": "DTS", "dtsl": "DTS", "dtse": "DTS",
+	"lpcm": "PCM", "sowt": "PCM", "twos": "PCM", "ipcm": "PCM",
+	"tx3g": "TX3G", "wvtt": "WebVTT", "stpp": "TTML", "c608": "CEA-608",
+}
+
+// dolbyVisionEntries carry Dolby Vision video, with or without a
+// configuration box.
+var dolbyVisionEntries = map[string]bool{
+	"dva1": true, "dvav": true, "dvh1": true, "dvhe": true,
+}
+
+// ac3Channels counts the full-range channels of each AC-3 acmod.
+var ac3Channels = [8]int{2, 1, 2, 3, 3, 4, 4, 5}
+
+func isTopLevelBox(kind string) bool {
+	switch kind {
+	case "ftyp", "moov", "free", "skip", "wide", "mdat", "pdin":
+		return true
+	}
+	return false
+}
+
+// box reads a box header, returning its type, total size and header
+// length. A size of 0 means the box runs to the end of the file.
+func box(b []byte) (kind string, size int64, n int, ok bool) {
+	if len(b) < 8 {
+		return "", 0, 0, false
+	}
+	size = int64(binary.BigEndian.Uint32(b))
+	kind = string(b[4:8])
+	n = 8
+	if size == 1 {
+		if len(b) < 16 {
+			return "", 0, 0, false
+		}
+		size = int64(binary.BigEndian.Uint64(b[8:]))
+		n = 16
+	}
+	if size != 0 && size < int64(n) {
+		return "", 0, 0, false
+	}
+	return kind, size, n, true
+}
+
+// boxes calls fn with the body of each box in b.
+func boxes(b []byte, fn func(kind string, body []byte)) {
+	for len(b) > 0 {
+		kind, size, n, ok := box(b)
+		if !ok {
+			return
+		}
+		if size == 0 || size > int64(len(b)) {
+			size = int64(len(b))
+		}
+		fn(kind, b[n:size])
+		b = b[size:]
+	}
+}
+
+func probeMP4(r *reader) (*Info, error) {
+	info := &Info{Container: "mp4"}
+
+	var pos int64
+	for i := 0; i < maxBoxes && pos < r.size; i++ {
+		header, err := r.read(pos, 16)
+		if err != nil {
+			return nil, err
+		}
+		kind, size, n, ok := box(header)
+		if !ok {
+			break
+		}
+		if size == 0 {
+			size = r.size - pos
+		}
+
+		switch kind {
+		case "ftyp":
+			if len(header) >= 12 && string(header[n:n+4]) == "qt  " {
+				info.Container = "mov"
+			}
+		case "moov":
+			body, err := r.read(pos+int64(n), size-int64(n))
+			if err != nil {
+				return nil, err
+			}
+			parseMoov(body, info)
+			return info, nil
+		}
+		pos += size
+	}
+	return nil, ErrUnsupported
+}
+
+func parseMoov(b []byte, info *Info) {
+	var timescale, duration int64
+	boxes(b, func(kind string, body []byte) {
+		switch kind {
+		case "mvhd":
+			timescale, duration = mvhdTimes(body)
+		case "mvex":
+			// Fragmented files leave the movie header's duration empty.
+			boxes(body, func(kind string, body []byte) {
+				if kind == "mehd" && duration == 0 && len(body) >= 8 {
+					if body[0] == 1 && len(body) >= 12 {
+						duration = int64(binary.BigEndian.Uint64(body[4:]))
+					} else {
+						duration = int64(binary.BigEndian.Uint32(body[4:]))
+					}
+				}
+			})
+		case "trak":
+			parseTrak(body, info)
+		}
+	})
+	if timescale > 0 {
+		info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+}
+
+// mvhdTimes reads the movie's timescale and duration, which follow its
+// creation and modification times.
+func mvhdTimes(b []byte) (timescale, duration int64) {
+	if len(b) < 4 {
+		return 0, 0
+	}
+	offset := 12
+	if b[0] == 1 {
+		offset = 20
+		if len(b) < offset+12 {
+			return 0, 0
+		}
+		return int64(binary.BigEndian.Uint32(b[offset:])), int64(binary.BigEndian.Uint64(b[offset+4:]))
+	}
+	if len(b) < offset+8 {
+		return 0, 0
+	}
+	return int64(binary.BigEndian.Uint32(b[offset:])), int64(binary.BigEndian.Uint32(b[offset+4:]))
+}
+
+type mp4Track struct {
+	id          int
+	enabled     bool
+	width       int
+	height      int
+	handler     string
+	language    string
+	entry       string
+	channels    int
+	transfer    int
+	dolbyVision bool
+}
+
+func parseTrak(b []byte, info *Info) {
+	var t mp4Track
+	boxes(b, func(kind string, body []byte) {
+		switch kind {
+		case "tkhd":
+			parseTkhd(body, &t)
+		case "mdia":
+			boxes(body, func(kind string, body []byte) {
+				switch kind {
+				case "mdhd":
+					t.language = mdhdLanguage(body)
+				case "hdlr":
+					if len(body) >= 12 {
+						t.handler = string(body[8:12])
+					}
+				case "minf":
+					boxes(body, func(kind string, body []byte) {
+						if kind != "stbl" {
+							return
+						}
+						boxes(body, func(kind string, body []byte) {
+							if kind == "stsd" {
+								parseStsd(body, &t)
+							}
+						})
+					})
+				}
+			})
+		}
+	})
+
+	track := Track{
+		Number:   t.id,
+		Codec:    mp4Codecs[t.entry],
+		Language: language(t.language),
+		Default:  t.enabled,
+	}
+	if track.Codec == "" {
+		track.Codec = t.entry
+	}
+
+	switch t.handler {
+	case "vide":
+		if info.VideoCodec != "" {
+			return
+		}
+		info.VideoCodec = track.Codec
+		info.Width, info.Height = t.width, t.height
+		info.HDR = hdrLabel(t.dolbyVision, t.transfer)
+	case "soun":
+		track.Channels = t.channels
+		// Every enabled MP4 track is eligible; the first one plays.
+		track.Default = t.enabled && len(info.Audio) == 0
+		info.Audio = append(info.Audio, track)
+	case "sbtl", "subt", "text", "clcp":
+		track.Default = false
+		info.Subtitles = append(info.Subtitles, track)
+	}
+}
+
+func parseTkhd(b []byte, t *mp4Track) {
+	if len(b) < 4 {
+		return
+	}
+	t.enabled = b[3]&1 == 1
+
+	idAt, sizeAt := 12, 76
+	if b[0] == 1 {
+		idAt, sizeAt = 20, 88
+	}
+	if len(b) >= idAt+4 {
+		t.id = int(binary.BigEndian.Uint32(b[idAt:]))
+	}
+	if len(b) >= sizeAt+8 {
+		t.width = int(binary.BigEndian.Uint32(b[sizeAt:]) >> 16)
+		t.height = int(binary.BigEndian.Uint32(b[sizeAt+4:]) >> 16)
+	}
+}
+
+// mdhdLanguage unpacks the three 5-bit letters of an ISO 639-2 code.
+func mdhdLanguage(b []byte) string {
+	at := 20
+	if len(b) > 0 && b[0] == 1 {
+		at = 32
+	}
+	if len(b) < at+2 {
+		return ""
+	}
+	packed := binary.BigEndian.Uint16(b[at:])
+	if packed == 0 || packed == 0x7FFF {
+		return ""
+	}
+	code := []byte{
+		byte(packed>>10&0x1F) + 0x60,
+		byte(packed>>5&0x1F) + 0x60,
+		byte(packed&0x1F) + 0x60,
+	}
+	return string(code)
+}
+
+// parseStsd reads the track's first sample entry: its codec, and the
+// picture size, colour and channel layout stored with it.
+func parseStsd(b []byte, t *mp4Track) {
+	if len(b) < 8 {
+		return
+	}
+	kind, size, _, ok := box(b[8:])
+	if !ok {
+		return
+	}
+	entry := b[8:]
+	if size > 0 && size <= int64(len(entry)) {
+		entry = entry[:size]
+	}
+	t.entry = kind
+	t.dolbyVision = dolbyVisionEntries[kind]
+
+	switch t.handler {
+	case "vide":
+		if len(entry) < 86 {
+			return
+		}
+		if t.width == 0 || t.height == 0 {
+			t.width = int(binary.BigEndian.Uint16(entry[32:]))
+			t.height = int(binary.BigEndian.Uint16(entry[34:]))
+		}
+		boxes(entry[86:], func(kind string, body []byte) {
+			switch kind {
+			case "colr":
+				if len(body) >= 8 && (string(body[:4]) == "nclx" || string(body[:4]) == "nclc") {
+					t.transfer = int(binary.BigEndian.Uint16(body[6:]))
+				}
+			case "dvcC", "dvvC", "dvwC":
+				t.dolbyVision = true
+			}
+		})
+
+	case "soun":
+		if len(entry) < 36 {
+			return
+		}
+		t.channels = int(binary.BigEndian.Uint16(entry[24:]))
+		extra := entry[36:]
+		// QuickTime sound descriptions grow with their version.
+		switch binary.BigEndian.Uint16(entry[16:]) {
+		case 1:
+			extra = entry[min(52, len(entry)):]
+		case 2:
+			extra = entry[min(72, len(entry)):]
+			if len(entry) >= 52 {
+				t.channels = int(binary.BigEndian.Uint32(entry[48:]))
+			}
+		}
+		boxes(extra, func(kind string, body []byte) {
+			switch kind {
+			case "dac3":
+				if len(body) >= 3 {
+					t.channels = ac3Channels[body[1]>>3&7] + int(body[1]>>2&1)
+				}
+			case "dec3":
+				if len(body) >= 5 {
+					t.channels = ac3Channels[body[3]>>1&7] + int(body[3]&1)
+				}
+			case "dOps":
+				if len(body) >= 2 {
+					t.channels = int(body[1])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Package probe reads video properties from MKV and MP4 container headers
+// without decoding anything, fetching as little of the file as it can. It
+// also pulls text subtitle tracks out of MKV files.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"strix/source"
+)
+
+const (
+	// windowSize is read from each end of the file up front. Track headers
+	// are almost always in the first window, and an MP4 written without
+	// faststart keeps its index in the last.
+	windowSize = 1024 * 1024
+	// maxElement caps a single header element fetched outside the windows,
+	// such as a long movie's MP4 index.
+	maxElement = 64 * 1024 * 1024
+	// pageSize is read around small headers outside the windows, so
+	// neighbouring ones don't each cost a request.
+	pageSize = 64 * 1024
+)
+
+// ErrUnsupported is returned for files that aren't MKV or MP4, or whose
+// headers can't be read. Other errors come from reading the file.
+var ErrUnsupported = errors.New("unsupported container")
+
+// Info describes a file's streams.
+type Info struct {
+	Container  string
+	Width      int
+	Height     int
+	Duration   time.Duration
+	VideoCodec string
+	// HDR is "HDR10", "HLG", "DV" or "DV HDR10"; empty for SDR.
+	HDR       string
+	Audio     []Track
+	Subtitles []Track
+}
+
+// Track is one audio or subtitle stream. Number is the MKV track number or
+// the MP4 track ID.
+type Track struct {
+	Number   int
+	Codec    string
+	Language string
+	Title    string
+	Channels int
+	Default  bool
+	Forced   bool
+}
+
+// Resolution names the video's quality the way release names do. Width is
+// checked as well as height so cropped widescreen video, like 1920x800,
+// still counts as 1080p.
+func (i *Info) Resolution() string {
+	switch {
+	case i.Width == 0 && i.Height == 0:
+		return ""
+	case i.Width >= 3200 || i.Height >= 1800:
+		return "2160p"
+	case i.Width >= 1700 || i.Height >= 1000:
+		return "1080p"
+	case i.Width >= 1200 || i.Height >= 700:
+		return "720p"
+	case i.Width >= 640 || i.Height >= 460:
+		return "480p"
+	}
+	return fmt.Sprintf("%dp", i.Height)
+}
+
+// Probe reads src's container headers.
+func Probe(ctx context.Context, src source.MediaSource) (*Info, error) {
+	stat, err := src.Stat(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newReader(ctx, src, stat.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(r.head, ebmlMagic):
+		return probeMKV(r)
+	case len(r.head) >= 8 && isTopLevelBox(string(r.head[4:8])):
+		return probeMP4(r)
+	}
+	return nil, ErrUnsupported
+}
+
+// reader serves byte ranges of a file from the windows read at each end,
+// fetching anything outside them.
+type reader struct {
+	ctx       context.Context
+	src       source.MediaSource
+	size      int64
+	head      []byte
+	tail      []byte
+	tailStart int64
+	page      []byte
+	pageStart int64
+}
+
+func newReader(ctx context.Context, src source.MediaSource, size int64) (*reader, error) {
+	r := &reader{ctx: ctx, src: src, size: size}
+
+	var err error
+	if r.head, err = r.fetch(0, min(size, windowSize)); err != nil {
+		return nil, err
+	}
+
+	r.tailStart = max(size-windowSize, int64(len(r.head)))
+	if r.tailStart < size {
+		if r.tail, err = r.fetch(r.tailStart, size-r.tailStart); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// read returns up to n bytes at off, fewer at the end of the file.
+func (r *reader) read(off, n int64) ([]byte, error) {
+	if off < 0 || off >= r.size {
+		return nil, fmt.Errorf("%w: offset %d is past the end of the file", ErrUnsupported, off)
+	}
+	n = min(n, r.size-off)
+	if n > maxElement {
+		return nil, fmt.Errorf("%w: element of %d bytes at %d is too large", ErrUnsupported, n, off)
+	}
+
+	if off+n <= int64(len(r.head)) {
+		return r.head[off : off+n], nil
+	}
+	if off >= r.tailStart && r.tail != nil {
+		return r.tail[off-r.tailStart : off-r.tailStart+n], nil
+	}
+
+	start := off - off%pageSize
+	if off+n <= start+pageSize {
+		if r.page == nil || r.pageStart != start {
+			page, err := r.fetch(start, min(pageSize, r.size-start))
+			if err != nil {
+				return nil, err
+			}
+			r.page, r.pageStart = page, start
+		}
+		if off+n <= start+int64(len(r.page)) {
+			return r.page[off-start : off-start+n], nil
+		}
+	}
+	return r.fetch(off, n)
+}
+
+func (r *reader) fetch(off, n int64) ([]byte, error) {
+	buf := make([]byte, 0, n)
+	err := r.src.ReadRange(r.ctx, off, n, func(chunk []byte) error {
+		buf = append(buf, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// languages maps the ISO 639-2 codes containers use to the two-letter codes
+// the rest of the library uses. Codes missing here are kept as they are.
+var languages = map[string]string{
+	"eng": "en", "hin": "hi", "tam": "ta", "tel": "te", "mal": "ml",
+	"kan": "kn", "ben": "bn", "mar": "mr", "pan": "pa", "urd": "ur",
+	"fre": "fr", "fra": "fr", "ger": "de", "deu": "de", "spa": "es",
+	"ita": "it", "jpn": "ja", "kor": "ko", "chi": "zh", "zho": "zh",
+	"rus": "ru", "por": "pt", "ara": "ar", "tur": "tr", "dut": "nl",
+	"nld": "nl", "pol": "pl", "swe": "sv", "nor": "no", "nob": "no",
+	"dan": "da", "fin": "fi", "gre": "el", "ell": "el", "heb": "he",
+	"tha": "th", "vie": "vi", "ind": "id", "may": "ms", "msa": "ms",
+	"per": "fa", "fas": "fa", "ukr": "uk", "cze": "cs", "ces": "cs",
+	"hun": "hu", "rum": "ro", "ron": "ro", "fil": "tl", "tgl": "tl",
+}
+
+func language(code string) string {
+	switch code {
+	case "", "und", "mul", "zxx", "mis":
+		return ""
+	}
+	if short, ok := languages[code]; ok {
+		return short
+	}
+	return code
+}
+
+func hdrLabel(dolbyVision bool, transfer int) string {
+	var hdr string
+	switch transfer {
+	case 16:
+		hdr = "HDR10"
+	case 18:
+		hdr = "HLG"
+	}
+	if dolbyVision {
+		if hdr == "" {
+			return "DV"
+		}
+		return "DV " + hdr
+	}
+	return hdr
+}
@@ -0,0 +1,403 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"strix/source"
+)
+
+// memSource serves a file held in memory.
+type memSource []byte
+
+func (m memSource) Stat(ctx context.Context) (*source.Info, error) {
+	return &source.Info{ETag: "mem", Size: int64(len(m))}, nil
+}
+
+func (m memSource) ReadRange(ctx context.Context, start, length int64, fn func([]byte) error) error {
+	if start > int64(len(m)) {
+		return io.ErrUnexpectedEOF
+	}
+	err := fn(m[start:min(start+length, int64(len(m)))])
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// ebml writes an element with an 8-byte size, so offsets are easy to work
+// out and every header fits the 12 bytes the parser reads.
+func ebml(id uint32, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	size[0] = 0x01
+	return append(append(ebmlID(id), size...), data...)
+}
+
+// unsized writes an element whose size is left unknown, as live recordings
+// do.
+func unsized(id uint32, body ...[]byte) []byte {
+	size := []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	return append(append(ebmlID(id), size...), bytes.Join(body, nil)...)
+}
+
+func ebmlID(id uint32) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+func uintEl(id uint32, v uint64) []byte {
+	return ebml(id, binary.BigEndian.AppendUint64(nil, v))
+}
+
+func floatEl(id uint32, v float64) []byte {
+	return ebml(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func stringEl(id uint32, s string) []byte {
+	return ebml(id, []byte(s))
+}
+
+func mkvHeader(docType string) []byte {
+	return ebml(idEBML, stringEl(idDocType, docType))
+}
+
+func segmentInfo(seconds float64) []byte {
+	return ebml(idInfo, uintEl(idTimecodeScale, defaultScale), floatEl(idDuration, seconds*1000))
+}
+
+// cluster holds a video block padded out to size bytes.
+func cluster(size int) []byte {
+	return ebml(idCluster, uintEl(idTimecode, 0), ebml(idSimpleBlock, make([]byte, size)))
+}
+
+// testTracks describes a DV HDR10 video, two audio tracks and a forced
+// subtitle.
+func testTracks() []byte {
+	return ebml(idTracks,
+		ebml(idTrackEntry,
+			uintEl(idTrackNumber, 1),
+			uintEl(idTrackType, trackVideo),
+			stringEl(idCodecID, "V_MPEGH/ISO/HEVC"),
+			ebml(idVideo,
+				uintEl(idPixelWidth, 3840),
+				uintEl(idPixelHeight, 1600),
+				ebml(idColour, uintEl(idTransfer, 16)),
+			),
+			ebml(idBlockAddMap, uintEl(idBlockAddType, blockAddDVCC)),
+		),
+		ebml(idTrackEntry,
+			uintEl(idTrackNumber, 2),
+			uintEl(idTrackType, trackAudio),
+			stringEl(idCodecID, "A_EAC3"),
+			stringEl(idLanguage, "ger"),
+			stringEl(idName, "Deutsch"),
+			ebml(idAudio, uintEl(idChannels, 6)),
+		),
+		ebml(idTrackEntry,
+			uintEl(idTrackNumber, 3),
+			uintEl(idTrackType, trackAudio),
+			stringEl(idCodecID, "A_AAC/MPEG4/LC"),
+			stringEl(idLanguage, "und"),
+			stringEl(idLanguageIETF, "ja"),
+			uintEl(idFlagDefault, 0),
+			ebml(idAudio, uintEl(idChannels, 2)),
+		),
+		ebml(idTrackEntry,
+			uintEl(idTrackNumber, 4),
+			uintEl(idTrackType, trackSubtitle),
+			stringEl(idCodecID, "S_TEXT/UTF8"),
+			uintEl(idFlagForced, 1),
+		),
+	)
+}
+
+var testTracksInfo = Info{
+	Container:  "mkv",
+	Width:      3840,
+	Height:     1600,
+	Duration:   90 * time.Minute,
+	VideoCodec: "HEVC",
+	HDR:        "DV HDR10",
+	Audio: []Track{
+		{Number: 2, Codec: "EAC3", Language: "de", Title: "Deutsch", Channels: 6, Default: true},
+		{Number: 3, Codec: "AAC", Language: "ja", Channels: 2},
+	},
+	Subtitles: []Track{
+		{Number: 4, Codec: "SRT", Language: "en", Default: true, Forced: true},
+	},
+}
+
+// seekHeadLast builds a segment whose Info and Tracks follow a cluster
+// larger than the window read from the start of the file, and are found
+// through the SeekHead.
+func seekHeadLast() []byte {
+	seek := func(id uint32, pos uint64) []byte {
+		return ebml(idSeek, ebml(idSeekID, ebmlID(id)), uintEl(idSeekPosition, pos))
+	}
+	head := func(info, tracks uint64) []byte {
+		return ebml(idSeekHead, seek(idInfo, info), seek(idTracks, tracks))
+	}
+
+	first := cluster(windowSize + windowSize/2)
+	info := segmentInfo(90 * 60)
+	infoAt := uint64(len(head(0, 0)) + len(first))
+	tracksAt := infoAt + uint64(len(info))
+
+	return append(mkvHeader("matroska"), ebml(idSegment,
+		head(infoAt, tracksAt),
+		first,
+		info,
+		testTracks(),
+		cluster(windowSize+windowSize/2),
+	)...)
+}
+
+func testMKV() []byte {
+	return append(mkvHeader("matroska"), ebml(idSegment, segmentInfo(90*60), testTracks(), cluster(16))...)
+}
+
+func TestProbeMKV(t *testing.T) {
+	webm := Info{
+		Container:  "webm",
+		Width:      1920,
+		Height:     1080,
+		Duration:   30 * time.Second,
+		VideoCodec: "VP9",
+		HDR:        "HLG",
+		Audio:      []Track{{Number: 2, Codec: "Opus", Language: "fr", Channels: 8, Default: true}},
+	}
+
+	tests := []struct {
+		name string
+		file []byte
+		want Info
+	}{
+		{name: "headers first", file: testMKV(), want: testTracksInfo},
+		{name: "seekhead after clusters", file: seekHeadLast(), want: testTracksInfo},
+		{
+			name: "webm with unknown segment size",
+			file: append(mkvHeader("webm"), unsized(idSegment,
+				segmentInfo(30),
+				ebml(idTracks,
+					ebml(idTrackEntry,
+						uintEl(idTrackNumber, 1),
+						uintEl(idTrackType, trackVideo),
+						stringEl(idCodecID, "V_VP9"),
+						ebml(idVideo,
+							uintEl(idPixelWidth, 1920),
+							uintEl(idPixelHeight, 1080),
+							ebml(idColour, uintEl(idTransfer, 18)),
+						),
+					),
+					ebml(idTrackEntry,
+						uintEl(idTrackNumber, 2),
+						uintEl(idTrackType, trackAudio),
+						stringEl(idCodecID, "A_OPUS"),
+						stringEl(idLanguage, "fre"),
+						ebml(idAudio, uintEl(idChannels, 8)),
+					),
+				),
+				cluster(16),
+			)...),
+			want: webm,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Probe(context.Background(), memSource(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Probe\n got: %+v\nwant: %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// mp4Box writes a box with a 32-bit size.
+func mp4Box(kind string, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, kind...), data...)
+}
+
+// fullBox writes a box starting with a version and flags.
+func fullBox(kind string, version byte, flags uint32, body ...[]byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags)
+	return mp4Box(kind, append([][]byte{header}, body...)...)
+}
+
+func u16(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+func u32(v int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(v)) }
+
+func mvhd(timescale, duration int) []byte {
+	return fullBox("mvhd", 0, 0, u32(0), u32(0), u32(timescale), u32(duration), make([]byte, 80))
+}
+
+// tkhd writes a track header; width and height are whole pixels.
+func tkhd(id int, enabled bool, width, height int) []byte {
+	flags := uint32(0)
+	if enabled {
+		flags = 1
+	}
+	return fullBox("tkhd", 0, flags, u32(0), u32(0), u32(id), make([]byte, 60), u32(width<<16), u32(height<<16))
+}
+
+func mdhd(lang string) []byte {
+	packed := 0
+	for _, c := range []byte(lang) {
+		packed = packed<<5 | int(c-0x60)
+	}
+	return fullBox("mdhd", 0, 0, u32(0), u32(0), u32(1000), u32(0), u16(packed), u16(0))
+}
+
+func trak(header []byte, handler, lang string, entry []byte) []byte {
+	return mp4Box("trak", header, mp4Box("mdia",
+		mdhd(lang),
+		fullBox("hdlr", 0, 0, u32(0), []byte(handler), make([]byte, 12)),
+		mp4Box("minf", mp4Box("stbl", fullBox("stsd", 0, 0, u32(1), entry))),
+	))
+}
+
+func videoEntry(kind string, width, height int, children ...[]byte) []byte {
+	fields := [][]byte{make([]byte, 6), u16(1), make([]byte, 16), u16(width), u16(height), make([]byte, 50)}
+	return mp4Box(kind, append(fields, children...)...)
+}
+
+func audioEntry(kind string, channels int, children ...[]byte) []byte {
+	fields := [][]byte{make([]byte, 6), u16(1), make([]byte, 8), u16(channels), make([]byte, 10)}
+	return mp4Box(kind, append(fields, children...)...)
+}
+
+func colr(transfer int) []byte {
+	return mp4Box("colr", []byte("nclx"), u16(9), u16(transfer), u16(9), []byte{0})
+}
+
+func testMoov() []byte {
+	return mp4Box("moov",
+		mvhd(1000, 2*60*60*1000),
+		trak(tkhd(1, true, 1920, 800), "vide", "und", videoEntry("hvc1", 1920, 1080, colr(18))),
+		// E-AC-3 3/2 with LFE.
+		trak(tkhd(2, true, 0, 0), "soun", "eng", audioEntry("ec-3", 2, mp4Box("dec3", u16(0), []byte{0, 7<<1 | 1, 0}))),
+		trak(tkhd(3, true, 0, 0), "soun", "hin", audioEntry("mp4a", 2)),
+		trak(tkhd(4, false, 0, 0), "sbtl", "fre", mp4Box("tx3g", make([]byte, 8))),
+	)
+}
+
+func testMP4() []byte {
+	return bytes.Join([][]byte{mp4Box("ftyp", []byte("isom"), u32(0)), testMoov(), mp4Box("mdat", make([]byte, 64))}, nil)
+}
+
+func TestProbeMP4(t *testing.T) {
+	want := Info{
+		Container:  "mp4",
+		Width:      1920,
+		Height:     800,
+		Duration:   2 * time.Hour,
+		VideoCodec: "HEVC",
+		HDR:        "HLG",
+		Audio: []Track{
+			{Number: 2, Codec: "EAC3", Language: "en", Channels: 6, Default: true},
+			{Number: 3, Codec: "AAC", Language: "hi", Channels: 2},
+		},
+		Subtitles: []Track{{Number: 4, Codec: "TX3G", Language: "fr"}},
+	}
+	mov := want
+	mov.Container = "mov"
+
+	tests := []struct {
+		name string
+		file []byte
+		want Info
+	}{
+		{name: "faststart", file: testMP4(), want: want},
+		{
+			// The index is written after the media, past the window read
+			// from the start of the file.
+			name: "index at the end",
+			file: bytes.Join([][]byte{
+				mp4Box("ftyp", []byte("qt  "), u32(0)),
+				mp4Box("mdat", make([]byte, 3*windowSize)),
+				testMoov(),
+			}, nil),
+			want: mov,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Probe(context.Background(), memSource(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Probe\n got: %+v\nwant: %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeUnsupported(t *testing.T) {
+	files := map[string][]byte{
+		"empty":          {},
+		"text":           []byte("not a video file"),
+		"mkv, no tracks": append(mkvHeader("matroska"), ebml(idSegment, segmentInfo(1), cluster(16))...),
+		"mp4, no moov":   bytes.Join([][]byte{mp4Box("ftyp", []byte("isom"), u32(0)), mp4Box("mdat", make([]byte, 64))}, nil),
+	}
+	for name, file := range files {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Probe(context.Background(), memSource(file)); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("Probe error = %v, want ErrUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestResolution(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          string
+	}{
+		{3840, 2160, "2160p"},
+		{3840, 1600, "2160p"},
+		{1920, 1080, "1080p"},
+		{1920, 800, "1080p"},
+		{1280, 536, "720p"},
+		{720, 576, "480p"},
+		{426, 240, "240p"},
+		{0, 0, ""},
+	}
+	for _, tt := range tests {
+		info := Info{Width: tt.width, Height: tt.height}
+		if got := info.Resolution(); got != tt.want {
+			t.Errorf("Resolution of %dx%d = %q, want %q", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func FuzzProbe(f *testing.F) {
+	f.Add(testMKV())
+	f.Add(testMP4())
+	f.Add(ebmlMagic)
+	f.Add(mp4Box("moov", mp4Box("trak")))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Probe(context.Background(), memSource(data))
+	})
+}
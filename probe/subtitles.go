@@ -0,0 +1,532 @@
+package probe
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"strix/source"
+)
+
+// Matroska element IDs used to find subtitle blocks.
+const (
+	idCues                = 0x1C53BB6B
+	idCuePoint            = 0xBB
+	idCueTrackPositions   = 0xB7
+	idCueTrack            = 0xF7
+	idCueClusterPosition  = 0xF1
+	idCueRelativePosition = 0xF0
+	idTimecode            = 0xE7
+	idSimpleBlock         = 0xA3
+	idBlockGroup          = 0xA0
+	idBlock               = 0xA1
+	idBlockDuration       = 0x9B
+	idContentEncodings    = 0x6D80
+	idContentEncoding     = 0x6240
+	idContentEncodingType = 0x5033
+	idContentCompression  = 0x5034
+	idContentCompAlgo     = 0x4254
+	idContentCompSettings = 0x4255
+)
+
+// Content compression algorithms.
+const (
+	compZlib           = 0
+	compHeaderStripped = 3
+)
+
+const (
+	// defaultCueLength is used for blocks that don't say how long they
+	// show.
+	defaultCueLength = 3 * time.Second
+	// maxFrame caps a subtitle frame once decompressed.
+	maxFrame = 1024 * 1024
+)
+
+// ErrNoTrack is returned when a file has no subtitle track with the
+// requested number.
+var ErrNoTrack = errors.New("no such subtitle track")
+
+// Cue is one subtitle, with its text in WebVTT markup.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// IsTextSubtitle reports whether a subtitle codec holds text that Subtitles
+// can extract, rather than pictures.
+func IsTextSubtitle(codec string) bool {
+	switch codec {
+	case "SRT", "ASS", "SSA", "WebVTT":
+		return true
+	}
+	return false
+}
+
+// Subtitles extracts an MKV text subtitle track. Blocks are found through
+// the file's cue index where it lists them, so only those parts of the file
+// are read; otherwise every cluster is.
+func Subtitles(ctx context.Context, src source.MediaSource, number int) ([]Cue, error) {
+	stat, err := src.Stat(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newReader(ctx, src, stat.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(r.head, ebmlMagic) {
+		return nil, ErrUnsupported
+	}
+
+	f, err := openMKV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var track *Track
+	for i := range f.info.Subtitles {
+		if f.info.Subtitles[i].Number == number {
+			track = &f.info.Subtitles[i]
+		}
+	}
+	if track == nil {
+		return nil, ErrNoTrack
+	}
+	if !IsTextSubtitle(track.Codec) {
+		return nil, fmt.Errorf("%w: %s subtitles are images", ErrUnsupported, track.Codec)
+	}
+
+	x := &extractor{f: f, track: int64(number), codec: track.Codec, timecodes: make(map[int64]int64)}
+	if x.decode, err = trackDecoder(f.tracks, number); err != nil {
+		return nil, err
+	}
+
+	points, err := x.cuePoints()
+	if err != nil {
+		return nil, err
+	}
+	if len(points) > 0 {
+		err = x.readPoints(points)
+	} else {
+		err = x.readClusters()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sortCues(x.cues), nil
+}
+
+// cuePoint locates a block by its cluster's offset in the segment and the
+// block's offset in the cluster; relative is -1 when the index doesn't
+// say.
+type cuePoint struct {
+	cluster  int64
+	relative int64
+}
+
+type extractor struct {
+	f         *mkvFile
+	track     int64
+	codec     string
+	decode    func([]byte) ([]byte, error)
+	timecodes map[int64]int64
+	cues      []Cue
+}
+
+// cuePoints lists the index entries for the track, in file order. A
+// missing or broken index lists none.
+func (x *extractor) cuePoints() ([]cuePoint, error) {
+	data, err := x.f.element(idCues)
+	if errors.Is(err, ErrUnsupported) {
+		return nil, nil
+	}
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	seen := make(map[cuePoint]bool)
+	var points []cuePoint
+	children(data, func(id uint32, data []byte) {
+		if id != idCuePoint {
+			return
+		}
+		children(data, func(id uint32, data []byte) {
+			if id != idCueTrackPositions {
+				return
+			}
+			p := cuePoint{cluster: -1, relative: -1}
+			var track int64
+			children(data, func(id uint32, data []byte) {
+				switch id {
+				case idCueTrack:
+					track = readUint(data)
+				case idCueClusterPosition:
+					p.cluster = readUint(data)
+				case idCueRelativePosition:
+					p.relative = readUint(data)
+				}
+			})
+			if track == x.track && p.cluster >= 0 && !seen[p] {
+				seen[p] = true
+				points = append(points, p)
+			}
+		})
+	})
+
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].cluster != points[j].cluster {
+			return points[i].cluster < points[j].cluster
+		}
+		return points[i].relative < points[j].relative
+	})
+	return points, nil
+}
+
+// readPoints reads the blocks the index points at. Entries without a
+// relative position cost their whole cluster.
+func (x *extractor) readPoints(points []cuePoint) error {
+	whole := make(map[int64]bool)
+	for _, p := range points {
+		if whole[p.cluster] {
+			continue
+		}
+		pos := x.f.segmentStart + p.cluster
+		if p.relative < 0 {
+			whole[p.cluster] = true
+			if _, err := x.readCluster(pos); err != nil {
+				return err
+			}
+			continue
+		}
+
+		start, timecode, err := x.clusterTimecode(pos)
+		if err != nil {
+			return err
+		}
+		header, err := x.f.r.read(start+p.relative, 12)
+		if err != nil {
+			return err
+		}
+		id, size, n, ok := elementHeader(header)
+		if !ok || size == unknownSize || (id != idSimpleBlock && id != idBlockGroup) {
+			continue
+		}
+		data, err := x.f.r.read(start+p.relative+int64(n), size)
+		if err != nil {
+			return err
+		}
+		x.element(timecode, id, data)
+	}
+	return nil
+}
+
+// clusterTimecode returns where a cluster's children start and its
+// timecode, which is its first child.
+func (x *extractor) clusterTimecode(pos int64) (start, timecode int64, err error) {
+	header, err := x.f.r.read(pos, 12)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, _, n, ok := elementHeader(header)
+	if !ok || id != idCluster {
+		return 0, 0, fmt.Errorf("%w: no cluster at %d", ErrUnsupported, pos)
+	}
+	start = pos + int64(n)
+	if timecode, ok := x.timecodes[start]; ok {
+		return start, timecode, nil
+	}
+
+	header, err = x.f.r.read(start, 20)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, size, n, ok := elementHeader(header)
+	if !ok || id != idTimecode || int64(n)+size > int64(len(header)) {
+		return 0, 0, fmt.Errorf("%w: cluster at %d has no timecode", ErrUnsupported, pos)
+	}
+	timecode = readUint(header[n : int64(n)+size])
+	x.timecodes[start] = timecode
+	return start, timecode, nil
+}
+
+// readClusters walks every cluster in the segment.
+func (x *extractor) readClusters() error {
+	pos := x.f.body
+	for pos < x.f.segmentEnd {
+		next, err := x.readCluster(pos)
+		if err != nil {
+			return err
+		}
+		if next <= pos {
+			break
+		}
+		pos = next
+	}
+	return nil
+}
+
+// readCluster reads the top-level element at pos, taking the track's
+// blocks if it's a cluster, and returns where the next one starts.
+func (x *extractor) readCluster(pos int64) (int64, error) {
+	header, err := x.f.r.read(pos, 12)
+	if err != nil {
+		return 0, err
+	}
+	id, size, n, ok := elementHeader(header)
+	if !ok || size == unknownSize {
+		return 0, fmt.Errorf("%w: unreadable element at %d", ErrUnsupported, pos)
+	}
+	next := pos + int64(n) + size
+	if id != idCluster {
+		return next, nil
+	}
+
+	data, err := x.f.r.read(pos+int64(n), size)
+	if err != nil {
+		return 0, err
+	}
+	var timecode int64
+	children(data, func(id uint32, data []byte) {
+		if id == idTimecode {
+			timecode = readUint(data)
+			return
+		}
+		x.element(timecode, id, data)
+	})
+	return next, nil
+}
+
+// element takes a SimpleBlock or BlockGroup if it belongs to the track.
+func (x *extractor) element(timecode int64, id uint32, data []byte) {
+	switch id {
+	case idSimpleBlock:
+		x.block(timecode, data, -1)
+	case idBlockGroup:
+		var block []byte
+		duration := int64(-1)
+		children(data, func(id uint32, data []byte) {
+			switch id {
+			case idBlock:
+				block = data
+			case idBlockDuration:
+				duration = readUint(data)
+			}
+		})
+		if block != nil {
+			x.block(timecode, block, duration)
+		}
+	}
+}
+
+func (x *extractor) block(timecode int64, b []byte, duration int64) {
+	track, n, ok := vint(b, false)
+	if !ok || track != x.track || len(b) < n+3 {
+		return
+	}
+	// Laced blocks hold several frames; subtitles are never written that
+	// way.
+	if b[n+2]&0x06 != 0 {
+		return
+	}
+
+	frame, err := x.decode(b[n+3:])
+	if err != nil {
+		return
+	}
+	text := cueText(x.codec, frame)
+	if text == "" {
+		return
+	}
+
+	start := time.Duration((timecode + int64(int16(binary.BigEndian.Uint16(b[n:])))) * x.f.scale)
+	end := start + defaultCueLength
+	if duration >= 0 {
+		end = start + time.Duration(duration*x.f.scale)
+	}
+	x.cues = append(x.cues, Cue{Start: max(start, 0), End: end, Text: text})
+}
+
+// trackDecoder undoes the track's content compression. Encrypted tracks
+// can't be read.
+func trackDecoder(tracks []byte, number int) (func([]byte) ([]byte, error), error) {
+	decode := func(b []byte) ([]byte, error) { return b, nil }
+
+	var err error
+	children(tracks, func(id uint32, data []byte) {
+		if id != idTrackEntry {
+			return
+		}
+		var entry int64
+		var encodings []byte
+		children(data, func(id uint32, data []byte) {
+			switch id {
+			case idTrackNumber:
+				entry = readUint(data)
+			case idContentEncodings:
+				encodings = data
+			}
+		})
+		if entry != int64(number) || encodings == nil {
+			return
+		}
+
+		children(encodings, func(id uint32, data []byte) {
+			if id != idContentEncoding {
+				return
+			}
+			var kind int64
+			algo := int64(compZlib)
+			var settings []byte
+			children(data, func(id uint32, data []byte) {
+				switch id {
+				case idContentEncodingType:
+					kind = readUint(data)
+				case idContentCompression:
+					children(data, func(id uint32, data []byte) {
+						switch id {
+						case idContentCompAlgo:
+							algo = readUint(data)
+						case idContentCompSettings:
+							settings = data
+						}
+					})
+				}
+			})
+
+			switch {
+			case kind != 0:
+				err = fmt.Errorf("%w: track %d is encrypted", ErrUnsupported, number)
+			case algo == compZlib:
+				decode = inflate
+			case algo == compHeaderStripped:
+				decode = func(b []byte) ([]byte, error) { return append(append([]byte{}, settings...), b...), nil }
+			default:
+				err = fmt.Errorf("%w: track %d uses compression %d", ErrUnsupported, number, algo)
+			}
+		})
+	})
+	return decode, err
+}
+
+// inflate undoes zlib compression, refusing frames that grow past
+// maxFrame.
+func inflate(b []byte) ([]byte, error) {
+	z, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	frame, err := io.ReadAll(io.LimitReader(z, maxFrame+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) > maxFrame {
+		return nil, fmt.Errorf("%w: compressed frame is over %d bytes", ErrUnsupported, maxFrame)
+	}
+	return frame, nil
+}
+
+var (
+	assOverride = regexp.MustCompile(`\{[^}]*\}`)
+	markupTag   = regexp.MustCompile(`</?([A-Za-z]+)[^>]*>`)
+)
+
+// cueText turns a block's payload into WebVTT cue text.
+func cueText(codec string, frame []byte) string {
+	text := strings.ReplaceAll(string(frame), "\r\n", "\n")
+
+	switch codec {
+	case "WebVTT":
+	case "ASS", "SSA":
+		// Blocks hold a dialogue line without its start and end times:
+		// ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect,
+		// then the text.
+		fields := strings.SplitN(text, ",", 9)
+		if len(fields) < 9 {
+			return ""
+		}
+		text = assOverride.ReplaceAllString(fields[8], "")
+		text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+		text = escapeText(text)
+	default:
+		text = srtText(text)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// srtText escapes SRT text, keeping the italic, bold and underline tags
+// WebVTT shares with it and dropping the rest, like <font>.
+func srtText(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, m := range markupTag.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(escapeText(text[last:m[0]]))
+		last = m[1]
+
+		switch tag := strings.ToLower(text[m[2]:m[3]]); tag {
+		case "i", "b", "u":
+			if text[m[0]+1] == '/' {
+				out.WriteString("</" + tag + ">")
+			} else {
+				out.WriteString("<" + tag + ">")
+			}
+		}
+	}
+	out.WriteString(escapeText(text[last:]))
+	return out.String()
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func escapeText(text string) string {
+	return textEscaper.Replace(text)
+}
+
+// sortCues orders cues by start time, dropping repeats of the same cue
+// read through two index entries.
+func sortCues(cues []Cue) []Cue {
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	out := cues[:0]
+	for _, c := range cues {
+		if len(out) > 0 && c == out[len(out)-1] {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// WriteVTT writes cues as a WebVTT file.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	var out bytes.Buffer
+	out.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(&out, "%s --> %s\n%s\n\n", vttTime(c.Start), vttTime(c.End), c.Text)
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
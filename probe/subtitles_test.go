@@ -0,0 +1,217 @@
+package probe
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCueText(t *testing.T) {
+	tests := []struct {
+		codec string
+		frame string
+		want  string
+	}{
+		{"SRT", "Hello <i>world</i>", "Hello <i>world</i>"},
+		{"SRT", `<font color="#ffff00">Yellow</font> & <B>bold</B>`, "Yellow &amp; <b>bold</b>"},
+		{"SRT", "Line one\r\n\r\n  Line two  ", "Line one\nLine two"},
+		{"SRT", "1 < 2 > 0", "1 &lt; 2 &gt; 0"},
+		{"SRT", "<u>under</u><s>struck</s>", "<u>under</u>struck"},
+		{"ASS", `0,0,Default,,0,0,0,,{\an8}Top\Nline`, "Top\nline"},
+		{"ASS", `1,0,Default,Bob,0,0,0,,Well, {\i1}yes{\i0}, <no>`, "Well, yes, &lt;no&gt;"},
+		{"SSA", `2,0,Default,,0,0,0,,Hard\hspace`, "Hard space"},
+		{"ASS", "0,0,Default", ""},
+		{"WebVTT", "<v Bob>Hi</v>\n", "<v Bob>Hi</v>"},
+	}
+	for _, tt := range tests {
+		if got := cueText(tt.codec, []byte(tt.frame)); got != tt.want {
+			t.Errorf("cueText(%s, %q) = %q, want %q", tt.codec, tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestVTTTime(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "00:00:00.000"},
+		{1500 * time.Millisecond, "00:00:01.500"},
+		{61*time.Minute + 2*time.Second + 3*time.Millisecond, "01:01:02.003"},
+		{25 * time.Hour, "25:00:00.000"},
+	}
+	for _, tt := range tests {
+		if got := vttTime(tt.d); got != tt.want {
+			t.Errorf("vttTime(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var out bytes.Buffer
+	err := WriteVTT(&out, []Cue{
+		{Start: time.Second, End: 3 * time.Second, Text: "One"},
+		{Start: 5 * time.Second, End: 8 * time.Second, Text: "Two\nlines"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n\n00:00:01.000 --> 00:00:03.000\nOne\n\n00:00:05.000 --> 00:00:08.000\nTwo\nlines\n\n"
+	if out.String() != want {
+		t.Errorf("WriteVTT wrote\n%q\nwant\n%q", out.String(), want)
+	}
+}
+
+func TestInflateLimit(t *testing.T) {
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write(make([]byte, maxFrame+1))
+	z.Close()
+
+	if _, err := inflate(compressed.Bytes()); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("inflate of %d bytes: error = %v, want ErrUnsupported", maxFrame+1, err)
+	}
+}
+
+// subtitleBlock is a block of the subtitle track, track 2, at a timecode
+// relative to its cluster.
+func subtitleBlock(id uint32, timecode int, frame []byte) []byte {
+	return ebml(id, []byte{0x82}, u16(timecode), []byte{0x80}, frame)
+}
+
+// subtitleMKV builds a file with a video track and an SRT track holding
+// two cues in two clusters. The first is a BlockGroup with a duration,
+// the second a SimpleBlock. With index set, a Cues element lists them:
+// the first with its position in the cluster, the second without. encode
+// is applied to each frame, and encoding is the track's ContentEncodings.
+func subtitleMKV(index bool, encoding []byte, encode func([]byte) []byte) []byte {
+	videoBlock := ebml(idSimpleBlock, []byte{0x81, 0, 0, 0x80}, make([]byte, 256))
+	group := ebml(idBlockGroup,
+		subtitleBlock(idBlock, 1000, encode([]byte("Hello <i>world</i>"))),
+		uintEl(idBlockDuration, 2000),
+	)
+	timecode := uintEl(idTimecode, 0)
+	first := ebml(idCluster, timecode, videoBlock, group)
+	second := ebml(idCluster, uintEl(idTimecode, 5000), videoBlock,
+		subtitleBlock(idSimpleBlock, 500, encode([]byte("Hello & goodbye"))))
+
+	subtitle := [][]byte{
+		uintEl(idTrackNumber, 2),
+		uintEl(idTrackType, trackSubtitle),
+		stringEl(idCodecID, "S_TEXT/UTF8"),
+	}
+	if encoding != nil {
+		subtitle = append(subtitle, encoding)
+	}
+	tracks := ebml(idTracks,
+		ebml(idTrackEntry,
+			uintEl(idTrackNumber, 1),
+			uintEl(idTrackType, trackVideo),
+			stringEl(idCodecID, "V_MPEG4/ISO/AVC"),
+		),
+		ebml(idTrackEntry, subtitle...),
+	)
+	info := segmentInfo(10)
+
+	if !index {
+		return append(mkvHeader("matroska"), ebml(idSegment, info, tracks, first, second)...)
+	}
+
+	seekHead := func(cuesAt uint64) []byte {
+		return ebml(idSeekHead, ebml(idSeek, ebml(idSeekID, ebmlID(idCues)), uintEl(idSeekPosition, cuesAt)))
+	}
+	firstAt := uint64(len(seekHead(0)) + len(info) + len(tracks))
+	secondAt := firstAt + uint64(len(first))
+	cuesAt := secondAt + uint64(len(second))
+
+	cuePoint := func(cluster uint64, relative ...uint64) []byte {
+		positions := [][]byte{uintEl(idCueTrack, 2), uintEl(idCueClusterPosition, cluster)}
+		for _, r := range relative {
+			positions = append(positions, uintEl(idCueRelativePosition, r))
+		}
+		return ebml(idCuePoint, ebml(idCueTrackPositions, positions...))
+	}
+	cues := ebml(idCues,
+		cuePoint(firstAt, uint64(len(timecode)+len(videoBlock))),
+		cuePoint(secondAt),
+	)
+
+	return append(mkvHeader("matroska"), ebml(idSegment, seekHead(cuesAt), info, tracks, first, second, cues)...)
+}
+
+func contentEncoding(algo uint64, settings []byte) []byte {
+	compression := [][]byte{uintEl(idContentCompAlgo, algo)}
+	if settings != nil {
+		compression = append(compression, ebml(idContentCompSettings, settings))
+	}
+	return ebml(idContentEncodings, ebml(idContentEncoding, ebml(idContentCompression, compression...)))
+}
+
+func TestSubtitles(t *testing.T) {
+	plain := func(b []byte) []byte { return b }
+	deflate := func(b []byte) []byte {
+		var out bytes.Buffer
+		z := zlib.NewWriter(&out)
+		z.Write(b)
+		z.Close()
+		return out.Bytes()
+	}
+	// Header stripping leaves out the bytes every frame starts with.
+	stripped := func(b []byte) []byte { return bytes.TrimPrefix(b, []byte("Hello ")) }
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"cue index", subtitleMKV(true, nil, plain)},
+		{"no cue index", subtitleMKV(false, nil, plain)},
+		{"zlib", subtitleMKV(true, contentEncoding(compZlib, nil), deflate)},
+		{"header stripped", subtitleMKV(false, contentEncoding(compHeaderStripped, []byte("Hello ")), stripped)},
+	}
+
+	want := []Cue{
+		{Start: time.Second, End: 3 * time.Second, Text: "Hello <i>world</i>"},
+		{Start: 5500 * time.Millisecond, End: 8500 * time.Millisecond, Text: "Hello &amp; goodbye"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Subtitles(context.Background(), memSource(tt.file), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Subtitles\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSubtitlesErrors(t *testing.T) {
+	file := subtitleMKV(true, nil, func(b []byte) []byte { return b })
+	if _, err := Subtitles(context.Background(), memSource(file), 3); !errors.Is(err, ErrNoTrack) {
+		t.Errorf("missing track: error = %v, want ErrNoTrack", err)
+	}
+
+	encrypted := ebml(idContentEncodings, ebml(idContentEncoding, uintEl(idContentEncodingType, 1)))
+	file = subtitleMKV(true, encrypted, func(b []byte) []byte { return b })
+	if _, err := Subtitles(context.Background(), memSource(file), 2); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("encrypted track: error = %v, want ErrUnsupported", err)
+	}
+
+	if _, err := Subtitles(context.Background(), memSource(testMP4()), 4); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("MP4: error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestSortCues(t *testing.T) {
+	a := Cue{Start: time.Second, End: 2 * time.Second, Text: "a"}
+	b := Cue{Start: 3 * time.Second, End: 4 * time.Second, Text: "b"}
+	got := sortCues([]Cue{b, a, a, b})
+	if want := []Cue{a, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortCues = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxRanges = 32
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header as described in RFC 7233 section 2.1.
+// A nil result with a nil error means the header should be ignored and the
+// full representation served. Unsatisfiable specs are dropped; if none are
+// left errRangeNotSatisfiable is returned.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, nil
+	}
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// suffix-byte-range-spec: the final N bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+				end = min(end, size-1)
+			}
+
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable
+	}
+
+	return coalesceRanges(ranges, size), nil
+}
+
+// coalesceRanges merges overlapping or adjacent ranges. Clients asking for
+// more bytes than the file holds get the whole file instead.
+func coalesceRanges(ranges []byteRange, size int64) []byteRange {
+	if len(ranges) == 1 {
+		return ranges
+	}
+
+	sorted := append([]byteRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	merged := []byteRange{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.start+last.length {
+			end := max(last.start+last.length, r.start+r.length)
+			last.length = end - last.start
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	var total int64
+	for _, r := range merged {
+		total += r.length
+	}
+	if total > size {
+		return []byteRange{{start: 0, length: size}}
+	}
+
+	return merged
+}
+
+// etagMatches implements the comparison used by If-None-Match (weak) and
+// If-Range (strong) against a list of entity tags.
+func etagMatches(header, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if strong {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// multipartLayout precomputes the part headers of a multipart/byteranges
+// body so Content-Length can be sent before any data is fetched.
+type multipartLayout struct {
+	boundary string
+	headers  []textproto.MIMEHeader
+	length   int64
+}
+
+func newMultipartLayout(ranges []byteRange, contentType string, size int64) *multipartLayout {
+	counter := &countingWriter{}
+	mw := multipart.NewWriter(counter)
+
+	layout := &multipartLayout{boundary: mw.Boundary()}
+	for _, r := range ranges {
+		header := textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {r.contentRange(size)},
+		}
+		layout.headers = append(layout.headers, header)
+		mw.CreatePart(header)
+		counter.n += r.length
+	}
+	mw.Close()
+
+	layout.length = counter.n
+	return layout
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// write writes each range as one part, using body to stream the
+// bytes of a single range.
+func (l *multipartLayout) write(w io.Writer, ranges []byteRange, body func(io.Writer, byteRange) error) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(l.boundary); err != nil {
+		return err
+	}
+
+	for i, r := range ranges {
+		part, err := mw.CreatePart(l.headers[i])
+		if err != nil {
+			return err
+		}
+		if err := body(part, r); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
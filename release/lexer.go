@@ -0,0 +1,112 @@
+package release
+
+import (
+	"strings"
+	"unicode"
+)
+
+type token struct {
+	text string
+	// dash is set when a '-' came right before the token; joined when that
+	// dash had no other separator around it, as in "Spider-Man" or
+	// "x264-GROUP".
+	dash   bool
+	joined bool
+	// bracket is the 1-based index of the enclosing (), [] or {} group, 0
+	// outside of one.
+	bracket int
+}
+
+// lexer splits a release name on dots, underscores, spaces, dashes and
+// brackets. Dots between single digits are kept so "5.1" and "DDP5.1" stay
+// whole, which "2012.1080p" must not.
+type lexer struct {
+	tokens []token
+	// brackets holds the raw text of each bracket group, by index - 1.
+	brackets []string
+
+	buf     []rune
+	dash    bool
+	gap     bool
+	depth   int
+	current int
+	start   int
+}
+
+func lex(name string) ([]token, []string) {
+	l := &lexer{}
+	runes := []rune(name)
+
+	for i, r := range runes {
+		switch {
+		case r == '(' || r == '[' || r == '{':
+			l.flush()
+			l.gap = true
+			if l.depth == 0 {
+				l.brackets = append(l.brackets, "")
+				l.current = len(l.brackets)
+				l.start = i + 1
+			}
+			l.depth++
+		case r == ')' || r == ']' || r == '}':
+			l.flush()
+			if l.depth > 0 {
+				l.depth--
+				if l.depth == 0 {
+					l.brackets[l.current-1] = strings.TrimSpace(string(runes[l.start:i]))
+					l.current = 0
+				}
+			}
+		case r == '-':
+			l.flush()
+			l.dash = true
+		case (r == '+' || r == '&') && len(l.buf) == 0:
+			// A lone "+" or "&" joins lists like "Tamil + Hindi"; one
+			// straight after a word belongs to it, as in "DD+" or "HDR10+".
+			l.gap = true
+		case r == '.' && keepDot(l.buf, runes[i+1:]):
+			l.buf = append(l.buf, r)
+		case r == '.' || r == '_' || r == ',' || r == '~' || r == '|' || unicode.IsSpace(r):
+			l.flush()
+			l.gap = true
+		default:
+			l.buf = append(l.buf, r)
+		}
+	}
+	l.flush()
+
+	return l.tokens, l.brackets
+}
+
+func (l *lexer) flush() {
+	if len(l.buf) == 0 {
+		return
+	}
+
+	t := token{text: string(l.buf), dash: l.dash, joined: l.dash && !l.gap}
+	if l.depth > 0 {
+		t.bracket = l.current
+	}
+	l.tokens = append(l.tokens, t)
+
+	l.buf = l.buf[:0]
+	l.dash = false
+	l.gap = false
+}
+
+// keepDot reports whether a dot joins a single digit to the single digit
+// after it, as in audio channel counts, or a small number to one, as in
+// sizes like "1.4GB" and "10.5GB".
+func keepDot(before, after []rune) bool {
+	n := len(before)
+	if n == 0 || !unicode.IsDigit(before[n-1]) {
+		return false
+	}
+	if n > 2 && unicode.IsDigit(before[n-2]) {
+		return false
+	}
+	if len(after) == 0 || !unicode.IsDigit(after[0]) {
+		return false
+	}
+	return len(after) == 1 || !unicode.IsDigit(after[1])
+}
@@ -0,0 +1,653 @@
+// Package release parses scene and P2P release names such as
+// "The.Matrix.1999.1080p.BluRay.x264-GROUP.mkv" into their parts.
+package release
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Info is what a release name says about a file. Fields the name doesn't
+// mention are left zero.
+type Info struct {
+	Title   string
+	Year    int
+	Season  int
+	Episode int
+	// LastEpisode ends a multi-episode range such as S01E01-E03; it is zero
+	// for a single episode.
+	LastEpisode int
+	// Absolute is set when episodes are numbered from the start of the show
+	// instead of the season, as in anime releases like "Title - 137".
+	Absolute bool
+
+	Resolution    string
+	Source        string
+	Service       string
+	VideoCodec    string
+	BitDepth      int
+	HDR           []string
+	AudioCodec    string
+	AudioChannels string
+	Languages     []string
+	MultiAudio    bool
+	Edition       string
+	Repack        bool
+	Group         string
+	Container     string
+}
+
+// Codec describes the video codec and bit depth, e.g. "HEVC 10bit".
+func (i *Info) Codec() string {
+	var parts []string
+	if i.VideoCodec != "" {
+		parts = append(parts, i.VideoCodec)
+	}
+	if i.BitDepth > 0 {
+		parts = append(parts, strconv.Itoa(i.BitDepth)+"bit")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Token shapes that aren't fixed words.
+var (
+	seasonEpisodeShape = regexp.MustCompile(`^(?i)S(\d{1,3})((?:E\d{1,4})+)$`)
+	seasonShape        = regexp.MustCompile(`^(?i)S(\d{1,3})$`)
+	crossShape         = regexp.MustCompile(`^(\d{1,2})[xX](\d{2,3})$`)
+	episodeShape       = regexp.MustCompile(`^(?i)EP?(\d{1,4})$`)
+	absoluteShape      = regexp.MustCompile(`^(\d{1,4})(?:v\d)?$`)
+	resolutionShape    = regexp.MustCompile(`^(?i)(\d{3,4})[pi]$`)
+	dimensionsShape    = regexp.MustCompile(`^\d{3,4}[xX](\d{3,4})$`)
+	channelsShape      = regexp.MustCompile(`^\d\.\d$`)
+	sizeShape          = regexp.MustCompile(`^(?i)\d+(?:\.\d+)?(?:MB|GB)$`)
+	crcShape           = regexp.MustCompile(`^[0-9A-Fa-f]{8}$`)
+	monthShape         = regexp.MustCompile(`^(0[1-9]|1[0-2])$`)
+	dayShape           = regexp.MustCompile(`^(0[1-9]|[12]\d|3[01])$`)
+)
+
+// match is one classified token, or several read together like "WEB DL"
+// or "S01E01-E03".
+type match struct {
+	kind      kind
+	value     string
+	ambiguous bool
+	n         int
+
+	season, episode, lastEpisode int
+	channels                     string
+}
+
+type parser struct {
+	tokens   []token
+	brackets []string
+	upper    []string
+	info     Info
+}
+
+// Parse splits a release name into its title, year, episode numbers and
+// technical tags. It never fails; unknown words end up in the title if they
+// come before the first tag and are ignored after it.
+func Parse(name string) Info {
+	p := &parser{}
+
+	if ext := strings.ToLower(filepath.Ext(name)); videoExtensions[ext] {
+		p.info.Container = ext[1:]
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	name = stripChannelTag(name)
+
+	p.tokens, p.brackets = lex(name)
+	p.stripPrefix()
+	if len(p.tokens) == 0 {
+		return p.info
+	}
+
+	p.upper = make([]string, len(p.tokens))
+	for i, t := range p.tokens {
+		p.upper[i] = strings.ToUpper(t.text)
+	}
+
+	matches := p.classify()
+	titleEnd := p.findTitle(matches)
+
+	for i := titleEnd; i < len(matches); {
+		m := matches[i]
+		if !p.amongWords(matches, i) {
+			p.apply(m)
+		}
+		i += max(m.n, 1)
+	}
+	p.findGroup(matches, titleEnd)
+
+	return p.info
+}
+
+// stripChannelTag drops a leading "@channel - " credit added by Telegram
+// reposters.
+func stripChannelTag(name string) string {
+	if !strings.HasPrefix(name, "@") {
+		return name
+	}
+	if i := strings.IndexAny(name, " -"); i > 0 {
+		return strings.TrimLeft(name[i:], " -")
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// stripPrefix removes a leading [Group] tag, as fansub releases use, and a
+// leading website name: "www.example.com", "[example.com]" or
+// "example.com - ". A domain-looking pair of words without one of those
+// shapes is left alone, since titles like "Back to the Future" have them.
+func (p *parser) stripPrefix() {
+	if len(p.tokens) == 0 {
+		return
+	}
+
+	if id := p.tokens[0].bracket; id > 0 {
+		end := 0
+		for end < len(p.tokens) && p.tokens[end].bracket == id {
+			end++
+		}
+		known, domain := false, false
+		for i, t := range p.tokens[:end] {
+			if m := classifyText(strings.ToUpper(t.text)); m.kind != kindUnknown {
+				known = true
+			}
+			domain = domain || (i > 0 && isTLD(t.text))
+		}
+		switch {
+		case domain && end < len(p.tokens):
+			p.tokens = p.tokens[end:]
+		case !known && end < len(p.tokens):
+			p.info.Group = p.brackets[id-1]
+			p.tokens = p.tokens[end:]
+		}
+	}
+
+	if len(p.tokens) > 2 && strings.EqualFold(p.tokens[0].text, "www") {
+		for i := 2; i < len(p.tokens) && i < 5; i++ {
+			if isTLD(p.tokens[i].text) {
+				p.tokens = p.tokens[i+1:]
+				return
+			}
+		}
+	}
+
+	// "example.com - Title": one word, a TLD straight after it, then a
+	// spaced dash.
+	if len(p.tokens) > 2 && isTLD(p.tokens[1].text) && !p.tokens[1].dash &&
+		p.tokens[2].dash && !p.tokens[2].joined {
+		p.tokens = p.tokens[2:]
+	}
+}
+
+func isTLD(s string) bool {
+	switch strings.ToLower(s) {
+	case "com", "org", "net", "in", "me", "to", "co", "cc", "ws", "mx", "io", "xyz", "lol", "se", "ph", "pw",
+		"ai", "tv", "nl", "cz", "pe", "vc", "ag", "lt", "uk", "ru", "tips", "cafe", "site", "info", "biz", "club":
+		return true
+	}
+	return false
+}
+
+// classify reads the tokens left to right, joining neighbours where two or
+// three together form a known term. The other tokens of a joined match get
+// a copy of it with n set to 0.
+func (p *parser) classify() []match {
+	matches := make([]match, len(p.tokens))
+	for i := 0; i < len(p.tokens); {
+		m := p.classifyAt(i)
+		matches[i] = m
+		for j := 1; j < m.n; j++ {
+			matches[i+j] = m
+			matches[i+j].n = 0
+		}
+		i += max(m.n, 1)
+	}
+	return matches
+}
+
+func (p *parser) classifyAt(i int) match {
+	// Multi-token terms: "WEB-DL", "H.264", "Blu-Ray", "DTS-HD MA",
+	// "Dolby Vision", "Director's Cut".
+	for n := 3; n >= 2; n-- {
+		if i+n > len(p.tokens) || !sameBracket(p.tokens[i:i+n]) {
+			continue
+		}
+		key := strings.Join(p.upper[i:i+n], "")
+		if t, ok := vocab[key]; ok {
+			return match{kind: t.kind, value: t.value, ambiguous: t.ambiguous, n: n}
+		}
+		if m, ok := audioMatch(key); ok {
+			m.n = n
+			return m
+		}
+	}
+
+	if m, ok := p.episodeAt(i); ok {
+		return m
+	}
+	if m, ok := p.dateAt(i); ok {
+		return m
+	}
+
+	m := classifyText(p.upper[i])
+	m.n = 1
+	return m
+}
+
+func sameBracket(tokens []token) bool {
+	for _, t := range tokens[1:] {
+		if t.bracket != tokens[0].bracket {
+			return false
+		}
+	}
+	return true
+}
+
+// classifyText recognizes a single token on its own.
+func classifyText(upper string) match {
+	if t, ok := vocab[upper]; ok {
+		return match{kind: t.kind, value: t.value, ambiguous: t.ambiguous}
+	}
+
+	if y, err := strconv.Atoi(upper); err == nil && len(upper) == 4 && y >= 1900 && y <= time.Now().Year()+1 {
+		return match{kind: kindYear, value: upper}
+	}
+	if m := resolutionShape.FindStringSubmatch(upper); m != nil {
+		return match{kind: kindResolution, value: m[1] + "p"}
+	}
+	if m := dimensionsShape.FindStringSubmatch(upper); m != nil {
+		return match{kind: kindResolution, value: m[1] + "p"}
+	}
+	if channelsShape.MatchString(upper) {
+		return match{kind: kindChannels, value: upper}
+	}
+	if m, ok := audioMatch(upper); ok {
+		return m
+	}
+	if sizeShape.MatchString(upper) {
+		return match{kind: kindTag}
+	}
+	return match{}
+}
+
+// audioMatch reads an audio codec with an optional channel count straight
+// after it.
+func audioMatch(upper string) (match, bool) {
+	for _, a := range audioCodecs {
+		rest, ok := strings.CutPrefix(upper, a.prefix)
+		if !ok {
+			continue
+		}
+		if rest == "" {
+			return match{kind: kindAudio, value: a.codec}, true
+		}
+		if channelsShape.MatchString(rest) {
+			return match{kind: kindAudio, value: a.codec, channels: rest}, true
+		}
+		return match{}, false
+	}
+	return match{}, false
+}
+
+// episodeAt reads season and episode numbering starting at token i:
+// S01E02, S01E02E03, S01E02-E03, S01E02-03, 1x02, S01 E02, "Season 1
+// Episode 2", a lone S01 for a season pack, and the "Show - 05" numbering
+// fansub releases use, also after a season as in "Show S2 - 05".
+func (p *parser) episodeAt(i int) (match, bool) {
+	text := p.tokens[i].text
+	m := match{kind: kindEpisode, n: 1}
+
+	switch {
+	case seasonEpisodeShape.MatchString(text):
+		parts := seasonEpisodeShape.FindStringSubmatch(text)
+		m.season, _ = strconv.Atoi(parts[1])
+		episodes := strings.FieldsFunc(strings.ToUpper(parts[2]), func(r rune) bool { return r == 'E' })
+		m.episode, _ = strconv.Atoi(episodes[0])
+		if len(episodes) > 1 {
+			m.lastEpisode, _ = strconv.Atoi(episodes[len(episodes)-1])
+		}
+
+	case crossShape.MatchString(text):
+		parts := crossShape.FindStringSubmatch(text)
+		m.season, _ = strconv.Atoi(parts[1])
+		m.episode, _ = strconv.Atoi(parts[2])
+
+	case seasonShape.MatchString(text):
+		m.season, _ = strconv.Atoi(seasonShape.FindStringSubmatch(text)[1])
+		if i+1 < len(p.tokens) {
+			if e := episodeShape.FindStringSubmatch(p.tokens[i+1].text); e != nil {
+				m.episode, _ = strconv.Atoi(e[1])
+				m.n = 2
+			} else if episode, ok := p.dashNumberAt(i + 1); ok {
+				m.episode, m.n = episode, 2
+			}
+		}
+
+	case p.upper[i] == "SEASON" && i+1 < len(p.tokens):
+		// "Season - 28" is a fansub episode after a title ending in Season.
+		if next := p.tokens[i+1]; next.dash && !next.joined {
+			return match{}, false
+		}
+		season, err := strconv.Atoi(p.tokens[i+1].text)
+		if err != nil || season > 100 {
+			return match{}, false
+		}
+		m.season, m.n = season, 2
+		if i+3 < len(p.tokens) && (p.upper[i+2] == "EPISODE" || p.upper[i+2] == "EP") {
+			if episode, err := strconv.Atoi(p.tokens[i+3].text); err == nil {
+				m.episode, m.n = episode, 4
+			}
+		}
+
+	case (p.upper[i] == "EPISODE" || p.upper[i] == "EP") && i+1 < len(p.tokens):
+		episode, err := strconv.Atoi(p.tokens[i+1].text)
+		if err != nil {
+			return match{}, false
+		}
+		m.episode, m.n = episode, 2
+
+	case episodeShape.MatchString(text) && i > 0:
+		m.episode, _ = strconv.Atoi(episodeShape.FindStringSubmatch(text)[1])
+
+	case i > 0:
+		episode, ok := p.dashNumberAt(i)
+		if !ok {
+			return match{}, false
+		}
+		m.episode = episode
+
+	default:
+		return match{}, false
+	}
+
+	// A range continues after a dash: S01E01-E03, S01E01-03, "- 01-12".
+	if m.episode > 0 && m.lastEpisode == 0 {
+		if j := i + m.n; j < len(p.tokens) && p.tokens[j].dash {
+			next := strings.TrimPrefix(strings.TrimPrefix(p.upper[j], "E"), "P")
+			if last, err := strconv.Atoi(next); err == nil && last > m.episode && last-m.episode < 500 {
+				m.lastEpisode = last
+				m.n++
+			}
+		}
+	}
+	return m, true
+}
+
+// dashNumberAt reads the episode number of fansub releases, " - 05" or
+// " - 137v2", at token i. Years are left alone.
+func (p *parser) dashNumberAt(i int) (int, bool) {
+	t := p.tokens[i]
+	if !t.dash || t.joined || !absoluteShape.MatchString(t.text) || classifyText(p.upper[i]).kind == kindYear {
+		return 0, false
+	}
+	episode, _ := strconv.Atoi(absoluteShape.FindStringSubmatch(t.text)[1])
+	return episode, true
+}
+
+// dateAt reads the air date of a daily show, "2024.10.15", at token i. Only
+// its year is kept.
+func (p *parser) dateAt(i int) (match, bool) {
+	if i+2 >= len(p.tokens) || classifyText(p.upper[i]).kind != kindYear ||
+		!monthShape.MatchString(p.tokens[i+1].text) || !dayShape.MatchString(p.tokens[i+2].text) {
+		return match{}, false
+	}
+	return match{kind: kindDate, value: p.tokens[i].text, n: 3}, true
+}
+
+// findTitle decides where the title ends and returns that token index. The
+// title runs up to the first definite tag; a year before that tag splits
+// it off unless the year is the first word ("2012", "1917"). Language or
+// edition tags left at the end are trimmed.
+func (p *parser) findTitle(matches []match) int {
+	if len(matches) == 0 {
+		return 0
+	}
+
+	inTitle := func(i int) kind {
+		m := matches[i]
+		if m.ambiguous && p.upper[i] != p.tokens[i].text {
+			return kindUnknown
+		}
+		return m.kind
+	}
+
+	nextTag := func(from int) int {
+		for i := from; i < len(matches); i++ {
+			if k := inTitle(i); k != kindUnknown && !k.weak() {
+				return i
+			}
+		}
+		return len(matches)
+	}
+
+	stop := nextTag(0)
+	// A title can start with a tag word, as "HDR Is Coming" does, as long as
+	// words of its own follow before the next tag.
+	if stop == 0 && p.tokens[0].bracket == 0 && matches[0].kind != kindEpisode {
+		if next := nextTag(max(matches[0].n, 1)); !p.onlyTags(matches, 0, next) {
+			stop = next
+		}
+	}
+
+	titleEnd := stop
+	for j := stop - 1; j > 0; j-- {
+		if matches[j].kind != kindYear {
+			continue
+		}
+		from := p.editionPhrase(matches, j+1, stop)
+		if p.onlyTags(matches, from, stop) {
+			titleEnd = j
+			if from > j+1 {
+				p.info.Edition = p.join(j+1, from)
+			}
+			break
+		}
+	}
+	for titleEnd > 1 {
+		if k := inTitle(titleEnd - 1); !k.weak() || k == kindYear || k == kindChannels {
+			break
+		}
+		titleEnd--
+	}
+
+	var b strings.Builder
+	for i, t := range p.tokens[:titleEnd] {
+		if strings.HasPrefix(t.text, "@") {
+			continue
+		}
+		if b.Len() > 0 {
+			switch {
+			case t.joined:
+				b.WriteByte('-')
+			case isInitial(t.text) && isInitial(p.tokens[i-1].text):
+				// Keep acronyms like "S.W.A.T" together.
+				b.WriteByte('.')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(t.text)
+	}
+	p.info.Title = strings.TrimFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '!' && r != '?' && r != ')'
+	})
+
+	return titleEnd
+}
+
+// onlyTags reports whether every token in [from, to) is a known tag, which
+// is what lets a year before them end the title.
+func (p *parser) onlyTags(matches []match, from, to int) bool {
+	for i := from; i < to; {
+		if matches[i].kind == kindUnknown {
+			return false
+		}
+		i += max(matches[i].n, 1)
+	}
+	return true
+}
+
+func (p *parser) onlyUnknown(matches []match, from, to int) bool {
+	for i := from; i < to; {
+		if matches[i].kind != kindUnknown {
+			return false
+		}
+		i += max(matches[i].n, 1)
+	}
+	return true
+}
+
+// editionPhrase returns the end of a run of words from token from that
+// names an edition, like "10th Anniversary Edition" or "The Final Cut", or
+// from itself if there is none.
+func (p *parser) editionPhrase(matches []match, from, to int) int {
+	for i := from; i < to && i < from+5 && matches[i].kind == kindUnknown; i++ {
+		switch p.upper[i] {
+		case "EDITION", "CUT", "VERSION":
+			return i + 1
+		}
+	}
+	return from
+}
+
+func (p *parser) join(from, to int) string {
+	words := make([]string, 0, to-from)
+	for _, t := range p.tokens[from:to] {
+		words = append(words, t.text)
+	}
+	return strings.Join(words, " ")
+}
+
+// amongWords reports whether the ambiguous term at i, not written in
+// capitals, sits among ordinary words after an episode number, as "Japanese"
+// does in "S26E06.Japanese.Toilet".
+func (p *parser) amongWords(matches []match, i int) bool {
+	m := matches[i]
+	if !m.ambiguous || p.upper[i] == p.tokens[i].text || i == 0 {
+		return false
+	}
+	if prev := matches[i-1].kind; prev != kindUnknown && prev != kindEpisode {
+		return false
+	}
+	next := i + max(m.n, 1)
+	return next < len(matches) && matches[next].kind == kindUnknown
+}
+
+func (p *parser) apply(m match) {
+	info := &p.info
+	switch m.kind {
+	case kindYear, kindDate:
+		if info.Year == 0 {
+			info.Year, _ = strconv.Atoi(m.value)
+		}
+	case kindEpisode:
+		if info.Season == 0 && info.Episode == 0 {
+			info.Season, info.Episode, info.LastEpisode = m.season, m.episode, m.lastEpisode
+			info.Absolute = m.season == 0 && m.episode > 0
+		}
+	case kindResolution:
+		if info.Resolution == "" {
+			info.Resolution = m.value
+		}
+	case kindSource:
+		// Remux names usually say BluRay too; keep the more specific one.
+		if info.Source == "" || m.value == "Remux" {
+			info.Source = m.value
+		}
+	case kindService:
+		info.Service = m.value
+	case kindVideoCodec:
+		if info.VideoCodec == "" {
+			info.VideoCodec = m.value
+		}
+	case kindBitDepth:
+		info.BitDepth, _ = strconv.Atoi(m.value)
+	case kindHDR:
+		if !contains(info.HDR, m.value) {
+			info.HDR = append(info.HDR, m.value)
+		}
+	case kindAudio:
+		switch {
+		case m.value == "Atmos" && info.AudioCodec != "":
+			info.AudioCodec += " Atmos"
+		case info.AudioCodec == "":
+			info.AudioCodec = m.value
+		}
+		if m.channels != "" && info.AudioChannels == "" {
+			info.AudioChannels = m.channels
+		}
+	case kindChannels:
+		if info.AudioChannels == "" {
+			info.AudioChannels = m.value
+		}
+	case kindLanguage:
+		if !contains(info.Languages, m.value) {
+			info.Languages = append(info.Languages, m.value)
+		}
+	case kindMultiAudio:
+		info.MultiAudio = true
+	case kindEdition:
+		if info.Edition == "" {
+			info.Edition = m.value
+		}
+	case kindRepack:
+		info.Repack = true
+	}
+}
+
+// findGroup takes the release group from the end of the name: "-GROUP"
+// after the last tag, or a trailing [GROUP] that isn't a CRC checksum.
+func (p *parser) findGroup(matches []match, titleEnd int) {
+	if p.info.Group != "" || len(p.tokens) == 0 || len(p.tokens) <= titleEnd {
+		return
+	}
+
+	last := len(p.tokens) - 1
+	t := p.tokens[last]
+	// "-YTS.MX": a group named after its website.
+	if prev := last - 1; t.bracket == 0 && !t.dash && isTLD(t.text) && prev > titleEnd &&
+		p.tokens[prev].joined && matches[prev].kind == kindUnknown {
+		p.info.Group = p.tokens[prev].text + "." + t.text
+		return
+	}
+	if t.bracket == 0 {
+		if t.joined && matches[last].kind == kindUnknown && last > titleEnd {
+			p.info.Group = t.text
+		}
+		return
+	}
+
+	first := last
+	for first > titleEnd && p.tokens[first-1].bracket == t.bracket {
+		first--
+	}
+	if !p.onlyUnknown(matches, first, last+1) {
+		return
+	}
+	if group := p.brackets[t.bracket-1]; !crcShape.MatchString(group) {
+		p.info.Group = group
+	}
+}
+
+func isInitial(s string) bool {
+	r := []rune(s)
+	return len(r) == 1 && unicode.IsLetter(r[0])
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
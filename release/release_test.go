@@ -0,0 +1,163 @@
+package release
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.golden with the parser's output")
+
+// Golden files hold one release name per line, each followed by a line
+// starting with a tab that describes what Parse should make of it. Blank
+// lines and lines starting with # are kept as they are.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden files in testdata")
+	}
+
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".golden"), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+			var out []string
+			for i := 0; i < len(lines); i++ {
+				name := lines[i]
+				out = append(out, name)
+				if name == "" || strings.HasPrefix(name, "#") || strings.HasPrefix(name, "\t") {
+					continue
+				}
+
+				got := describe(Parse(name))
+				want := ""
+				if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+					i++
+					want = strings.TrimPrefix(lines[i], "\t")
+				}
+				out = append(out, "\t"+got)
+
+				if !*update && got != want {
+					t.Errorf("Parse(%q)\n got: %s\nwant: %s", name, got, want)
+				}
+			}
+
+			if *update {
+				if err := os.WriteFile(file, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name string
+		want Info
+	}{
+		{
+			name: "Back.to.the.Future.1985.REMASTERED.1080p.BluRay.x264.DTS-HD.MA.5.1-FGT.mkv",
+			want: Info{Title: "Back to the Future", Year: 1985, Resolution: "1080p", Source: "BluRay",
+				VideoCodec: "AVC", AudioCodec: "DTS-HD MA", AudioChannels: "5.1", Edition: "Remastered",
+				Group: "FGT", Container: "mkv"},
+		},
+		{
+			name: "www.1TamilMV.com - Jailer (2023) Tamil HQ HDRip - 1080p - x264 - (DD+5.1 - 192Kbps & AAC) - 2.5GB - ESub.mkv",
+			want: Info{Title: "Jailer", Year: 2023, Resolution: "1080p", Source: "HDRip", VideoCodec: "AVC",
+				AudioCodec: "EAC3", AudioChannels: "5.1", Languages: []string{"ta"}, Container: "mkv"},
+		},
+		{
+			name: "The.Bear.S02E01-E03.2160p.HULU.WEB-DL.DDP5.1.DV.HDR.H.265-NTb.mkv",
+			want: Info{Title: "The Bear", Season: 2, Episode: 1, LastEpisode: 3, Resolution: "2160p",
+				Source: "WEB-DL", Service: "HULU", VideoCodec: "HEVC", HDR: []string{"DV", "HDR"},
+				AudioCodec: "EAC3", AudioChannels: "5.1", Group: "NTb", Container: "mkv"},
+		},
+		{
+			name: "[SubsPlease] Jujutsu Kaisen - 47 (1080p) [A1B2C3D4].mkv",
+			want: Info{Title: "Jujutsu Kaisen", Episode: 47, Absolute: true, Resolution: "1080p",
+				Group: "SubsPlease", Container: "mkv"},
+		},
+		// Names with no words left after the extension and punctuation.
+		{name: "", want: Info{}},
+		{name: ".mkv", want: Info{Container: "mkv"}},
+		{name: "_.mkv", want: Info{Container: "mkv"}},
+		{name: "---.mp4", want: Info{Container: "mp4"}},
+		{name: "(((", want: Info{}},
+		{name: "-", want: Info{}},
+		{name: ".", want: Info{}},
+		{name: "_", want: Info{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.name)
+			if describe(got) != describe(tt.want) {
+				t.Errorf("Parse(%q)\n got: %s\nwant: %s", tt.name, describe(got), describe(tt.want))
+			}
+		})
+	}
+}
+
+// describe writes the fields Parse filled in, in a fixed order, for
+// comparing against golden files.
+func describe(info Info) string {
+	var fields []string
+	add := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if strings.ContainsAny(value, ` "=`) {
+			value = strconv.Quote(value)
+		}
+		fields = append(fields, key+"="+value)
+	}
+	number := func(key string, n int) {
+		if n != 0 {
+			add(key, strconv.Itoa(n))
+		}
+	}
+
+	add("title", info.Title)
+	number("year", info.Year)
+	number("s", info.Season)
+	switch {
+	case info.LastEpisode > 0:
+		add("e", fmt.Sprintf("%d-%d", info.Episode, info.LastEpisode))
+	case info.Episode > 0:
+		add("e", strconv.Itoa(info.Episode))
+	}
+	if info.Absolute {
+		fields = append(fields, "abs")
+	}
+	add("res", info.Resolution)
+	add("source", info.Source)
+	add("service", info.Service)
+	add("codec", info.VideoCodec)
+	number("bits", info.BitDepth)
+	add("hdr", strings.Join(info.HDR, ","))
+	add("audio", info.AudioCodec)
+	add("ch", info.AudioChannels)
+	add("lang", strings.Join(info.Languages, ","))
+	if info.MultiAudio {
+		fields = append(fields, "multi")
+	}
+	add("edition", info.Edition)
+	if info.Repack {
+		fields = append(fields, "repack")
+	}
+	add("group", info.Group)
+	add("ext", info.Container)
+	return strings.Join(fields, " ")
+}
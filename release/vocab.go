@@ -0,0 +1,228 @@
+package release
+
+type kind int
+
+const (
+	kindUnknown kind = iota
+	kindYear
+	kindEpisode
+	kindDate
+	kindResolution
+	kindSource
+	kindService
+	kindVideoCodec
+	kindBitDepth
+	kindHDR
+	kindAudio
+	kindChannels
+	kindLanguage
+	kindMultiAudio
+	kindEdition
+	kindRepack
+	kindTag
+)
+
+// weak kinds can appear between a title and its year, so they don't end
+// the title on their own.
+func (k kind) weak() bool {
+	switch k {
+	case kindYear, kindChannels, kindLanguage, kindMultiAudio, kindEdition, kindRepack, kindTag:
+		return true
+	}
+	return false
+}
+
+type term struct {
+	kind  kind
+	value string
+	// ambiguous terms are also ordinary words ("German", "Extended") and
+	// only count inside a title when written in capitals.
+	ambiguous bool
+}
+
+// vocab maps upper-cased tokens, with any dashes or dots between joined
+// tokens removed, to what they mean.
+var vocab = map[string]term{}
+
+func init() {
+	add := func(k kind, value string, ambiguous bool, keys ...string) {
+		for _, key := range keys {
+			vocab[key] = term{kind: k, value: value, ambiguous: ambiguous}
+		}
+	}
+
+	add(kindResolution, "2160p", false, "4K", "UHD", "2160P")
+	add(kindResolution, "1080p", false, "FHD", "1080P", "1080I")
+	add(kindResolution, "720p", false, "HD", "720P")
+	add(kindResolution, "480p", false, "SD", "480P")
+
+	add(kindSource, "WEB-DL", false, "WEBDL", "WEBHD")
+	add(kindSource, "WEBRip", false, "WEBRIP", "WEBMUX")
+	add(kindSource, "WEB", true, "WEB")
+	add(kindSource, "BluRay", false, "BLURAY", "BDREMUX", "BD25", "BD50", "BD")
+	add(kindSource, "BDRip", false, "BDRIP")
+	add(kindSource, "BRRip", false, "BRRIP")
+	add(kindSource, "Remux", false, "REMUX")
+	add(kindSource, "HDRip", false, "HDRIP")
+	add(kindSource, "HDTV", false, "HDTV", "HDTVRIP")
+	add(kindSource, "PDTV", false, "PDTV", "SDTV", "DSR", "DSRIP")
+	add(kindSource, "DVDRip", false, "DVDRIP")
+	add(kindSource, "DVD", false, "DVD", "DVDR", "DVD5", "DVD9", "NTSC")
+	add(kindSource, "DVD", true, "PAL")
+	add(kindSource, "SCR", false, "SCR", "SCREENER", "DVDSCR", "BDSCR")
+	add(kindSource, "CAM", false, "CAMRIP", "HDCAM", "HQCAM")
+	add(kindSource, "CAM", true, "CAM")
+	add(kindSource, "TS", false, "HDTS", "TELESYNC", "PDVD", "PREDVD")
+	add(kindSource, "TS", true, "TS")
+	add(kindSource, "TC", false, "HDTC", "TELECINE")
+	add(kindSource, "TC", true, "TC")
+	add(kindSource, "R5", false, "R5", "R6")
+	add(kindSource, "Workprint", false, "WORKPRINT")
+
+	add(kindService, "AMZN", false, "AMZN")
+	add(kindService, "AMZN", true, "AMAZON")
+	add(kindService, "NF", false, "NF")
+	add(kindService, "NF", true, "NETFLIX")
+	add(kindService, "DSNP", false, "DSNP", "DSNY")
+	add(kindService, "DSNP", true, "DISNEY")
+	add(kindService, "HMAX", false, "HMAX")
+	add(kindService, "MAX", true, "MAX")
+	add(kindService, "CR", false, "CR", "CRUNCHYROLL")
+	add(kindService, "ATVP", false, "ATVP", "APTV")
+	add(kindService, "HULU", false, "HULU")
+	add(kindService, "PCOK", false, "PCOK")
+	add(kindService, "PMTP", false, "PMTP")
+	add(kindService, "CRAV", false, "CRAV")
+	add(kindService, "ZEE5", false, "ZEE5")
+	add(kindService, "HS", false, "HOTSTAR", "DSNPHS")
+	add(kindService, "SONYLIV", false, "SONYLIV", "SLIV")
+	add(kindService, "JC", false, "JIOCINEMA")
+	add(kindService, "iT", false, "ITUNES")
+
+	add(kindVideoCodec, "AVC", false, "X264", "H264", "AVC")
+	add(kindVideoCodec, "HEVC", false, "X265", "H265", "HEVC")
+	add(kindVideoCodec, "AV1", false, "AV1")
+	add(kindVideoCodec, "VP9", false, "VP9")
+	add(kindVideoCodec, "XviD", false, "XVID")
+	add(kindVideoCodec, "DivX", false, "DIVX")
+	add(kindVideoCodec, "MPEG2", false, "MPEG2")
+	add(kindVideoCodec, "VC-1", false, "VC1")
+
+	add(kindBitDepth, "10", false, "10BIT", "10BITS", "HI10", "HI10P")
+	add(kindBitDepth, "8", false, "8BIT", "8BITS")
+	add(kindBitDepth, "12", false, "12BIT", "12BITS")
+
+	add(kindHDR, "HDR", false, "HDR")
+	add(kindHDR, "HDR10", false, "HDR10")
+	add(kindHDR, "HDR10+", false, "HDR10+", "HDR10PLUS")
+	add(kindHDR, "DV", false, "DV", "DOVI", "DOLBYVISION")
+	add(kindHDR, "HLG", false, "HLG")
+
+	add(kindChannels, "7.1", false, "8CH")
+	add(kindChannels, "5.1", false, "6CH")
+	add(kindChannels, "2.0", false, "2CH")
+
+	add(kindLanguage, "en", false, "ENG")
+	add(kindLanguage, "en", true, "ENGLISH")
+	add(kindLanguage, "hi", false, "HINDI", "HIN")
+	add(kindLanguage, "ta", false, "TAMIL")
+	add(kindLanguage, "ta", true, "TAM")
+	add(kindLanguage, "te", false, "TELUGU")
+	add(kindLanguage, "te", true, "TEL")
+	add(kindLanguage, "ml", false, "MALAYALAM")
+	add(kindLanguage, "ml", true, "MAL")
+	add(kindLanguage, "kn", false, "KANNADA")
+	add(kindLanguage, "kn", true, "KAN")
+	add(kindLanguage, "bn", false, "BENGALI")
+	add(kindLanguage, "mr", false, "MARATHI")
+	add(kindLanguage, "pa", false, "PUNJABI")
+	add(kindLanguage, "fr", false, "FRE", "VFF", "VFQ", "VF2", "TRUEFRENCH", "VOSTFR")
+	add(kindLanguage, "fr", true, "FRENCH")
+	add(kindLanguage, "de", false, "GER", "DEU")
+	add(kindLanguage, "de", true, "GERMAN")
+	add(kindLanguage, "es", false, "SPA", "ESP", "CASTELLANO")
+	add(kindLanguage, "es", true, "SPANISH", "LATINO")
+	add(kindLanguage, "it", false, "ITA")
+	add(kindLanguage, "it", true, "ITALIAN")
+	add(kindLanguage, "ja", false, "JAP", "JPN")
+	add(kindLanguage, "ja", true, "JAPANESE")
+	add(kindLanguage, "ko", false, "KOR")
+	add(kindLanguage, "ko", true, "KOREAN")
+	add(kindLanguage, "zh", false, "CHI", "MANDARIN", "CANTONESE")
+	add(kindLanguage, "zh", true, "CHINESE")
+	add(kindLanguage, "ru", false, "RUS")
+	add(kindLanguage, "ru", true, "RUSSIAN")
+	add(kindLanguage, "pt", false, "POR", "PTBR")
+	add(kindLanguage, "pt", true, "PORTUGUESE")
+	add(kindLanguage, "ar", true, "ARABIC")
+	add(kindLanguage, "tr", true, "TURKISH")
+	add(kindLanguage, "nl", true, "DUTCH", "FLEMISH")
+	add(kindLanguage, "da", true, "DANISH")
+	add(kindLanguage, "sv", true, "SWEDISH")
+	add(kindLanguage, "no", true, "NORWEGIAN")
+	add(kindLanguage, "fi", true, "FINNISH")
+	add(kindLanguage, "pl", true, "POLISH")
+	add(kindLanguage, "cs", true, "CZECH")
+	add(kindLanguage, "hu", true, "HUNGARIAN")
+	add(kindLanguage, "el", true, "GREEK")
+	add(kindLanguage, "he", true, "HEBREW")
+	add(kindLanguage, "fa", true, "PERSIAN", "FARSI")
+	add(kindLanguage, "th", true, "THAI")
+	add(kindLanguage, "vi", true, "VIETNAMESE")
+	add(kindLanguage, "id", true, "INDONESIAN")
+	add(kindLanguage, "uk", true, "UKRAINIAN")
+	add(kindLanguage, "ur", false, "URDU")
+	add(kindLanguage, "gu", false, "GUJARATI")
+
+	add(kindMultiAudio, "", false, "DUALAUDIO", "MULTI", "MULTIAUDIO", "DL")
+	add(kindMultiAudio, "", true, "DUAL")
+
+	add(kindEdition, "Extended", true, "EXTENDED", "EXTENDEDCUT", "EXTENDEDEDITION")
+	add(kindEdition, "Unrated", true, "UNRATED")
+	add(kindEdition, "Uncut", true, "UNCUT")
+	add(kindEdition, "Theatrical", true, "THEATRICAL", "THEATRICALCUT")
+	add(kindEdition, "Director's Cut", false, "DIRECTORSCUT", "DIRECTOR'SCUT")
+	add(kindEdition, "IMAX", false, "IMAX")
+	add(kindEdition, "Remastered", true, "REMASTERED")
+	add(kindEdition, "Criterion", true, "CRITERION")
+	add(kindEdition, "Special Edition", false, "SPECIALEDITION")
+	add(kindEdition, "Open Matte", false, "OPENMATTE")
+
+	add(kindRepack, "", false, "PROPER", "REPACK", "RERIP", "REAL")
+
+	add(kindTag, "", false, "INTERNAL", "FESTIVAL", "STV", "DUBBED", "SUBBED",
+		"HC", "HARDSUB", "HARDSUBS", "KORSUB", "ESUB", "ESUBS", "MSUB", "MSUBS", "SUBS",
+		"HQ", "READNFO", "NFOFIX", "RETAIL", "HYBRID", "WS", "UNCENSORED", "SDR", "UNTOUCHED",
+		"MULTISUB", "MULTISUBS", "ENGSUB", "ENGSUBS", "ENGLISHSUB", "ENGLISHSUBS", "ENGLISHSUBBED")
+	add(kindTag, "", true, "LIMITED", "COMPLETE", "CONVERT", "MA", "TRUE")
+}
+
+// audioCodecs are matched as token prefixes, longest first, so channel
+// counts written straight after them ("DDP5.1", "AAC2.0") are split off.
+var audioCodecs = []struct {
+	prefix string
+	codec  string
+}{
+	{"TRUEHD", "TrueHD"},
+	{"DTSHDMA", "DTS-HD MA"},
+	{"DTSHD", "DTS-HD"},
+	{"DTSX", "DTS:X"},
+	{"ATMOS", "Atmos"},
+	{"EAC3", "EAC3"},
+	{"LPCM", "PCM"},
+	{"FLAC", "FLAC"},
+	{"OPUS", "Opus"},
+	{"DDP", "EAC3"},
+	{"DD+", "EAC3"},
+	{"AAC", "AAC"},
+	{"AC3", "AC3"},
+	{"DTS", "DTS"},
+	{"MP3", "MP3"},
+	{"PCM", "PCM"},
+	{"DD", "AC3"},
+}
+
+var videoExtensions = map[string]bool{
+	".mkv": true, ".mp4": true, ".avi": true, ".mov": true, ".wmv": true,
+	".flv": true, ".webm": true, ".m4v": true, ".ts": true, ".m2ts": true,
+}
@@ -0,0 +1,155 @@
+package search
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"strix/database"
+)
+
+const (
+	maxResults    = 100
+	buildPageSize = 1000
+)
+
+// IndexedDB wraps a database.DB so file searches are answered from the
+// in-memory index, which it keeps up to date as files are added. It also
+// keeps the title metadata needed for suggestions.
+type IndexedDB struct {
+	database.DB
+	index *Index
+
+	titlesMu sync.RWMutex
+	titles   map[database.TitleKey]*database.Title
+}
+
+// Wrap loads the whole library into a new index and returns db with search
+// served from it.
+func Wrap(db database.DB) (*IndexedDB, error) {
+	start := time.Now()
+	index := NewIndex()
+
+	// Adding the whole library at once sorts the vocab a single time.
+	var all []database.MediaFile
+	for offset := 0; ; offset += buildPageSize {
+		files, err := db.GetAllMedia(buildPageSize, offset)
+		if err != nil {
+			return nil, fmt.Errorf("build search index: %w", err)
+		}
+		all = append(all, files...)
+		if len(files) < buildPageSize {
+			break
+		}
+	}
+	index.Add(all...)
+
+	titles, err := db.GetAllTitles()
+	if err != nil {
+		return nil, fmt.Errorf("load titles: %w", err)
+	}
+	d := &IndexedDB{
+		DB:     db,
+		index:  index,
+		titles: make(map[database.TitleKey]*database.Title, len(titles)),
+	}
+	for i := range titles {
+		d.cacheTitle(&titles[i])
+	}
+
+	log.Printf("[SEARCH] Indexed %d files in %v", index.Len(), time.Since(start).Round(time.Millisecond))
+	return d, nil
+}
+
+func (d *IndexedDB) SearchMedia(query string) ([]database.MediaFile, error) {
+	return d.index.Search(query, Options{Limit: maxResults}), nil
+}
+
+func (d *IndexedDB) SearchByTitle(query string) ([]database.MediaFile, error) {
+	return d.index.Search(query, Options{TitleOnly: true, Limit: maxResults}), nil
+}
+
+func (d *IndexedDB) AddMedia(tmdbID int, mediaType, title, fileID string, messageID int, chatID int64, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, cdnBotIndex int, release *database.ReleaseInfo) error {
+	if err := d.DB.AddMedia(tmdbID, mediaType, title, fileID, messageID, chatID, fileSize, fileName, season, episode, lastEpisode, quality, cdnBotIndex, release); err != nil {
+		return err
+	}
+	d.reindex(database.MediaFile{TMDBID: tmdbID, MediaType: mediaType, Season: season, Episode: episode})
+	return nil
+}
+
+func (d *IndexedDB) AddMediaBatch(files []database.MediaFile) error {
+	if err := d.DB.AddMediaBatch(files); err != nil {
+		return err
+	}
+	d.reindex(files...)
+	return nil
+}
+
+func (d *IndexedDB) AddLocalMedia(tmdbID int, mediaType, title, filePath string, fileSize int64, fileName string, season, episode, lastEpisode int, quality string, release *database.ReleaseInfo) error {
+	if err := d.DB.AddLocalMedia(tmdbID, mediaType, title, filePath, fileSize, fileName, season, episode, lastEpisode, quality, release); err != nil {
+		return err
+	}
+	d.reindex(database.MediaFile{TMDBID: tmdbID, MediaType: mediaType, Season: season, Episode: episode})
+	return nil
+}
+
+func (d *IndexedDB) SetMediaProbe(id string, quality string, probe *database.MediaProbe) error {
+	if err := d.DB.SetMediaProbe(id, quality, probe); err != nil {
+		return err
+	}
+	m, err := d.DB.GetMediaByID(id)
+	if err != nil || m == nil {
+		return err
+	}
+	d.reindex(*m)
+	return nil
+}
+
+// reindex reloads the stored versions of each added file's title and
+// episode, so the index holds the documents as saved, IDs included.
+func (d *IndexedDB) reindex(files ...database.MediaFile) {
+	type slot struct {
+		tmdbID          int
+		mediaType       string
+		season, episode int
+	}
+	seen := make(map[slot]bool)
+
+	for _, f := range files {
+		s := slot{f.TMDBID, f.MediaType, f.Season, f.Episode}
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		versions, err := d.DB.GetMediaVersions(f.TMDBID, f.MediaType, f.Season, f.Episode)
+		if err != nil {
+			log.Printf("[SEARCH] Failed to reindex %s %d: %v", f.MediaType, f.TMDBID, err)
+			continue
+		}
+		d.index.Add(versions...)
+	}
+}
+
+func (d *IndexedDB) UpsertTitle(t *database.Title) error {
+	if err := d.DB.UpsertTitle(t); err != nil {
+		return err
+	}
+	d.cacheTitle(t)
+	return nil
+}
+
+// cacheTitle keeps a copy of the title without its episode list, which
+// suggestions don't need.
+func (d *IndexedDB) cacheTitle(t *database.Title) {
+	title := *t
+	title.Episodes = nil
+
+	key := database.TitleKey{TMDBID: t.TMDBID, MediaType: t.MediaType}
+	d.titlesMu.Lock()
+	d.titles[key] = &title
+	d.titlesMu.Unlock()
+
+	d.index.SetYear(key, t.Year)
+}
@@ -0,0 +1,513 @@
+// Package search keeps an in-memory, typo-tolerant index of the library so
+// file searches don't depend on the database's text search.
+package search
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"strix/database"
+)
+
+// Field weights: a hit in the title counts far more than one that only
+// appears in the file name.
+const (
+	titleWeight    = 1.0
+	fileNameWeight = 0.35
+
+	exactScore  = 1.0
+	prefixScore = 0.8
+	fuzzyScore  = 0.65
+
+	exactTitleBoost = 2.0
+	yearBoost       = 1.0
+
+	minPrefixLen = 2
+
+	// vocabInsertLimit is how many new terms are inserted into the sorted
+	// vocab one by one; larger batches are appended and sorted once.
+	vocabInsertLimit = 64
+)
+
+var yearRegex = regexp.MustCompile(`\b(19\d{2}|20\d{2})\b`)
+
+type document struct {
+	media database.MediaFile
+	title string
+	// year is read from the file name, for titles whose year isn't known.
+	year int
+	// terms holds each indexed term with the weight of the best field it
+	// appears in.
+	terms map[string]float64
+}
+
+// Index maps normalized terms to library files. It is safe for concurrent
+// use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]float64
+	grams    map[string]map[string]struct{}
+	// files counts indexed files per title.
+	files map[database.TitleKey]int
+	// years holds the stored year of each title that has one.
+	years map[database.TitleKey]int
+	// vocab is every term in sorted order, for prefix lookups.
+	vocab []string
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]float64),
+		grams:    make(map[string]map[string]struct{}),
+		files:    make(map[database.TitleKey]int),
+		years:    make(map[database.TitleKey]int),
+	}
+}
+
+// Len returns the number of indexed files.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// docKey identifies a file the same way the database upserts it, so a file
+// that is stored again replaces its old entry.
+func docKey(m *database.MediaFile) string {
+	if m.Source == database.SourceLocal {
+		return "local:" + m.FilePath
+	}
+	return "tg:" + strconv.FormatInt(m.ChatID, 10) + ":" + strconv.Itoa(m.MessageID)
+}
+
+// Add indexes files, replacing any earlier entry for the same file.
+func (idx *Index) Add(files ...database.MediaFile) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var added []string
+	for _, m := range files {
+		key := docKey(&m)
+		idx.remove(key)
+
+		doc := &document{
+			media: m,
+			title: Normalize(m.Title),
+			terms: make(map[string]float64),
+		}
+		if match := yearRegex.FindString(m.FileName); match != "" {
+			doc.year, _ = strconv.Atoi(match)
+		}
+
+		for _, term := range indexTerms(m.FileName) {
+			doc.terms[term] = fileNameWeight
+		}
+		for _, term := range indexTerms(m.Title) {
+			doc.terms[term] = titleWeight
+		}
+
+		for term, weight := range doc.terms {
+			postings := idx.postings[term]
+			if postings == nil {
+				postings = make(map[string]float64)
+				idx.postings[term] = postings
+				idx.addTrigrams(term)
+				added = append(added, term)
+			}
+			postings[key] = weight
+		}
+		idx.docs[key] = doc
+		idx.files[titleKey(&m)]++
+	}
+	idx.insertTerms(added)
+}
+
+// SetYear records a title's year, which takes the place of any year in its
+// file names when a search asks for one.
+func (idx *Index) SetYear(key database.TitleKey, year int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if year > 0 {
+		idx.years[key] = year
+	} else {
+		delete(idx.years, key)
+	}
+}
+
+func (idx *Index) docYear(doc *document) int {
+	if year := idx.years[titleKey(&doc.media)]; year > 0 {
+		return year
+	}
+	return doc.year
+}
+
+// Has reports whether any file of the title is indexed.
+func (idx *Index) Has(key database.TitleKey) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.files[key] > 0
+}
+
+func titleKey(m *database.MediaFile) database.TitleKey {
+	return database.TitleKey{TMDBID: m.TMDBID, MediaType: m.MediaType}
+}
+
+func (idx *Index) remove(key string) {
+	doc := idx.docs[key]
+	if doc == nil {
+		return
+	}
+	for term := range doc.terms {
+		postings := idx.postings[term]
+		delete(postings, key)
+		if len(postings) == 0 {
+			delete(idx.postings, term)
+			idx.removeTerm(term)
+		}
+	}
+	delete(idx.docs, key)
+
+	if tk := titleKey(&doc.media); idx.files[tk] > 1 {
+		idx.files[tk]--
+	} else {
+		delete(idx.files, tk)
+	}
+}
+
+// insertTerms adds terms new to the index to the sorted vocab. Terms a
+// later file of the same batch removed again are skipped.
+func (idx *Index) insertTerms(terms []string) {
+	if len(terms) > vocabInsertLimit {
+		for _, term := range terms {
+			if idx.postings[term] != nil {
+				idx.vocab = append(idx.vocab, term)
+			}
+		}
+		sort.Strings(idx.vocab)
+		idx.vocab = slices.Compact(idx.vocab)
+		return
+	}
+
+	for _, term := range terms {
+		i, found := slices.BinarySearch(idx.vocab, term)
+		if found || idx.postings[term] == nil {
+			continue
+		}
+		idx.vocab = slices.Insert(idx.vocab, i, term)
+	}
+}
+
+func (idx *Index) addTrigrams(term string) {
+	for _, g := range trigrams(term) {
+		if idx.grams[g] == nil {
+			idx.grams[g] = make(map[string]struct{})
+		}
+		idx.grams[g][term] = struct{}{}
+	}
+}
+
+func (idx *Index) removeTerm(term string) {
+	if i := sort.SearchStrings(idx.vocab, term); i < len(idx.vocab) && idx.vocab[i] == term {
+		idx.vocab = append(idx.vocab[:i], idx.vocab[i+1:]...)
+	}
+	for _, g := range trigrams(term) {
+		delete(idx.grams[g], term)
+		if len(idx.grams[g]) == 0 {
+			delete(idx.grams, g)
+		}
+	}
+}
+
+// Options narrows a search.
+type Options struct {
+	// TitleOnly ignores terms that only appear in file names.
+	TitleOnly bool
+	Limit     int
+}
+
+type scored struct {
+	doc   *document
+	score float64
+}
+
+// Search ranks files against the query. Every word has to match a term
+// exactly, as a prefix, or within a small edit distance; a year in the
+// query is optional but boosts files from that year, and a query equal to
+// the whole title ranks first.
+func (idx *Index) Search(query string, opts Options) []database.MediaFile {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	results := idx.rank(query, opts)
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	files := make([]database.MediaFile, len(results))
+	for i, r := range results {
+		files[i] = r.doc.media
+	}
+	return files
+}
+
+// SearchTitles ranks titles rather than files: each title matched to TMDB
+// appears once, placed by its best-scoring file, which is the one returned.
+// The limit counts titles.
+func (idx *Index) SearchTitles(query string, opts Options) []database.MediaFile {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	seen := make(map[database.TitleKey]bool)
+	var files []database.MediaFile
+	for _, r := range idx.rank(query, opts) {
+		key := titleKey(&r.doc.media)
+		if key.TMDBID == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		files = append(files, r.doc.media)
+		if opts.Limit > 0 && len(files) >= opts.Limit {
+			break
+		}
+	}
+	return files
+}
+
+// rank scores every file matching the query, best first. The caller holds
+// the read lock.
+func (idx *Index) rank(query string, opts Options) []scored {
+	words := strings.Fields(Normalize(query))
+	if len(words) == 0 {
+		return nil
+	}
+
+	var year int
+	var terms []string
+	for _, w := range words {
+		if yearRegex.MatchString(w) && year == 0 && len(words) > 1 {
+			year, _ = strconv.Atoi(w)
+			continue
+		}
+		terms = append(terms, w)
+	}
+	// "spider man" should also find "Spiderman" and the other way round,
+	// which indexTerms covers by indexing joined word pairs.
+	phrase := strings.Join(terms, " ")
+
+	var scores map[string]float64
+	for i, term := range terms {
+		matches := idx.matchTerm(term, opts.TitleOnly)
+		if i+1 < len(terms) {
+			// A joined pair can stand in for two words the file spells as one.
+			for key, s := range idx.matchTerm(term+terms[i+1], opts.TitleOnly) {
+				matches[key] = max(matches[key], s)
+			}
+		}
+		if i > 0 {
+			for key, s := range idx.matchTerm(terms[i-1]+term, opts.TitleOnly) {
+				matches[key] = max(matches[key], s)
+			}
+		}
+
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for key := range scores {
+			if s, ok := matches[key]; ok {
+				scores[key] += s
+			} else {
+				delete(scores, key)
+			}
+		}
+	}
+
+	results := make([]scored, 0, len(scores))
+	for key, score := range scores {
+		doc := idx.docs[key]
+		if doc.title == phrase {
+			score += exactTitleBoost
+		}
+		if year > 0 && idx.docYear(doc) == year {
+			score += yearBoost
+		}
+		results = append(results, scored{doc: doc, score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.doc.media.Title != b.doc.media.Title {
+			return a.doc.media.Title < b.doc.media.Title
+		}
+		if a.doc.media.Season != b.doc.media.Season {
+			return a.doc.media.Season < b.doc.media.Season
+		}
+		if a.doc.media.Episode != b.doc.media.Episode {
+			return a.doc.media.Episode < b.doc.media.Episode
+		}
+		return database.QualityRank(a.doc.media.Quality) > database.QualityRank(b.doc.media.Quality)
+	})
+	return results
+}
+
+// matchTerm scores every file containing a term that matches the query
+// term, keeping the best score per file.
+func (idx *Index) matchTerm(term string, titleOnly bool) map[string]float64 {
+	matches := make(map[string]float64)
+	add := func(indexed string, score float64) {
+		for key, weight := range idx.postings[indexed] {
+			if titleOnly && weight < titleWeight {
+				continue
+			}
+			matches[key] = max(matches[key], score*weight)
+		}
+	}
+
+	add(term, exactScore)
+
+	if len([]rune(term)) >= minPrefixLen {
+		for i := sort.SearchStrings(idx.vocab, term); i < len(idx.vocab) && strings.HasPrefix(idx.vocab[i], term); i++ {
+			if idx.vocab[i] != term {
+				add(idx.vocab[i], prefixScore)
+			}
+		}
+	}
+
+	if maxDist := allowedTypos(term); maxDist > 0 {
+		for candidate, dist := range idx.fuzzyTerms(term, maxDist) {
+			add(candidate, fuzzyScore*(1-0.15*float64(dist-1)))
+		}
+	}
+
+	return matches
+}
+
+// fuzzyTerms finds indexed terms within maxDist edits of term. Candidates
+// come from shared trigrams: each edit can break at most three of them.
+func (idx *Index) fuzzyTerms(term string, maxDist int) map[string]int {
+	grams := trigrams(term)
+	shared := make(map[string]int)
+	for _, g := range grams {
+		for candidate := range idx.grams[g] {
+			shared[candidate]++
+		}
+	}
+
+	need := max(1, len(grams)-3*maxDist)
+	rt := []rune(term)
+	found := make(map[string]int)
+	for candidate, n := range shared {
+		if n < need || candidate == term {
+			continue
+		}
+		rc := []rune(candidate)
+		if abs(len(rc)-len(rt)) > maxDist {
+			continue
+		}
+		if d := levenshtein(rt, rc); d <= maxDist {
+			found[candidate] = d
+		}
+	}
+	return found
+}
+
+// allowedTypos scales the edit distance with the word length. Numbers
+// must match exactly, or "2019" would find every year around it.
+func allowedTypos(term string) int {
+	if strings.IndexFunc(term, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+		return 0
+	}
+	switch n := len([]rune(term)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// indexTerms splits text into normalized words plus each adjacent pair
+// joined, so "Spider-Man" is found by "spiderman".
+func indexTerms(text string) []string {
+	words := strings.Fields(Normalize(text))
+	terms := make([]string, 0, 2*len(words))
+	for i, w := range words {
+		terms = append(terms, w)
+		if i+1 < len(words) {
+			terms = append(terms, w+words[i+1])
+		}
+	}
+	return terms
+}
+
+// Normalize lowercases text, strips diacritics and turns punctuation and
+// release-name separators into spaces.
+func Normalize(text string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case r == '\'' || r == '’':
+			// Drop apostrophes so "Ocean's" matches "oceans".
+		case r == '&':
+			b.WriteString(" and ")
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func trigrams(term string) []string {
+	r := []rune("^" + term + "$")
+	grams := make([]string, 0, len(r))
+	for i := 0; i+3 <= len(r); i++ {
+		grams = append(grams, string(r[i:i+3]))
+	}
+	return grams
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
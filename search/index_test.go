@@ -0,0 +1,238 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"strix/database"
+)
+
+// file is a Telegram file of a movie, identified by its message.
+func file(message, tmdbID int, title, fileName string) database.MediaFile {
+	return database.MediaFile{
+		ChatID:    -100,
+		MessageID: message,
+		TMDBID:    tmdbID,
+		MediaType: "movie",
+		Title:     title,
+		FileName:  fileName,
+	}
+}
+
+func testIndex() *Index {
+	idx := NewIndex()
+	idx.Add(
+		file(1, 603, "The Matrix", "The.Matrix.1999.1080p.BluRay.x264.mkv"),
+		file(2, 157336, "Interstellar", "Interstellar.2014.2160p.UHD.BluRay.mkv"),
+		file(3, 27205, "Inception", "Inception.2010.720p.WEB-DL.mkv"),
+		file(4, 634649, "Spider-Man: No Way Home", "Spider-Man.No.Way.Home.2021.1080p.mkv"),
+		file(5, 315635, "Spiderman Homecoming", "Spiderman.Homecoming.2017.1080p.mkv"),
+		file(6, 841, "Dune", "Dune.1984.1080p.BluRay.mkv"),
+		file(7, 438631, "Dune", "Dune.2021.2160p.WEB-DL.mkv"),
+		file(8, 14160, "Up", "Up.2009.1080p.mkv"),
+	)
+	return idx
+}
+
+// ids lists the TMDB IDs of the results, in order.
+func ids(files []database.MediaFile) []int {
+	out := make([]int, len(files))
+	for i, f := range files {
+		out[i] = f.TMDBID
+	}
+	return out
+}
+
+func TestSearch(t *testing.T) {
+	idx := testIndex()
+
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+	}{
+		{"exact", "inception", []int{27205}},
+		{"one typo", "matrx", []int{603}},
+		{"two typos in a long word", "interstelarr", []int{157336}},
+		{"too many typos for a short word", "matirx", []int{}},
+		{"short words need to match exactly", "ip", []int{}},
+		{"prefix", "inter", []int{157336}},
+		{"two letter prefix", "in", []int{27205, 157336}},
+		{"one letter is not a prefix", "i", []int{}},
+		{"last word as prefix", "no way ho", []int{634649}},
+		{"joined pair in the query", "spiderman no way home", []int{634649}},
+		{"joined pair in the title", "spider man homecoming", []int{315635}},
+		{"year picks the version", "dune 2021", []int{438631, 841}},
+		{"other year", "dune 1984", []int{841, 438631}},
+		{"a lone year is a search word", "2021", []int{438631, 634649}},
+		{"file name terms", "bluray", []int{841, 157336, 603}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(idx.Search(tt.query, Options{}))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchTitleOnly(t *testing.T) {
+	idx := testIndex()
+	if got := idx.Search("bluray", Options{TitleOnly: true}); len(got) != 0 {
+		t.Errorf("title-only search matched file names: %v", ids(got))
+	}
+	if got := ids(idx.Search("matrix", Options{TitleOnly: true})); !slices.Equal(got, []int{603}) {
+		t.Errorf("title-only search = %v, want [603]", got)
+	}
+}
+
+func TestSearchExactTitleFirst(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(
+		file(1, 1, "Up in the Air", "Up.in.the.Air.2009.mkv"),
+		file(2, 2, "Up", "Up.2009.mkv"),
+	)
+	if got := ids(idx.Search("up", Options{})); !slices.Equal(got, []int{2, 1}) {
+		t.Errorf("Search(up) = %v, want the exact title first", got)
+	}
+}
+
+func TestSetYear(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(
+		file(1, 841, "Dune", "Dune.1080p.BluRay.mkv"),
+		file(2, 438631, "Dune", "Dune.2160p.WEB-DL.mkv"),
+	)
+
+	// A stored year boosts a title whose file names have none.
+	idx.SetYear(database.TitleKey{TMDBID: 438631, MediaType: "movie"}, 2021)
+	if got := ids(idx.Search("dune 2021", Options{})); !slices.Equal(got, []int{438631, 841}) {
+		t.Errorf("Search(dune 2021) = %v, want the 2021 title first", got)
+	}
+
+	// A stored year takes the place of the one in the file name.
+	idx.SetYear(database.TitleKey{TMDBID: 841, MediaType: "movie"}, 1984)
+	idx.Add(file(1, 841, "Dune", "Dune.2021.1080p.BluRay.mkv"))
+	if got := ids(idx.Search("dune 1984", Options{})); !slices.Equal(got, []int{841, 438631}) {
+		t.Errorf("Search(dune 1984) = %v, want the 1984 title first", got)
+	}
+}
+
+func TestAddReplaces(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(file(1, 10, "Old Name", "Old.Name.2001.mkv"))
+	idx.Add(file(1, 20, "New Name", "New.Name.2002.mkv"))
+
+	if idx.Len() != 1 {
+		t.Errorf("Len = %d after re-adding the same file, want 1", idx.Len())
+	}
+	if got := idx.Search("old", Options{}); len(got) != 0 {
+		t.Errorf("Search(old) = %v, want the replaced entry gone", ids(got))
+	}
+	if got := ids(idx.Search("new name", Options{})); !slices.Equal(got, []int{20}) {
+		t.Errorf("Search(new name) = %v, want [20]", got)
+	}
+	if idx.Has(database.TitleKey{TMDBID: 10, MediaType: "movie"}) {
+		t.Error("Has reports the title the file no longer belongs to")
+	}
+	if !idx.Has(database.TitleKey{TMDBID: 20, MediaType: "movie"}) {
+		t.Error("Has misses the title the file now belongs to")
+	}
+	if slices.Contains(idx.vocab, "old") || slices.Contains(idx.vocab, "2001") {
+		t.Errorf("vocab keeps terms of the replaced entry: %v", idx.vocab)
+	}
+}
+
+func TestSearchTitles(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(
+		file(1, 603, "The Matrix", "The.Matrix.1999.2160p.mkv"),
+		file(2, 603, "The Matrix", "The.Matrix.1999.1080p.mkv"),
+		file(3, 604, "The Matrix Reloaded", "The.Matrix.Reloaded.2003.mkv"),
+		file(4, 0, "The Matrix Fan Edit", "The.Matrix.Fan.Edit.mkv"),
+		file(5, 605, "The Matrix Revolutions", "The.Matrix.Revolutions.2003.mkv"),
+	)
+
+	if got := ids(idx.SearchTitles("matrix", Options{})); !slices.Equal(got, []int{603, 604, 605}) {
+		t.Errorf("SearchTitles = %v, want each matched title once", got)
+	}
+	if got := ids(idx.SearchTitles("matrix", Options{Limit: 2})); !slices.Equal(got, []int{603, 604}) {
+		t.Errorf("SearchTitles with limit 2 = %v, want two titles", got)
+	}
+}
+
+// TestVocabPaths checks that adding files in one large batch, which sorts
+// the vocab once, leaves the same index as adding them one at a time.
+func TestVocabPaths(t *testing.T) {
+	var files []database.MediaFile
+	for i := range 100 {
+		files = append(files, file(i, i+1, fmt.Sprintf("Title%03d Word%03d", i, 99-i), fmt.Sprintf("Title%03d.%d.mkv", i, 1900+i)))
+	}
+
+	bulk := NewIndex()
+	bulk.Add(files...)
+
+	single := NewIndex()
+	for _, f := range files {
+		single.Add(f)
+	}
+
+	if !slices.IsSorted(bulk.vocab) {
+		t.Error("bulk vocab is not sorted")
+	}
+	if !slices.Equal(bulk.vocab, single.vocab) {
+		t.Errorf("bulk vocab has %d terms, one by one %d", len(bulk.vocab), len(single.vocab))
+	}
+	for _, query := range []string{"title05", "word099", "title042 1942", "titel007"} {
+		b, s := ids(bulk.Search(query, Options{})), ids(single.Search(query, Options{}))
+		if len(b) == 0 || !slices.Equal(b, s) {
+			t.Errorf("Search(%q): bulk %v, one by one %v", query, b, s)
+		}
+	}
+}
+
+// TestVocabReplacedInBatch checks both vocab paths skip terms that a later
+// file in the same batch removed again.
+func TestVocabReplacedInBatch(t *testing.T) {
+	for _, n := range []int{1, 2 * vocabInsertLimit} {
+		t.Run(fmt.Sprintf("%d files", n+1), func(t *testing.T) {
+			files := []database.MediaFile{file(0, 1, "Alpha", "Alpha.mkv")}
+			for i := range n - 1 {
+				files = append(files, file(i+1, i+2, fmt.Sprintf("Filler%03d", i), "filler.mkv"))
+			}
+			files = append(files, file(0, 1, "Omega", "Omega.mkv"))
+
+			idx := NewIndex()
+			idx.Add(files...)
+
+			if slices.Contains(idx.vocab, "alpha") {
+				t.Error("vocab keeps a term removed later in the batch")
+			}
+			if !slices.Contains(idx.vocab, "omega") {
+				t.Error("vocab misses the replacing file's term")
+			}
+			if !slices.IsSorted(idx.vocab) {
+				t.Error("vocab is not sorted")
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Amélie":                    "amelie",
+		"Ocean's Eleven":            "oceans eleven",
+		"Fast & Furious":            "fast and furious",
+		"Spider-Man: No Way Home":   "spider man no way home",
+		"The.Matrix.1999.1080p":     "the matrix 1999 1080p",
+		"  Léon   The Professional": "leon the professional",
+	}
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
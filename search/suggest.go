@@ -0,0 +1,57 @@
+package search
+
+import (
+	"strix/database"
+)
+
+// Suggestion is a library title offered while the user is still typing.
+type Suggestion struct {
+	TMDBID     int    `json:"id"`
+	MediaType  string `json:"media_type"`
+	Title      string `json:"title"`
+	Year       int    `json:"year,omitempty"`
+	PosterPath string `json:"poster_path,omitempty"`
+	// Available is true for titles with files in the library.
+	Available bool `json:"available"`
+}
+
+// Suggest returns up to limit library titles matching the query, treating
+// the last word as a prefix. It answers from memory only.
+func (d *IndexedDB) Suggest(query string, limit int) []Suggestion {
+	files := d.index.SearchTitles(query, Options{TitleOnly: true, Limit: limit})
+
+	suggestions := make([]Suggestion, 0, len(files))
+	for _, f := range files {
+		suggestions = append(suggestions, d.suggestion(titleKey(&f), f.Title))
+	}
+	return suggestions
+}
+
+// LibrarySuggestion describes a title if it has files in the library.
+func (d *IndexedDB) LibrarySuggestion(key database.TitleKey, fallbackTitle string) (Suggestion, bool) {
+	if !d.index.Has(key) {
+		return Suggestion{}, false
+	}
+	return d.suggestion(key, fallbackTitle), true
+}
+
+// suggestion fills in stored TMDB metadata when it has been fetched.
+func (d *IndexedDB) suggestion(key database.TitleKey, fallbackTitle string) Suggestion {
+	s := Suggestion{
+		TMDBID:    key.TMDBID,
+		MediaType: key.MediaType,
+		Title:     fallbackTitle,
+		Available: true,
+	}
+
+	d.titlesMu.RLock()
+	t := d.titles[key]
+	d.titlesMu.RUnlock()
+
+	if t != nil {
+		s.Title = t.Title
+		s.Year = t.Year
+		s.PosterPath = t.PosterPath
+	}
+	return s
+}
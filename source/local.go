@@ -0,0 +1,149 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const localReadSize = 1024 * 1024
+
+// LocalDir serves media stored below a directory on disk. Paths are always
+// relative to the root and may not escape it.
+type LocalDir struct {
+	root     string
+	excluded []string
+}
+
+// NewLocalDir returns a LocalDir rooted at root. Subdirectories listed in
+// excluded (such as the chunk cache) are never served.
+func NewLocalDir(root string, excluded ...string) *LocalDir {
+	return &LocalDir{root: root, excluded: excluded}
+}
+
+// Resolve maps a path relative to the root onto disk. Symlinks are
+// followed, and paths that lead outside the root or into an excluded
+// directory are refused.
+func (d *LocalDir) Resolve(rel string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(rel))
+	clean = strings.TrimPrefix(clean, string(filepath.Separator))
+	if clean == "" || clean == "." || d.isExcluded(clean) {
+		return "", fmt.Errorf("invalid path %q", rel)
+	}
+
+	root, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, clean))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, rel)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	inside, err := filepath.Rel(root, path)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) || d.isExcluded(inside) {
+		return "", fmt.Errorf("invalid path %q", rel)
+	}
+	return path, nil
+}
+
+func (d *LocalDir) isExcluded(rel string) bool {
+	for _, ex := range d.excluded {
+		if rel == ex || strings.HasPrefix(rel, ex+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *LocalDir) Open(rel string) (MediaSource, error) {
+	path, err := d.Resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{path: path, name: filepath.Base(filepath.FromSlash(rel))}, nil
+}
+
+type localFile struct {
+	path string
+	// name is the file's name as listed, which a symlink's target may not
+	// share.
+	name string
+}
+
+func (f *localFile) Stat(ctx context.Context) (*Info, error) {
+	st, err := os.Stat(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", f.path, st.Size(), st.ModTime().UnixNano())))
+
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(f.name)))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	return &Info{
+		ETag:     `"local-` + hex.EncodeToString(sum[:8]) + `"`,
+		Size:     st.Size(),
+		FileName: f.name,
+		MimeType: mimeType,
+	}, nil
+}
+
+func (f *localFile) ReadRange(ctx context.Context, start, length int64, fn func([]byte) error) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, localReadSize)
+	remaining := length
+	for remaining > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := file.Read(buf[:min(int64(len(buf)), remaining)])
+		if n > 0 {
+			remaining -= int64(n)
+			if cbErr := fn(buf[:n]); cbErr != nil {
+				if cbErr == io.EOF {
+					return nil
+				}
+				return cbErr
+			}
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
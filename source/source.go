@@ -0,0 +1,24 @@
+package source
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotFound = errors.New("media not found")
+
+// Info describes a piece of media independently of where it is stored.
+type Info struct {
+	ETag     string
+	Size     int64
+	FileName string
+	MimeType string
+}
+
+// MediaSource is a single file that can be inspected and read in byte
+// ranges. ReadRange hands data to fn in order; fn may return io.EOF to
+// stop early without an error.
+type MediaSource interface {
+	Stat(ctx context.Context) (*Info, error)
+	ReadRange(ctx context.Context, start, length int64, fn func([]byte) error) error
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"strix/database"
+	"strix/probe"
+	"strix/source"
+	"strix/telegram"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// subtitleWait is how long a request waits for a track to be extracted
+	// before the player is asked to come back. Files without a cue index
+	// have to be read end to end, which takes far longer than that.
+	subtitleWait    = 10 * time.Second
+	subtitleRetry   = 15 * time.Second
+	subtitleTimeout = 30 * time.Minute
+
+	// Extracted tracks are removed once nobody has asked for them in
+	// subtitleTTL.
+	subtitleTTL   = 30 * 24 * time.Hour
+	subtitleSweep = 6 * time.Hour
+)
+
+// subtitleJob is a track being extracted. err is set before done is closed.
+type subtitleJob struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	// subtitleJobs holds extractions in progress by output path, so
+	// requests for the same track share one.
+	subtitleJobs   = make(map[string]*subtitleJob)
+	subtitleJobsMu sync.Mutex
+	// subtitleSlots caps how many tracks are extracted at once.
+	subtitleSlots = make(chan struct{}, 2)
+)
+
+func (s *Server) handleTracks(w http.ResponseWriter, r *http.Request) {
+	req, ok := streamRequest(w, r, "SUBS")
+	if !ok {
+		return
+	}
+
+	var m *database.MediaFile
+	var err error
+	if req.Path != "" {
+		m, err = s.db.GetLocalMedia(req.Path)
+	} else {
+		m, err = s.db.GetMediaByChatMessage(req.ChatID, req.MessageID)
+	}
+	if err != nil || m == nil {
+		http.Error(w, "No media found", http.StatusNotFound)
+		return
+	}
+
+	// Files the prober hasn't reached yet are probed now and stored.
+	if m.Probe == nil {
+		if err := telegram.ProbeMedia(m); err != nil {
+			log.Printf("[SUBS] Failed to save probe of %s: %v", m.FileName, err)
+		}
+	}
+
+	token := mux.Vars(r)["token"]
+	response := TracksResponse{
+		Audio:     []TrackInfo{},
+		Subtitles: []TrackInfo{},
+	}
+	if info := m.Probe; info != nil {
+		response.Container = info.Container
+		for _, t := range info.Audio {
+			response.Audio = append(response.Audio, trackResponse(t))
+		}
+		for _, t := range info.Subtitles {
+			track := trackResponse(t)
+			if (info.Container == "mkv" || info.Container == "webm") && probe.IsTextSubtitle(t.Codec) {
+				track.URL = fmt.Sprintf("/subtitles/%s/%d.vtt", token, t.Number)
+			}
+			response.Subtitles = append(response.Subtitles, track)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) handleSubtitles(w http.ResponseWriter, r *http.Request) {
+	req, ok := streamRequest(w, r, "SUBS")
+	if !ok {
+		return
+	}
+	track, _ := strconv.Atoi(mux.Vars(r)["track"])
+
+	src, err := s.mediaSource(req)
+	if err != nil {
+		http.Error(w, "No media found", http.StatusNotFound)
+		return
+	}
+	stat, err := src.Stat(r.Context())
+	if err != nil {
+		sourceError(w, "SUBS", err)
+		return
+	}
+
+	path := s.subtitlePath(stat.ETag, track)
+	if _, err := os.Stat(path); err != nil {
+		job := extractSubtitles(src, path, track, stat.FileName)
+		select {
+		case <-job.done:
+		case <-r.Context().Done():
+			return
+		case <-time.After(subtitleWait):
+			w.Header().Set("Retry-After", strconv.Itoa(int(subtitleRetry.Seconds())))
+			http.Error(w, "Subtitles are still being extracted, try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch {
+		case errors.Is(job.err, probe.ErrNoTrack):
+			http.Error(w, "No such subtitle track", http.StatusNotFound)
+			return
+		case errors.Is(job.err, probe.ErrUnsupported):
+			http.Error(w, "Subtitle track can't be converted", http.StatusUnsupportedMediaType)
+			return
+		case job.err != nil:
+			http.Error(w, "Failed to read subtitles", http.StatusBadGateway)
+			return
+		}
+	}
+
+	// The modification time tracks when the file was last served, for
+	// sweepSubtitles.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeFile(w, r, path)
+}
+
+func trackResponse(t database.MediaTrack) TrackInfo {
+	return TrackInfo{
+		Number:   t.Number,
+		Codec:    t.Codec,
+		Language: t.Language,
+		Title:    t.Title,
+		Channels: t.Channels,
+		Default:  t.Default,
+		Forced:   t.Forced,
+	}
+}
+
+// subtitlePath is where a file's extracted track is kept. The chunk cache
+// shares the directory but only manages its own files.
+func (s *Server) subtitlePath(etag string, track int) string {
+	sum := sha1.Sum([]byte(etag))
+	name := fmt.Sprintf("%s-%d.vtt", hex.EncodeToString(sum[:]), track)
+	return filepath.Join(s.config.FilesDir, "cache", "subtitles", name)
+}
+
+// extractSubtitles starts writing a track to path as WebVTT, or returns
+// the extraction already doing so. It carries on after the request that
+// started it has gone, so a file that has to be read end to end is ready
+// when the player asks again.
+func extractSubtitles(src source.MediaSource, path string, track int, name string) *subtitleJob {
+	subtitleJobsMu.Lock()
+	defer subtitleJobsMu.Unlock()
+
+	if job, ok := subtitleJobs[path]; ok {
+		return job
+	}
+	job := &subtitleJob{done: make(chan struct{})}
+	subtitleJobs[path] = job
+
+	go func() {
+		job.err = writeSubtitles(src, path, track)
+		if job.err != nil && !errors.Is(job.err, probe.ErrNoTrack) && !errors.Is(job.err, probe.ErrUnsupported) {
+			log.Printf("[SUBS] Failed to extract track %d of %s: %v", track, name, job.err)
+		}
+
+		subtitleJobsMu.Lock()
+		delete(subtitleJobs, path)
+		subtitleJobsMu.Unlock()
+		close(job.done)
+	}()
+	return job
+}
+
+// writeSubtitles extracts a track into path, unless an earlier job already
+// has.
+func writeSubtitles(src source.MediaSource, path string, track int) error {
+	subtitleSlots <- struct{}{}
+	defer func() { <-subtitleSlots }()
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), subtitleTimeout)
+	defer cancel()
+
+	cues, err := probe.Subtitles(ctx, src, track)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := probe.WriteVTT(f, cues); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	log.Printf("[SUBS] Extracted %d cues from track %d", len(cues), track)
+	return nil
+}
+
+// startSubtitleSweeper removes extracted tracks that haven't been served in
+// subtitleTTL, along with files left behind by interrupted extractions.
+func (s *Server) startSubtitleSweeper() {
+	go func() {
+		ticker := time.NewTicker(subtitleSweep)
+		defer ticker.Stop()
+
+		for {
+			s.sweepSubtitles()
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *Server) sweepSubtitles() {
+	dir := filepath.Join(s.config.FilesDir, "cache", "subtitles")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[SUBS] Failed to list extracted subtitles: %v", err)
+		}
+		return
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		ttl := subtitleTTL
+		switch filepath.Ext(entry.Name()) {
+		case ".vtt":
+		case ".tmp":
+			ttl = subtitleTimeout
+		default:
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < ttl {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("[SUBS] Removed %d unused subtitle files", removed)
+	}
+}
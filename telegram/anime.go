@@ -0,0 +1,173 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+const seasonLengthsTTL = time.Hour
+
+type seasonLengths struct {
+	seasons   []int
+	lengths   []int
+	fetchedAt time.Time
+}
+
+var (
+	seasonLengthsCache = make(map[int]*seasonLengths)
+	seasonLengthsMutex sync.Mutex
+)
+
+func absoluteOffsetKey(tmdbID int) string {
+	return fmt.Sprintf("absolute_offset_%d", tmdbID)
+}
+
+// absoluteOffset returns the offset an admin set for a show with /offset.
+// It is added to a file's absolute episode number before mapping, for
+// releases that count episodes differently from TMDB.
+func absoluteOffset(tmdbID int) int {
+	value, err := db.GetSetting(absoluteOffsetKey(tmdbID))
+	if err != nil {
+		log.Printf("[ANIME] Failed to load offset for %d: %v", tmdbID, err)
+		return 0
+	}
+	offset, _ := settingInt(value)
+	return offset
+}
+
+// loadSeasonLengths returns a show's regular seasons, in order, with their
+// TMDB episode counts. Specials are left out since absolute numbering
+// skips them.
+func loadSeasonLengths(tmdbID int) (*seasonLengths, error) {
+	seasonLengthsMutex.Lock()
+	cached := seasonLengthsCache[tmdbID]
+	seasonLengthsMutex.Unlock()
+	if cached != nil && time.Since(cached.fetchedAt) < seasonLengthsTTL {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	details, err := TMDB.Details(ctx, "tv", tmdbID)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &seasonLengths{fetchedAt: time.Now()}
+	for _, season := range details.Seasons {
+		if season.SeasonNumber == 0 || season.EpisodeCount == 0 {
+			continue
+		}
+		s.seasons = append(s.seasons, season.SeasonNumber)
+		s.lengths = append(s.lengths, season.EpisodeCount)
+	}
+
+	seasonLengthsMutex.Lock()
+	seasonLengthsCache[tmdbID] = s
+	seasonLengthsMutex.Unlock()
+	return s, nil
+}
+
+// locate finds the season holding absolute episode n and returns its index
+// with the episode number within it. Numbers past the last listed episode
+// fall in the latest season, which TMDB may not have fully listed yet for a
+// show still airing.
+func (s *seasonLengths) locate(n int) (int, int) {
+	for i, length := range s.lengths {
+		if n <= length || i == len(s.lengths)-1 {
+			return i, n
+		}
+		n -= length
+	}
+	return 0, 0
+}
+
+// mapAbsolute converts absolute episode numbers, counted from the start of
+// the show as anime releases do, into a season and episodes within it. A
+// range crossing into the next season is cut at the end of the first.
+func mapAbsolute(tmdbID, episode, lastEpisode int) (season, first, last int, ok bool) {
+	lengths, err := loadSeasonLengths(tmdbID)
+	if err != nil {
+		log.Printf("[ANIME] Failed to fetch seasons of %d: %v", tmdbID, err)
+		return 0, 0, 0, false
+	}
+
+	offset := absoluteOffset(tmdbID)
+	if len(lengths.seasons) == 0 || episode+offset < 1 {
+		return 0, 0, 0, false
+	}
+
+	i, first := lengths.locate(episode + offset)
+	if lastEpisode > episode {
+		j, end := lengths.locate(lastEpisode + offset)
+		if j == i {
+			last = end
+		} else {
+			last = lengths.lengths[i]
+		}
+	}
+	if last <= first {
+		last = 0
+	}
+	return lengths.seasons[i], first, last, true
+}
+
+// HandleOffset shows or sets the absolute-numbering offset of a show:
+// /offset <tmdb_id> [offset].
+func HandleOffset(m *tg.NewMessage) error {
+	if !isAuthorized(m.Sender.ID) {
+		m.Reply("⚠️ <b>Access Denied</b>\n\nYou are not authorized to use this command.")
+		return nil
+	}
+
+	args := strings.Fields(m.Args())
+	if len(args) == 0 || len(args) > 2 {
+		m.Reply("<b>Usage:</b> <code>/offset &lt;tmdb_id&gt; [offset]</code>\n\n<i>The offset is added to absolute episode numbers before they are mapped to TMDB seasons.</i>")
+		return nil
+	}
+
+	tmdbID, err := strconv.Atoi(args[0])
+	if err != nil || tmdbID <= 0 {
+		m.Reply("❌ <b>Invalid TMDB ID</b>")
+		return nil
+	}
+
+	if len(args) == 2 {
+		offset, err := strconv.Atoi(args[1])
+		if err != nil {
+			m.Reply("❌ <b>Invalid Offset</b>")
+			return nil
+		}
+		if err := db.SetSetting(absoluteOffsetKey(tmdbID), offset, m.Sender.ID); err != nil {
+			m.Reply("❌ <b>Error</b>\n\n" + err.Error())
+			return nil
+		}
+	}
+
+	lengths, err := loadSeasonLengths(tmdbID)
+	if err != nil {
+		m.Reply("❌ <b>Error</b>\n\n" + err.Error())
+		return nil
+	}
+
+	var text strings.Builder
+	text.WriteString("<b>Absolute Numbering</b>\n\n")
+	text.WriteString(fmt.Sprintf("→ <b>TMDB ID:</b> <code>%d</code>\n", tmdbID))
+	text.WriteString(fmt.Sprintf("→ <b>Offset:</b> %d\n", absoluteOffset(tmdbID)))
+
+	start := 1
+	for i, season := range lengths.seasons {
+		text.WriteString(fmt.Sprintf("→ <b>Season %d:</b> %d-%d\n", season, start, start+lengths.lengths[i]-1))
+		start += lengths.lengths[i]
+	}
+	m.Reply(text.String())
+	return nil
+}
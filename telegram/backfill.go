@@ -0,0 +1,318 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+
+	"strix/database"
+)
+
+const (
+	backfillBatchSize = 100
+	// Without an explicit to_id the backfill stops after this many batches
+	// in a row come back empty, which is taken as the end of the channel.
+	backfillEmptyBatches = 5
+)
+
+var (
+	backfillRunning = make(map[int64]bool)
+	backfillMutex   sync.Mutex
+)
+
+type backfillProgress struct {
+	FromID   int
+	ToID     int
+	Current  int
+	Scanned  int
+	Indexed  int
+	Queued   int
+	Existing int
+}
+
+func (p *backfillProgress) text(title string) string {
+	to := "end"
+	if p.ToID > 0 {
+		to = strconv.Itoa(p.ToID)
+	}
+	return fmt.Sprintf("%s\n\n→ <b>Range:</b> %d - %s\n→ <b>Position:</b> %d\n→ <b>Videos Scanned:</b> %d\n→ <b>Indexed:</b> %d\n→ <b>Queued for Review:</b> %d\n→ <b>Already Indexed:</b> %d",
+		title, p.FromID, to, p.Current, p.Scanned, p.Indexed, p.Queued, p.Existing)
+}
+
+func backfillCheckpointKey(chatID int64) string {
+	return fmt.Sprintf("index_checkpoint_%d", bareChannelID(chatID))
+}
+
+// HandleIndex backfills a channel's history: /index <channel> [from_id] [to_id].
+// Without from_id it resumes after the last checkpoint.
+func HandleIndex(m *tg.NewMessage) error {
+	if !isOwner(m.Sender.ID) {
+		m.Reply("⚠️ <b>Access Denied</b>\n\nOnly the owner can backfill channels.")
+		return nil
+	}
+
+	args := strings.Fields(m.Args())
+	if len(args) == 0 || len(args) > 3 {
+		m.Reply("<b>Usage:</b> <code>/index &lt;channel&gt; [from_id] [to_id]</code>")
+		return nil
+	}
+
+	chatID, err := resolveChannel(args[0])
+	if err != nil {
+		m.Reply("❌ <b>Invalid Channel</b>\n\n" + err.Error())
+		return nil
+	}
+	if id, ok := watchedChannel(chatID); ok {
+		chatID = id
+	}
+
+	fromID, toID := 0, 0
+	if len(args) > 1 {
+		if fromID, err = strconv.Atoi(args[1]); err != nil || fromID < 1 {
+			m.Reply("❌ <b>Invalid from_id</b>")
+			return nil
+		}
+	}
+	if len(args) > 2 {
+		if toID, err = strconv.Atoi(args[2]); err != nil || toID < fromID {
+			m.Reply("❌ <b>Invalid to_id</b>")
+			return nil
+		}
+	}
+
+	if fromID == 0 {
+		fromID = 1
+		checkpoint, err := db.GetSetting(backfillCheckpointKey(chatID))
+		if err != nil {
+			m.Reply("❌ <b>Error</b>\n\n" + err.Error())
+			return nil
+		}
+		if last, ok := settingInt(checkpoint); ok {
+			fromID = last + 1
+		}
+	}
+
+	backfillMutex.Lock()
+	if backfillRunning[chatID] {
+		backfillMutex.Unlock()
+		m.Reply("⚠️ <b>Already Running</b>\n\nA backfill for this channel is in progress.")
+		return nil
+	}
+	backfillRunning[chatID] = true
+	backfillMutex.Unlock()
+
+	defer func() {
+		backfillMutex.Lock()
+		delete(backfillRunning, chatID)
+		backfillMutex.Unlock()
+	}()
+
+	progress := &backfillProgress{FromID: fromID, ToID: toID, Current: fromID}
+	status, _ := m.Reply(progress.text("📥 <b>Indexing Channel</b>"))
+
+	err = backfillChannel(chatID, progress, func() {
+		if status != nil {
+			status.Edit(progress.text("📥 <b>Indexing Channel</b>"))
+		}
+	})
+
+	final := progress.text("✅ <b>Backfill Complete</b>")
+	if err != nil {
+		log.Printf("[INDEX] Backfill of %d stopped at %d: %v", chatID, progress.Current, err)
+		final = progress.text("❌ <b>Backfill Stopped</b>") + "\n\n" + err.Error() + "\n\n<i>Run the command again without from_id to resume.</i>"
+	} else if progress.Queued > 0 {
+		final += "\n\n<i>Use /review to resolve queued files.</i>"
+	}
+
+	if status != nil {
+		status.Edit(final)
+	} else {
+		m.Reply(final)
+	}
+	return nil
+}
+
+// resolveChannel accepts a numeric ID, @username or t.me link.
+func resolveChannel(arg string) (int64, error) {
+	arg = strings.TrimPrefix(strings.TrimPrefix(arg, "https://"), "http://")
+	arg = strings.TrimPrefix(arg, "t.me/")
+
+	var target any = arg
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		target = id
+	}
+
+	peer, err := bot.ResolvePeer(target)
+	if err != nil {
+		return 0, err
+	}
+
+	channel, ok := peer.(*tg.InputPeerChannel)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a channel", arg)
+	}
+	return channel.ChannelID, nil
+}
+
+// backfillChannel walks the channel in batches of message IDs, storing
+// confident matches in bulk and queuing the rest for review. The last
+// finished batch is checkpointed so an interrupted run can resume.
+func backfillChannel(chatID int64, progress *backfillProgress, report func()) error {
+	matches := make(map[string][]database.MatchCandidate)
+	lastReport := time.Now()
+	emptyBatches := 0
+
+	for start := progress.FromID; progress.ToID == 0 || start <= progress.ToID; start += backfillBatchSize {
+		end := start + backfillBatchSize - 1
+		if progress.ToID > 0 && end > progress.ToID {
+			end = progress.ToID
+		}
+
+		ids := make([]int32, 0, end-start+1)
+		for id := start; id <= end; id++ {
+			ids = append(ids, int32(id))
+		}
+
+		messages, err := fetchBackfillBatch(chatID, ids)
+		if err != nil {
+			return err
+		}
+
+		lastFound := 0
+		var files []indexFile
+		var names []string
+		for _, msg := range messages {
+			if _, empty := msg.OriginalUpdate.(*tg.MessageEmpty); empty || msg.Message == nil {
+				continue
+			}
+			lastFound = max(lastFound, int(msg.ID))
+
+			if msg.File == nil || msg.File.Name == "" || !IsVideoFileFunc(msg.File.Name) {
+				continue
+			}
+			progress.Scanned++
+
+			existing, err := db.GetMediaByChatMessage(chatID, int(msg.ID))
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				progress.Existing++
+				continue
+			}
+
+			files = append(files, indexFile{
+				ChatID:    chatID,
+				MessageID: int(msg.ID),
+				FileID:    msg.File.FileID,
+				FileName:  msg.File.Name,
+				FileSize:  msg.File.Size,
+			})
+			names = append(names, msg.File.Name)
+		}
+
+		// The whole batch is parsed at once so an LLM parser can read many
+		// names per request.
+		var parsed []*FileMetadata
+		if len(names) > 0 {
+			parsed = ParseFilenamesFunc(names)
+		}
+
+		var batch []database.MediaFile
+		for i, f := range files {
+			meta := parsed[i]
+			query := queryFromMetadata(meta)
+
+			key := fmt.Sprintf("%s|%d|%s", normalizeTitle(query.Title), query.Year, query.MediaType)
+			candidates, ok := matches[key]
+			if !ok {
+				var err error
+				candidates, err = matchTMDB(query)
+				if err != nil {
+					return err
+				}
+				matches[key] = candidates
+			}
+
+			media, pending := planIndexFile(f, meta, candidates)
+			if media != nil {
+				batch = append(batch, *media)
+				continue
+			}
+			if _, err := db.AddPendingMedia(pending); err != nil {
+				return err
+			}
+			progress.Queued++
+		}
+
+		if err := db.AddMediaBatch(batch); err != nil {
+			return err
+		}
+		for _, media := range batch {
+			ensureTitle(media.TMDBID, media.MediaType, media.Season)
+		}
+		if len(batch) > 0 {
+			wakeProber()
+		}
+		progress.Indexed += len(batch)
+		progress.Current = end
+
+		// Open-ended runs only checkpoint up to the newest post seen, so
+		// posts made after the run are picked up by the next one.
+		checkpoint := end
+		if progress.ToID == 0 {
+			checkpoint = lastFound
+		}
+		if checkpoint > 0 {
+			if err := db.SetSetting(backfillCheckpointKey(chatID), checkpoint, config.OwnerID); err != nil {
+				return err
+			}
+		}
+
+		if lastFound > 0 {
+			emptyBatches = 0
+		} else if emptyBatches++; progress.ToID == 0 && emptyBatches >= backfillEmptyBatches {
+			break
+		}
+
+		if time.Since(lastReport) > 3*time.Second {
+			report()
+			lastReport = time.Now()
+		}
+	}
+
+	return nil
+}
+
+func fetchBackfillBatch(chatID int64, ids []int32) ([]tg.NewMessage, error) {
+	for {
+		messages, err := bot.GetMessages(chatID, &tg.SearchOption{IDs: ids})
+		if err == nil {
+			return messages, nil
+		}
+		if !handleIfFlood(err) {
+			return nil, err
+		}
+	}
+}
+
+// settingInt reads a numeric setting; MongoDB returns int32 or int64 and
+// SQLite float64.
+func settingInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
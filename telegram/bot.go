@@ -5,25 +5,34 @@ import (
 
 	tg "github.com/amarnathcjd/gogram/telegram"
 
+	"strix/cache"
 	cfg "strix/config"
 	"strix/database"
+	"strix/source"
+	"strix/tmdb"
 )
 
 type FileMetadata struct {
-	Season  int
-	Episode int
-	Quality string
-	Title   string
-	Year    int
+	Season      int
+	Episode     int
+	LastEpisode int
+	Absolute    bool
+	Quality     string
+	Title       string
+	Year        int
+	Release     *database.ReleaseInfo
 }
 
 var bot *tg.Client
-var db *database.DB
+var db database.DB
 var config *cfg.Config
 var ParseFilenameFunc func(string) *FileMetadata
+var ParseFilenamesFunc func([]string) []*FileMetadata
 var IsVideoFileFunc func(string) bool
-var ExtractCodecFunc func(string) string
-var GeminiAPIKey string
+var ParseReleaseFunc func(string) *database.ReleaseInfo
+var ChunkCache *cache.ChunkCache
+var LocalFiles *source.LocalDir
+var TMDB *tmdb.Client
 
 // In-memory auth cache
 var (
@@ -33,10 +42,12 @@ var (
 	publicAccessMutex sync.RWMutex
 )
 
-func InitBot(c *cfg.Config, d *database.DB) error {
+func InitBot(c *cfg.Config, d database.DB) error {
 	config = c
 	db = d
 
+	loadStreamKeys()
+
 	client, err := tg.NewClient(tg.ClientConfig{
 		AppID:    int32(config.AppID),
 		AppHash:  config.AppHash,
@@ -54,13 +65,17 @@ func InitBot(c *cfg.Config, d *database.DB) error {
 	}
 
 	bot = client
+	initClientPool()
 
 	// Load auth users and settings into memory
 	if err := loadAuthCache(); err != nil {
 		return err
 	}
+	loadLegacyDeadline()
 
 	registerCommands()
+	startTitleRefresher()
+	startProber()
 
 	return nil
 }
@@ -102,8 +117,13 @@ func registerCommands() {
 	bot.On("command:removeauth", HandleRemoveAuth)
 	bot.On("command:listauth", HandleListAuth)
 	bot.On("command:setpublic", HandleSetPublic)
+	bot.On("command:review", HandleReview)
+	bot.On("command:index", HandleIndex)
+	bot.On("command:offset", HandleOffset)
 	bot.On(tg.OnCallbackQuery, HandleCallback)
 	bot.On(tg.OnNewMessage, HandleNewMessage)
+	bot.On(tg.OnNewMessage, HandleChannelPost)
+	bot.On(tg.OnDeleteMessage, HandleDeletedMessages)
 }
 
 func isOwner(userID int64) bool {
@@ -0,0 +1,321 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"strix/database"
+)
+
+// indexFile is a channel post picked up by the auto-indexer.
+type indexFile struct {
+	ChatID    int64
+	MessageID int
+	FileID    string
+	FileName  string
+	FileSize  int64
+}
+
+// HandleChannelPost indexes video files posted to INDEX_CHANNEL or one of
+// SOURCE_CHANNELS without asking anything. Files that cannot be matched
+// confidently are queued for review.
+func HandleChannelPost(m *tg.NewMessage) error {
+	if m.Message == nil || m.Message.Out || !m.IsChannel() {
+		return nil
+	}
+
+	chatID, ok := watchedChannel(m.ChatID())
+	if !ok {
+		return nil
+	}
+
+	if m.File == nil || m.File.Name == "" || !IsVideoFileFunc(m.File.Name) {
+		return nil
+	}
+
+	go func() {
+		if err := autoIndexFile(indexFile{
+			ChatID:    chatID,
+			MessageID: int(m.ID),
+			FileID:    m.File.FileID,
+			FileName:  m.File.Name,
+			FileSize:  m.File.Size,
+		}); err != nil {
+			log.Printf("[INDEX] Failed to index %d/%d: %v", chatID, m.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// watchedChannel reports whether chatID is one of the watched channels and
+// returns the ID as configured, so records match the ones /add creates.
+func watchedChannel(chatID int64) (int64, bool) {
+	channels := append([]int64{config.IndexChannel}, config.SourceChannels...)
+	for _, id := range channels {
+		if id != 0 && bareChannelID(id) == bareChannelID(chatID) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// bareChannelID strips the -100 prefix Bot API style IDs carry.
+func bareChannelID(id int64) int64 {
+	if id <= -1_000_000_000_000 {
+		return -id - 1_000_000_000_000
+	}
+	if id < 0 {
+		return -id
+	}
+	return id
+}
+
+// autoIndexFile parses, matches and stores one file, or queues it for
+// review when no candidate is confident enough.
+func autoIndexFile(f indexFile) error {
+	existing, err := db.GetMediaByChatMessage(f.ChatID, f.MessageID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+
+	meta := ParseFilenameFunc(f.FileName)
+
+	candidates, err := matchTMDB(queryFromMetadata(meta))
+	if err != nil {
+		return err
+	}
+
+	media, pending := planIndexFile(f, meta, candidates)
+	if media != nil {
+		err := db.AddMedia(media.TMDBID, media.MediaType, media.Title, media.FileID, media.MessageID, media.ChatID,
+			media.FileSize, media.FileName, media.Season, media.Episode, media.LastEpisode, media.Quality, media.CDNBotIndex, media.Release)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INDEX] %s → %s (%d)", f.FileName, media.Title, media.TMDBID)
+		ensureTitle(media.TMDBID, media.MediaType, media.Season)
+		wakeProber()
+		return nil
+	}
+
+	id, err := db.AddPendingMedia(pending)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INDEX] %s queued for review", f.FileName)
+
+	pending.ID, _ = primitive.ObjectIDFromHex(id)
+	sendReview(reviewChat(), pending)
+	return nil
+}
+
+// planIndexFile decides what to do with a matched file: it returns either
+// the record to store or the review entry to queue. A TV match also needs a
+// season to file the episode under, given by the name or mapped from
+// absolute numbering.
+func planIndexFile(f indexFile, meta *FileMetadata, candidates []database.MatchCandidate) (*database.MediaFile, *database.PendingMedia) {
+	if best, ok := confidentMatch(candidates); ok {
+		if season, episode, lastEpisode, ok := fileEpisodes(best.TMDBID, best.MediaType, meta); ok {
+			return &database.MediaFile{
+				TMDBID:      best.TMDBID,
+				MediaType:   best.MediaType,
+				Title:       best.Title,
+				FileID:      f.FileID,
+				MessageID:   f.MessageID,
+				ChatID:      f.ChatID,
+				FileSize:    f.FileSize,
+				FileName:    f.FileName,
+				Season:      season,
+				Episode:     episode,
+				LastEpisode: lastEpisode,
+				Quality:     meta.Quality,
+				Release:     meta.Release,
+			}, nil
+		}
+	}
+
+	return nil, pendingFor(f, meta, candidates)
+}
+
+// fileEpisodes works out the season and episodes a file is stored under:
+// none for a movie, every episode of the season for a season pack, and the
+// mapped season for absolute numbering. ok is false when a TV file's season
+// can't be told.
+func fileEpisodes(tmdbID int, mediaType string, meta *FileMetadata) (season, episode, lastEpisode int, ok bool) {
+	switch {
+	case mediaType != "tv":
+		return 0, 0, 0, true
+	case meta.Season > 0:
+		episode, lastEpisode = episodeRange(tmdbID, meta.Season, meta.Episode, meta.LastEpisode)
+		return meta.Season, episode, lastEpisode, true
+	case meta.Absolute:
+		return mapAbsolute(tmdbID, meta.Episode, meta.LastEpisode)
+	}
+	return 0, 0, 0, false
+}
+
+func pendingFor(f indexFile, meta *FileMetadata, candidates []database.MatchCandidate) *database.PendingMedia {
+	return &database.PendingMedia{
+		ChatID:      f.ChatID,
+		MessageID:   f.MessageID,
+		FileID:      f.FileID,
+		FileName:    f.FileName,
+		FileSize:    f.FileSize,
+		Season:      meta.Season,
+		Episode:     meta.Episode,
+		LastEpisode: meta.LastEpisode,
+		Quality:     meta.Quality,
+		Candidates:  candidates,
+	}
+}
+
+// reviewChat is where review requests go: LOG_CHANNEL, or the owner when
+// no log channel is set.
+func reviewChat() int64 {
+	if config.LogChannel != 0 {
+		return config.LogChannel
+	}
+	return config.OwnerID
+}
+
+func sendReview(chatID int64, p *database.PendingMedia) {
+	if chatID == 0 {
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("🔎 <b>Review Needed</b>\n\n")
+	text.WriteString(fmt.Sprintf("→ <b>File:</b> <code>%s</code>\n", p.FileName))
+	if p.Season > 0 {
+		text.WriteString(fmt.Sprintf("→ <b>Episode:</b> %s\n", episodeLabel(p.Season, p.Episode, p.LastEpisode)))
+	}
+	if p.Quality != "" {
+		text.WriteString(fmt.Sprintf("→ <b>Quality:</b> %s\n", p.Quality))
+	}
+	if len(p.Candidates) == 0 {
+		text.WriteString("\n<i>No TMDB matches found. Add it with /add.</i>")
+	} else {
+		text.WriteString("\n→ <b>Pick the right title:</b>")
+	}
+
+	keyboard := tg.NewKeyboard()
+	for i, c := range p.Candidates {
+		label := fmt.Sprintf("%s (%s) [%s] • %.0f%%", c.Title, c.Year, c.MediaType, c.Confidence*100)
+		keyboard.AddRow(tg.Button.Data(label, fmt.Sprintf("rv_%s_%d", p.ID.Hex(), i)))
+	}
+	keyboard.AddRow(tg.Button.Data("Skip", fmt.Sprintf("rv_%s_skip", p.ID.Hex())))
+
+	if _, err := bot.SendMessage(chatID, text.String(), &tg.SendOptions{
+		ReplyMarkup: keyboard.Build(),
+	}); err != nil {
+		log.Printf("[INDEX] Failed to send review for %s: %v", p.FileName, err)
+	}
+}
+
+// handleReviewCallback resolves a review request: rv_<id>_<candidate> files
+// the media under that candidate, rv_<id>_skip drops it.
+func handleReviewCallback(c *tg.CallbackQuery) error {
+	if !isAuthorized(c.OriginalUpdate.UserID) {
+		c.Answer("You are not authorized to review files.")
+		return nil
+	}
+
+	parts := strings.Split(c.DataString(), "_")
+	if len(parts) != 3 {
+		c.Answer("Invalid selection")
+		return nil
+	}
+
+	p, err := db.GetPendingMedia(parts[1])
+	if err != nil {
+		c.Answer("Error: " + err.Error())
+		return nil
+	}
+	if p == nil {
+		c.Answer("Already resolved")
+		c.Edit("✅ <b>Already Resolved</b>")
+		return nil
+	}
+
+	if parts[2] == "skip" {
+		if err := db.DeletePendingMedia(parts[1]); err != nil {
+			c.Answer("Error: " + err.Error())
+			return nil
+		}
+		c.Answer("Skipped")
+		c.Edit(fmt.Sprintf("⏭ <b>Skipped</b>\n\n→ <code>%s</code>", p.FileName))
+		return nil
+	}
+
+	choice, err := strconv.Atoi(parts[2])
+	if err != nil || choice < 0 || choice >= len(p.Candidates) {
+		c.Answer("Invalid selection")
+		return nil
+	}
+	selected := p.Candidates[choice]
+
+	// Queued files keep their parsed numbering, where an episode without a
+	// season is absolute.
+	season, episode, lastEpisode, ok := fileEpisodes(selected.TMDBID, selected.MediaType, &FileMetadata{
+		Season:      p.Season,
+		Episode:     p.Episode,
+		LastEpisode: p.LastEpisode,
+		Absolute:    p.Season == 0 && p.Episode > 0,
+	})
+	if !ok {
+		season, episode, lastEpisode = p.Season, p.Episode, p.LastEpisode
+	}
+
+	err = db.AddMedia(selected.TMDBID, selected.MediaType, selected.Title, p.FileID, p.MessageID, p.ChatID,
+		p.FileSize, p.FileName, season, episode, lastEpisode, p.Quality, 0, ParseReleaseFunc(p.FileName))
+	if err != nil {
+		c.Answer("Save failed: " + err.Error())
+		return nil
+	}
+	if err := db.DeletePendingMedia(parts[1]); err != nil {
+		log.Printf("[INDEX] Failed to clear review %s: %v", parts[1], err)
+	}
+	ensureTitle(selected.TMDBID, selected.MediaType, season)
+	wakeProber()
+
+	c.Answer("Indexed")
+	if selected.MediaType == "tv" {
+		c.Edit(fmt.Sprintf("✅ <b>Indexed</b>\n\n<b>%s</b>\n→ %s • <code>%s</code>", selected.Title, episodeLabel(season, episode, lastEpisode), p.Quality))
+	} else {
+		c.Edit(fmt.Sprintf("✅ <b>Indexed</b>\n\n<b>%s</b> • <code>%s</code>", selected.Title, p.Quality))
+	}
+	return nil
+}
+
+// HandleReview re-posts the oldest files waiting for review.
+func HandleReview(m *tg.NewMessage) error {
+	if !isAuthorized(m.Sender.ID) {
+		m.Reply("⚠️ <b>Access Denied</b>\n\nYou are not authorized to use this command.")
+		return nil
+	}
+
+	pending, err := db.ListPendingMedia(10)
+	if err != nil {
+		m.Reply("❌ <b>Error</b>\n\n" + err.Error())
+		return nil
+	}
+
+	if len(pending) == 0 {
+		m.Reply("✅ <b>Review Queue Empty</b>")
+		return nil
+	}
+
+	for i := range pending {
+		sendReview(m.ChatID(), &pending[i])
+	}
+	return nil
+}
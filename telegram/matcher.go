@@ -0,0 +1,247 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+
+	"strix/database"
+)
+
+// matchQuery is what a file or search is known to be. An empty MediaType
+// means unknown, e.g. a search typed by hand.
+type matchQuery struct {
+	Title     string
+	Year      int
+	MediaType string
+}
+
+func queryFromMetadata(meta *FileMetadata) matchQuery {
+	if meta == nil {
+		return matchQuery{}
+	}
+
+	mediaType := "movie"
+	if meta.Season > 0 || meta.Episode > 0 {
+		mediaType = "tv"
+	}
+	return matchQuery{Title: meta.Title, Year: meta.Year, MediaType: mediaType}
+}
+
+var trailingYearRegex = regexp.MustCompile(`^(.+?)[\s.(\[]+((?:19|20)\d{2})[)\]]?$`)
+
+// queryFromSearch splits a trailing year off a typed search like
+// "Dune 2021" or "Dune (2021)".
+func queryFromSearch(search string) matchQuery {
+	search = strings.TrimSpace(search)
+	if m := trailingYearRegex.FindStringSubmatch(search); m != nil {
+		year, _ := strconv.Atoi(m[2])
+		return matchQuery{Title: m[1], Year: year}
+	}
+	return matchQuery{Title: search}
+}
+
+// matchScore breaks a candidate's confidence into its parts, each 0 to 1.
+type matchScore struct {
+	Title float64
+	Year  float64
+	Type  float64
+}
+
+func (s matchScore) total() float64 {
+	return 0.6*s.Title + 0.25*s.Year + 0.15*s.Type
+}
+
+// scoreMatch compares a TMDB result with the query. A missing year only
+// earns partial credit, so an exact title alone is just enough to clear the
+// default threshold; an unknown media type costs nothing.
+func scoreMatch(q matchQuery, title, year, mediaType string) matchScore {
+	var s matchScore
+
+	s.Title = titleSimilarity(q.Title, title)
+
+	resultYear, err := strconv.Atoi(year)
+	switch {
+	case q.Year == 0 || err != nil:
+		s.Year = 0.4
+	case resultYear == q.Year:
+		s.Year = 1
+	case resultYear == q.Year-1 || resultYear == q.Year+1:
+		s.Year = 0.5
+	}
+
+	if q.MediaType == "" || q.MediaType == mediaType {
+		s.Type = 1
+	}
+
+	return s
+}
+
+// scoreResults scores TMDB search results against the query, best first.
+func scoreResults(q matchQuery, results []TMDBSearchResult) []database.MatchCandidate {
+	var candidates []database.MatchCandidate
+	for i, r := range results {
+		if i >= 5 {
+			break
+		}
+		candidates = append(candidates, database.MatchCandidate{
+			TMDBID:     r.ID,
+			MediaType:  r.Type,
+			Title:      r.Title,
+			Year:       r.Year,
+			Confidence: scoreMatch(q, r.Title, r.Year, r.Type).total(),
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	return candidates
+}
+
+// matchTMDB searches TMDB for the query and scores the results. A search
+// with the year that finds nothing is retried on the title alone.
+func matchTMDB(q matchQuery) ([]database.MatchCandidate, error) {
+	if q.Title == "" {
+		return nil, nil
+	}
+
+	search := q.Title
+	if q.Year > 0 {
+		search = fmt.Sprintf("%s %d", q.Title, q.Year)
+	}
+
+	results, err := searchTMDB(search)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 && q.Year > 0 {
+		results, err = searchTMDB(q.Title)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return scoreResults(q, results), nil
+}
+
+// confidentMatch returns the best candidate when it clears MATCH_CONFIDENCE
+// and is clearly ahead of the runner-up.
+func confidentMatch(candidates []database.MatchCandidate) (database.MatchCandidate, bool) {
+	if len(candidates) == 0 {
+		return database.MatchCandidate{}, false
+	}
+
+	best := candidates[0]
+	if best.Confidence < config.MatchConfidence {
+		return best, false
+	}
+	if len(candidates) > 1 && best.Confidence-candidates[1].Confidence < 0.1 {
+		return best, false
+	}
+	return best, true
+}
+
+// explainMatch describes why a candidate scored the way it did.
+func explainMatch(q matchQuery, c database.MatchCandidate) string {
+	s := scoreMatch(q, c.Title, c.Year, c.MediaType)
+
+	reasons := []string{fmt.Sprintf("title %.0f%% similar", s.Title*100)}
+
+	switch {
+	case q.Year == 0:
+		reasons = append(reasons, "no year to compare")
+	case s.Year == 1:
+		reasons = append(reasons, fmt.Sprintf("year %d matches", q.Year))
+	case s.Year == 0.5:
+		reasons = append(reasons, fmt.Sprintf("year %s is one off from %d", c.Year, q.Year))
+	default:
+		reasons = append(reasons, fmt.Sprintf("year %s differs from %d", c.Year, q.Year))
+	}
+
+	switch {
+	case q.MediaType == "":
+	case s.Type == 0:
+		reasons = append(reasons, fmt.Sprintf("expected %s", q.MediaType))
+	case q.MediaType == "tv":
+		reasons = append(reasons, "has season/episode")
+	default:
+		reasons = append(reasons, "no season/episode")
+	}
+
+	return strings.Join(reasons, ", ")
+}
+
+// autoSelectResult picks a search result without asking when the matcher is
+// confident, and tells the user what it chose and why. It returns nil when
+// the user has to choose.
+func autoSelectResult(m *tg.NewMessage, q matchQuery, results []TMDBSearchResult) *TMDBSearchResult {
+	best, ok := confidentMatch(scoreResults(q, results))
+	if !ok {
+		return nil
+	}
+
+	for i, r := range results {
+		if r.ID != best.TMDBID || r.Type != best.MediaType {
+			continue
+		}
+		m.Reply(fmt.Sprintf("🎯 <b>Auto-Matched</b> • %.0f%%\n\n<b>%s</b> (%s) • %s\n→ %s",
+			best.Confidence*100, r.Title, r.Year, r.Type, explainMatch(q, best)))
+		return &results[i]
+	}
+	return nil
+}
+
+// titleSimilarity is the edit-distance similarity of the normalized titles,
+// from 0 to 1.
+func titleSimilarity(a, b string) float64 {
+	ra, rb := []rune(normalizeTitle(a)), []rune(normalizeTitle(b))
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	longest := max(len(ra), len(rb))
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func normalizeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == '&':
+			b.WriteString(" and ")
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -1,14 +1,15 @@
 package telegram
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"strix/source"
+	"strix/tmdb"
+
 	tg "github.com/amarnathcjd/gogram/telegram"
 )
 
@@ -20,6 +21,7 @@ type MediaAddState struct {
 	PosterPath  string
 	Season      int
 	Episode     int
+	LastEpisode int
 	Quality     string
 	CDNBotIndex int
 }
@@ -41,7 +43,7 @@ func HandleAddMedia(m *tg.NewMessage) error {
 	}
 
 	if fileOrURLResp == nil {
-		fileOrURLResp, err = m.Ask("<b>Media Upload</b>\n\n→ Send video file directly, or\n→ Send Telegram post URL\n\n<b>Format:</b> <code>https://t.me/username/messageID</code>")
+		fileOrURLResp, err = m.Ask("<b>Media Upload</b>\n\n→ Send video file directly, or\n→ Send Telegram post URL, or\n→ Send a path inside FILES_DIR\n\n<b>Format:</b> <code>https://t.me/username/messageID</code> or <code>local:movies/file.mkv</code>")
 		if err != nil {
 			m.Reply("❌ <b>Error</b>\n\n" + err.Error())
 			return nil
@@ -49,6 +51,7 @@ func HandleAddMedia(m *tg.NewMessage) error {
 	}
 
 	var isForwardedFile bool
+	var localPath string
 	var parsedMetadata *FileMetadata
 	var analyzeMsg *tg.NewMessage
 	var analyzeMsgText string
@@ -69,8 +72,31 @@ func HandleAddMedia(m *tg.NewMessage) error {
 		isForwardedFile = true
 		parsedMetadata = ParseFilenameFunc(fileOrURLResp.File.Name)
 
-		analyzeMsgText = fmt.Sprintf("✅ <b>Metadata Detected</b>\n\n→ <b>Title:</b> %s\n→ <b>Season:</b> %d | <b>Episode:</b> %d\n→ <b>Quality:</b> %s",
-			parsedMetadata.Title, parsedMetadata.Season, parsedMetadata.Episode, parsedMetadata.Quality)
+		analyzeMsgText = fmt.Sprintf("✅ <b>Metadata Detected</b>\n\n→ <b>Title:</b> %s\n→ <b>Season:</b> %d | <b>Episode:</b> %s\n→ <b>Quality:</b> %s",
+			parsedMetadata.Title, parsedMetadata.Season, episodeSpan(parsedMetadata), parsedMetadata.Quality)
+
+		if analyzeMsg != nil {
+			analyzeMsg.Edit(analyzeMsgText)
+		}
+	} else if path, ok := strings.CutPrefix(strings.TrimSpace(fileOrURLResp.Text()), "local:"); ok {
+		localPath = strings.TrimSpace(path)
+
+		if !IsVideoFileFunc(localPath) {
+			m.Reply("❌ <b>Invalid File Type</b>\n\nPlease send a video file (mkv, mp4, avi, etc.).")
+			return nil
+		}
+
+		if _, err := statLocalFile(localPath); err != nil {
+			m.Reply("❌ <b>File Not Found</b>\n\n" + err.Error())
+			return nil
+		}
+
+		analyzeMsg, _ = m.Reply("🔍 <b>Analyzing file for metadata...</b>")
+
+		parsedMetadata = ParseFilenameFunc(filepath.Base(localPath))
+
+		analyzeMsgText = fmt.Sprintf("✅ <b>Metadata Detected</b>\n\n→ <b>Title:</b> %s\n→ <b>Season:</b> %d | <b>Episode:</b> %s\n→ <b>Quality:</b> %s",
+			parsedMetadata.Title, parsedMetadata.Season, episodeSpan(parsedMetadata), parsedMetadata.Quality)
 
 		if analyzeMsg != nil {
 			analyzeMsg.Edit(analyzeMsgText)
@@ -149,7 +175,17 @@ func HandleAddMedia(m *tg.NewMessage) error {
 			}
 		}
 
-		if len(results) > 0 {
+		query := queryFromSearch(searchQuery)
+		if parsedMetadata != nil {
+			query.MediaType = queryFromMetadata(parsedMetadata).MediaType
+		}
+
+		if auto := autoSelectResult(m, query, results); auto != nil {
+			tmdbID = auto.ID
+			mediaType = auto.Type
+			title = auto.Title
+			posterPath = auto.PosterPath
+		} else if len(results) > 0 {
 			var resultMsg strings.Builder
 			resultMsg.WriteString("<b>Search Results</b>\n\n")
 			keyboard := tg.NewKeyboard()
@@ -197,10 +233,7 @@ func HandleAddMedia(m *tg.NewMessage) error {
 	}
 
 	if mediaType == "tv" {
-		if parsedMetadata != nil && parsedMetadata.Season > 0 {
-			state.Season = parsedMetadata.Season
-			state.Episode = parsedMetadata.Episode
-		} else {
+		if !state.useParsedEpisodes(parsedMetadata) {
 			seasonResp, err := m.Ask(fmt.Sprintf("<b>%s</b>\n<i>TV Series</i>\n\n→ Enter season number:", title))
 			if err != nil {
 				m.Reply("❌ <b>Error</b>\n\n" + err.Error())
@@ -209,13 +242,13 @@ func HandleAddMedia(m *tg.NewMessage) error {
 			season, _ := strconv.Atoi(seasonResp.Text())
 			state.Season = season
 
-			episodeResp, err := m.Ask("→ Enter episode number:")
+			episodeResp, err := m.Ask("→ Enter episode number\n\n<i>Use a range like <code>1-3</code> for a multi-episode file, or <code>0</code> for a season pack.</i>")
 			if err != nil {
 				m.Reply("❌ <b>Error</b>\n\n" + err.Error())
 				return nil
 			}
-			episode, _ := strconv.Atoi(episodeResp.Text())
-			state.Episode = episode
+			episode, lastEpisode, _ := parseEpisodeRange(episodeResp.Text())
+			state.Episode, state.LastEpisode = episodeRange(state.TMDBID, season, episode, lastEpisode)
 		}
 	}
 
@@ -233,6 +266,8 @@ func HandleAddMedia(m *tg.NewMessage) error {
 	var saveErr error
 	if isForwardedFile {
 		saveErr = saveMediaFromForwardedFile(fileOrURLResp, state)
+	} else if localPath != "" {
+		saveErr = saveMediaFromLocal(localPath, state)
 	} else {
 		saveErr = saveMediaFromURL(fileOrURLResp.Text(), state)
 	}
@@ -248,13 +283,13 @@ func HandleAddMedia(m *tg.NewMessage) error {
 	} else {
 		var successMsg string
 		if state.MediaType == "tv" {
-			successMsg = fmt.Sprintf("✅ <b>Media Added Successfully</b>\n\n<b>%s</b>\n→ S%02dE%02d • <code>%s</code>", state.Title, state.Season, state.Episode, state.Quality)
+			successMsg = fmt.Sprintf("✅ <b>Media Added Successfully</b>\n\n<b>%s</b>\n→ %s • <code>%s</code>", state.Title, episodeLabel(state.Season, state.Episode, state.LastEpisode), state.Quality)
 		} else {
 			successMsg = fmt.Sprintf("✅ <b>Media Added Successfully</b>\n\n<b>%s</b> • <code>%s</code>", state.Title, state.Quality)
 		}
 
 		if state.PosterPath != "" {
-			posterURL := fmt.Sprintf("https://image.tmdb.org/t/p/w500%s", state.PosterPath)
+			posterURL := tmdb.ImageURL(state.PosterPath, "w500")
 			if _, err := m.ReplyMedia(posterURL, tg.MediaOptions{
 				Caption: successMsg,
 			}); err != nil {
@@ -327,7 +362,12 @@ func HandleAddMulti(m *tg.NewMessage) error {
 			}
 		}
 
-		if len(results) > 0 {
+		if auto := autoSelectResult(m, queryFromSearch(searchQuery), results); auto != nil {
+			tmdbID = auto.ID
+			mediaType = auto.Type
+			title = auto.Title
+			posterPath = auto.PosterPath
+		} else if len(results) > 0 {
 			var resultMsg strings.Builder
 			resultMsg.WriteString("<b>Search Results</b>\n\n")
 			keyboard := tg.NewKeyboard()
@@ -412,7 +452,7 @@ func HandleAddMulti(m *tg.NewMessage) error {
 			parsedMetadata = ParseFilenameFunc(fileMsg.File.Name)
 
 			if parsedMetadata.Season > 0 || parsedMetadata.Episode > 0 || parsedMetadata.Quality != "" {
-				m.Reply(fmt.Sprintf("✅ <b>Detected:</b> S%dE%d • <code>%s</code> • <b>%s</b>", parsedMetadata.Season, parsedMetadata.Episode, parsedMetadata.Quality, parsedMetadata.Title))
+				m.Reply(fmt.Sprintf("✅ <b>Detected:</b> Season %d | Episode %s • <code>%s</code> • <b>%s</b>", parsedMetadata.Season, episodeSpan(parsedMetadata), parsedMetadata.Quality, parsedMetadata.Title))
 			} else {
 				m.Reply(fmt.Sprintf("✅ <b>Detected:</b> <b>%s</b> • <code>%s</code>", parsedMetadata.Title, parsedMetadata.Quality))
 			}
@@ -426,11 +466,8 @@ func HandleAddMulti(m *tg.NewMessage) error {
 		}
 
 		if mediaType == "tv" {
-			if parsedMetadata != nil && parsedMetadata.Season > 0 {
-				state.Season = parsedMetadata.Season
-				state.Episode = parsedMetadata.Episode
-			} else {
-				seasonEpResp, err := m.Ask("→ Enter season and episode\n\n<b>Example:</b> <code>1 5</code> for S01E05")
+			if !state.useParsedEpisodes(parsedMetadata) {
+				seasonEpResp, err := m.Ask("→ Enter season and episode\n\n<b>Example:</b> <code>1 5</code> for S01E05, <code>1 5-7</code> for S01E05-E07, <code>1 0</code> for a season pack")
 				if err != nil {
 					m.Reply("❌ <b>Error</b>\n\n" + err.Error())
 					continue
@@ -447,14 +484,14 @@ func HandleAddMulti(m *tg.NewMessage) error {
 					m.Reply("❌ <b>Invalid Season Number</b>")
 					continue
 				}
-				episode, err := strconv.Atoi(parts[1])
+				episode, lastEpisode, err := parseEpisodeRange(parts[1])
 				if err != nil {
 					m.Reply("❌ <b>Invalid Episode Number</b>")
 					continue
 				}
 
 				state.Season = season
-				state.Episode = episode
+				state.Episode, state.LastEpisode = episodeRange(state.TMDBID, season, episode, lastEpisode)
 			}
 		}
 
@@ -480,7 +517,7 @@ func HandleAddMulti(m *tg.NewMessage) error {
 			m.Reply("❌ <b>Save Failed</b>\n\n" + saveErr.Error())
 		} else {
 			if state.MediaType == "tv" {
-				m.Reply(fmt.Sprintf("✅ <b>Added</b>\n\n→ S%02dE%02d • <code>%s</code>", state.Season, state.Episode, state.Quality))
+				m.Reply(fmt.Sprintf("✅ <b>Added</b>\n\n→ %s • <code>%s</code>", episodeLabel(state.Season, state.Episode, state.LastEpisode), state.Quality))
 			} else {
 				m.Reply(fmt.Sprintf("✅ <b>Added</b>\n\n→ <code>%s</code>", state.Quality))
 			}
@@ -540,83 +577,131 @@ func saveMediaFromURL(url string, state *MediaAddState) error {
 		fi.File.Name,
 		state.Season,
 		state.Episode,
+		state.LastEpisode,
 		state.Quality,
 		state.CDNBotIndex,
+		ParseReleaseFunc(fi.File.Name),
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	ensureTitle(state.TMDBID, state.MediaType, state.Season)
+	wakeProber()
+	return nil
 }
 
-type TMDBSearchResult struct {
-	ID         int
-	Title      string
-	Year       string
-	Type       string
-	PosterPath string
+// useParsedEpisodes files the media under the episodes parsed from its
+// name, mapping absolute numbering to a season, and reports whether the
+// name gave enough to do so.
+func (s *MediaAddState) useParsedEpisodes(meta *FileMetadata) bool {
+	if meta == nil {
+		return false
+	}
+	season, episode, lastEpisode, ok := fileEpisodes(s.TMDBID, s.MediaType, meta)
+	if ok {
+		s.Season, s.Episode, s.LastEpisode = season, episode, lastEpisode
+	}
+	return ok
 }
 
-func searchTMDB(query string) ([]TMDBSearchResult, error) {
-	url := fmt.Sprintf("https://api.themoviedb.org/3/search/multi?api_key=%s&query=%s",
-		config.TMDBAPIKey, url.QueryEscape(query))
+// parseEpisodeRange reads an episode number, or a range like "5-7" for a
+// file holding several episodes.
+func parseEpisodeRange(text string) (int, int, error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(text), "-")
+
+	episode, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil || !isRange {
+		return episode, 0, err
+	}
 
-	resp, err := http.Get(url)
+	lastEpisode, err := strconv.Atoi(strings.TrimSpace(last))
 	if err != nil {
-		return nil, err
+		return 0, 0, err
+	}
+	if lastEpisode < episode {
+		return 0, 0, fmt.Errorf("episode range %d-%d ends before it starts", episode, lastEpisode)
+	}
+	return episode, lastEpisode, nil
+}
+
+// episodeSpan formats the parsed episode numbers of a file.
+func episodeSpan(meta *FileMetadata) string {
+	if meta.Season > 0 && meta.Episode == 0 {
+		return "full season"
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	span := strconv.Itoa(meta.Episode)
+	if meta.LastEpisode > meta.Episode {
+		span = fmt.Sprintf("%d-%d", meta.Episode, meta.LastEpisode)
+	}
+	if meta.Absolute {
+		span += " (absolute)"
+	}
+	return span
+}
+
+func statLocalFile(path string) (*source.Info, error) {
+	src, err := LocalFiles.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	return src.Stat(context.Background())
+}
+
+func saveMediaFromLocal(path string, state *MediaAddState) error {
+	info, err := statLocalFile(path)
+	if err != nil {
+		return err
+	}
 
-	var result struct {
-		Results []struct {
-			ID           int    `json:"id"`
-			Title        string `json:"title"`
-			Name         string `json:"name"`
-			MediaType    string `json:"media_type"`
-			PosterPath   string `json:"poster_path"`
-			ReleaseDate  string `json:"release_date"`
-			FirstAirDate string `json:"first_air_date"`
-		} `json:"results"`
+	err = db.AddLocalMedia(
+		state.TMDBID,
+		state.MediaType,
+		state.Title,
+		path,
+		info.Size,
+		info.FileName,
+		state.Season,
+		state.Episode,
+		state.LastEpisode,
+		state.Quality,
+		ParseReleaseFunc(info.FileName),
+	)
+	if err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(body, &result); err != nil {
+	ensureTitle(state.TMDBID, state.MediaType, state.Season)
+	wakeProber()
+	return nil
+}
+
+type TMDBSearchResult struct {
+	ID         int
+	Title      string
+	Year       string
+	Type       string
+	PosterPath string
+}
+
+func searchTMDB(query string) ([]TMDBSearchResult, error) {
+	resp, err := TMDB.SearchMulti(context.Background(), query)
+	if err != nil {
 		return nil, err
 	}
 
 	var results []TMDBSearchResult
-	for _, r := range result.Results {
+	for _, r := range resp.Results {
 		if r.MediaType != "movie" && r.MediaType != "tv" {
 			continue
 		}
 
-		title := r.Title
-		if title == "" {
-			title = r.Name
-		}
-
-		year := r.ReleaseDate
-		if year == "" {
-			year = r.FirstAirDate
-		}
-		if len(year) >= 4 {
-			year = year[:4]
-		}
-
-		mediaType := r.MediaType
-		if mediaType == "movie" {
-			mediaType = "movie"
-		} else {
-			mediaType = "tv"
-		}
-
 		results = append(results, TMDBSearchResult{
 			ID:         r.ID,
-			Title:      title,
-			Year:       year,
-			Type:       mediaType,
+			Title:      r.DisplayTitle(),
+			Year:       r.Year(),
+			Type:       r.MediaType,
 			PosterPath: r.PosterPath,
 		})
 	}
@@ -653,14 +738,14 @@ func saveMediaFromForwardedFile(msg *tg.NewMessage, state *MediaAddState) error
 	if fileName != "" && state.Season == 0 && state.MediaType == "tv" {
 		parsed := ParseFilenameFunc(fileName)
 		if parsed.Season > 0 {
-			msg.Reply(fmt.Sprintf("💡 <b>Detected:</b> S%02dE%02d", parsed.Season, parsed.Episode))
+			msg.Reply(fmt.Sprintf("💡 <b>Detected:</b> %s", episodeLabel(parsed.Season, parsed.Episode, parsed.LastEpisode)))
 		}
 	}
 
 	err := db.AddMedia(
 		state.TMDBID,
 		state.MediaType,
-		fmt.Sprintf("%s [%s]", state.Title, state.Quality),
+		state.Title,
 		fileID,
 		targetMsgID,
 		targetChatID,
@@ -668,51 +753,32 @@ func saveMediaFromForwardedFile(msg *tg.NewMessage, state *MediaAddState) error
 		fileName,
 		state.Season,
 		state.Episode,
+		state.LastEpisode,
 		state.Quality,
 		state.CDNBotIndex,
+		ParseReleaseFunc(fileName),
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	ensureTitle(state.TMDBID, state.MediaType, state.Season)
+	wakeProber()
+	return nil
 }
 
 func getTMDBFromIMDB(imdbID string) (int, string, string, string, error) {
-	url := fmt.Sprintf("https://api.themoviedb.org/3/find/%s?api_key=%s&external_source=imdb_id",
-		imdbID, config.TMDBAPIKey)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return 0, "", "", "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	resp, err := TMDB.FindByIMDb(context.Background(), imdbID)
 	if err != nil {
 		return 0, "", "", "", err
 	}
 
-	var result struct {
-		MovieResults []struct {
-			ID         int    `json:"id"`
-			Title      string `json:"title"`
-			PosterPath string `json:"poster_path"`
-		} `json:"movie_results"`
-		TVResults []struct {
-			ID         int    `json:"id"`
-			Name       string `json:"name"`
-			PosterPath string `json:"poster_path"`
-		} `json:"tv_results"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return 0, "", "", "", err
-	}
-
-	if len(result.MovieResults) > 0 {
-		return result.MovieResults[0].ID, "movie", result.MovieResults[0].Title, result.MovieResults[0].PosterPath, nil
+	if len(resp.MovieResults) > 0 {
+		return resp.MovieResults[0].ID, "movie", resp.MovieResults[0].DisplayTitle(), resp.MovieResults[0].PosterPath, nil
 	}
 
-	if len(result.TVResults) > 0 {
-		return result.TVResults[0].ID, "tv", result.TVResults[0].Name, result.TVResults[0].PosterPath, nil
+	if len(resp.TVResults) > 0 {
+		return resp.TVResults[0].ID, "tv", resp.TVResults[0].DisplayTitle(), resp.TVResults[0].PosterPath, nil
 	}
 
 	return 0, "", "", "", fmt.Errorf("no results found for IMDb ID: %s", imdbID)
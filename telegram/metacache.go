@@ -0,0 +1,191 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// documentInfo is everything the streaming path needs to know about a
+// message's document. The file reference is only valid for the client that
+// fetched it, so the client is cached alongside.
+type documentInfo struct {
+	client        *poolClient
+	DocID         int64
+	AccessHash    int64
+	FileReference []byte
+	DcID          int
+	Size          int64
+	FileName      string
+	MimeType      string
+	fetchedAt     time.Time
+}
+
+var (
+	documentCache      = make(map[string]*documentInfo)
+	documentCacheMutex sync.RWMutex
+)
+
+func init() {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			cleanExpiredDocuments()
+			cleanPreferredBots()
+		}
+	}()
+}
+
+// documentKey normalises the bare and -100 prefixed forms of a channel ID
+// so both map to the same entry.
+func documentKey(chatID int64, messageID int) string {
+	if chatID < -1000000000000 {
+		chatID = -chatID - 1000000000000
+	}
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+func documentTTL() time.Duration {
+	if config == nil || config.MetadataCacheTTL <= 0 {
+		return 30 * time.Minute
+	}
+	return config.MetadataCacheTTL
+}
+
+// resolveDocument returns cached document info for a message, fetching it
+// through the client pool on a miss. Entries owned by an excluded client
+// are treated as misses.
+func resolveDocument(ctx context.Context, chatID int64, messageID int, exclude ...*poolClient) (*documentInfo, error) {
+	key := documentKey(chatID, messageID)
+
+	documentCacheMutex.RLock()
+	info, ok := documentCache[key]
+	documentCacheMutex.RUnlock()
+
+	if ok && time.Since(info.fetchedAt) < documentTTL() &&
+		!slices.Contains(exclude, info.client) && info.client.available(time.Now()) {
+		return info, nil
+	}
+
+	client, message, err := fetchMessage(ctx, chatID, messageID, exclude...)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err = newDocumentInfo(client, message, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	documentCacheMutex.Lock()
+	documentCache[key] = info
+	documentCacheMutex.Unlock()
+
+	return info, nil
+}
+
+func newDocumentInfo(client *poolClient, message *tg.NewMessage, chatID int64, messageID int) (*documentInfo, error) {
+	doc := message.Document()
+	if doc == nil || doc.Size == 0 {
+		return nil, fmt.Errorf("no media found in message")
+	}
+
+	info := &documentInfo{
+		client:        client,
+		DocID:         doc.ID,
+		AccessHash:    doc.AccessHash,
+		FileReference: doc.FileReference,
+		DcID:          int(doc.DcID),
+		Size:          doc.Size,
+		MimeType:      doc.MimeType,
+		fetchedAt:     time.Now(),
+	}
+
+	for _, attr := range doc.Attributes {
+		if fileNameAttr, ok := attr.(*tg.DocumentAttributeFilename); ok {
+			info.FileName = fileNameAttr.FileName
+			break
+		}
+	}
+
+	if info.FileName == "" {
+		info.FileName = fmt.Sprintf("file_%d_%d", chatID, messageID)
+	}
+
+	if info.MimeType == "" {
+		info.MimeType = "application/octet-stream"
+	}
+
+	return info, nil
+}
+
+// refreshDocument replaces the cached entry by fetching the message again on
+// the same client, for when Telegram rejects the cached file reference.
+func refreshDocument(chatID int64, messageID int, client *poolClient) (*documentInfo, error) {
+	message, err := client.client.GetMessageByID(chatID, int32(messageID))
+	if err != nil {
+		InvalidateDocument(chatID, messageID)
+		return nil, err
+	}
+
+	info, err := newDocumentInfo(client, message, chatID, messageID)
+	if err != nil {
+		InvalidateDocument(chatID, messageID)
+		return nil, err
+	}
+
+	documentCacheMutex.Lock()
+	documentCache[documentKey(chatID, messageID)] = info
+	documentCacheMutex.Unlock()
+
+	return info, nil
+}
+
+// InvalidateDocument forgets cached metadata for a message, e.g. after the
+// media was deleted or replaced.
+func InvalidateDocument(chatID int64, messageID int) {
+	documentCacheMutex.Lock()
+	delete(documentCache, documentKey(chatID, messageID))
+	documentCacheMutex.Unlock()
+
+	preferredBotCache.Delete(fmt.Sprintf("%d:%d", chatID, messageID))
+}
+
+func cleanExpiredDocuments() {
+	documentCacheMutex.Lock()
+	defer documentCacheMutex.Unlock()
+
+	ttl := documentTTL()
+	for key, info := range documentCache {
+		if time.Since(info.fetchedAt) > ttl {
+			delete(documentCache, key)
+		}
+	}
+}
+
+// HandleDeletedMessages drops cached metadata and chunks for media whose
+// messages were deleted from a channel.
+func HandleDeletedMessages(m *tg.DeleteMessage) error {
+	for _, id := range m.Messages {
+		key := documentKey(m.ChannelID, int(id))
+
+		documentCacheMutex.Lock()
+		info, ok := documentCache[key]
+		delete(documentCache, key)
+		documentCacheMutex.Unlock()
+
+		if ok && ChunkCache != nil {
+			ChunkCache.Invalidate(info.DocID)
+		}
+		if ok {
+			log.Printf("[CACHE] Dropped metadata for deleted message %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amarnathcjd/gogram"
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+const (
+	maxClientFailures = 3
+	clientCooldown    = 30 * time.Second
+	// maxPoolWait is how long a request waits for a client to come out of
+	// its cooldown before giving up.
+	maxPoolWait = 5 * time.Second
+)
+
+// BusyError is returned when every client is cooling down after a flood
+// wait or repeated failures.
+type BusyError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("all clients are cooling down, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// poolClient is one bot account able to serve file downloads. Index 0 is
+// the main bot, index n is the nth configured CDN_BOT.
+type poolClient struct {
+	index  int
+	client *tg.Client
+
+	inflight atomic.Int64
+	served   atomic.Int64
+
+	mu         sync.Mutex
+	failures   int
+	floodUntil time.Time
+	downUntil  time.Time
+
+	sendersMu sync.RWMutex
+	senders   map[int]*gogram.MTProto
+}
+
+var (
+	clientPool []*poolClient
+	poolCursor atomic.Uint64
+)
+
+func initClientPool() {
+	clientPool = []*poolClient{newPoolClient(0, bot)}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i, token := range config.CDNBots {
+		wg.Add(1)
+		go func(index int, token string) {
+			defer wg.Done()
+
+			client, err := tg.NewClient(tg.ClientConfig{
+				AppID:     int32(config.AppID),
+				AppHash:   config.AppHash,
+				Session:   fmt.Sprintf("cdn_%d.session", index),
+				Cache:     tg.NewCache(fmt.Sprintf("strix_cdn_%d.db", index)),
+				LogLevel:  tg.LogInfo,
+				NoUpdates: true,
+			})
+			if err != nil {
+				log.Printf("[POOL] CDN bot %d: %v", index, err)
+				return
+			}
+
+			client.Conn()
+			if err := client.LoginBot(token); err != nil {
+				log.Printf("[POOL] CDN bot %d login failed: %v", index, err)
+				return
+			}
+
+			mu.Lock()
+			clientPool = append(clientPool, newPoolClient(index, client))
+			mu.Unlock()
+		}(i+1, token)
+	}
+
+	wg.Wait()
+
+	sort.Slice(clientPool, func(i, j int) bool {
+		return clientPool[i].index < clientPool[j].index
+	})
+
+	log.Printf("[POOL] %d client(s) ready for streaming", len(clientPool))
+}
+
+func newPoolClient(index int, client *tg.Client) *poolClient {
+	return &poolClient{
+		index:   index,
+		client:  client,
+		senders: make(map[int]*gogram.MTProto),
+	}
+}
+
+func (p *poolClient) available(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return now.After(p.floodUntil) && now.After(p.downUntil)
+}
+
+func (p *poolClient) readyAt() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.floodUntil.After(p.downUntil) {
+		return p.floodUntil
+	}
+	return p.downUntil
+}
+
+func (p *poolClient) markFlood(seconds int) {
+	p.mu.Lock()
+	p.floodUntil = time.Now().Add(time.Duration(seconds) * time.Second)
+	p.mu.Unlock()
+	log.Printf("[POOL] client %d flood-waited for %ds", p.index, seconds)
+}
+
+func (p *poolClient) markFailure() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures++
+	if p.failures >= maxClientFailures {
+		p.downUntil = time.Now().Add(clientCooldown)
+		p.failures = 0
+		log.Printf("[POOL] client %d marked unhealthy for %s", p.index, clientCooldown)
+	}
+}
+
+func (p *poolClient) markSuccess() {
+	p.mu.Lock()
+	p.failures = 0
+	p.mu.Unlock()
+}
+
+// handleError records a failed request and reports whether the caller
+// should move to another client.
+func (p *poolClient) handleError(err error) bool {
+	if tg.MatchError(err, "FLOOD_WAIT_") || tg.MatchError(err, "FLOOD_PREMIUM_WAIT_") {
+		if wait := tg.GetFloodWait(err); wait > 0 {
+			p.markFlood(wait)
+			return true
+		}
+	}
+	p.markFailure()
+	return !p.available(time.Now())
+}
+
+func (p *poolClient) sender(dcID int) (*gogram.MTProto, error) {
+	if dcID == p.client.GetDC() {
+		return p.client.MTProto, nil
+	}
+
+	p.sendersMu.RLock()
+	cached, exists := p.senders[dcID]
+	p.sendersMu.RUnlock()
+	if exists {
+		return cached, nil
+	}
+
+	p.sendersMu.Lock()
+	defer p.sendersMu.Unlock()
+
+	if cached, exists := p.senders[dcID]; exists {
+		return cached, nil
+	}
+
+	exported, err := p.client.CreateExportedSender(dcID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exported sender: %w", err)
+	}
+	p.senders[dcID] = exported
+	return exported, nil
+}
+
+func (p *poolClient) acquire() {
+	p.inflight.Add(1)
+	p.served.Add(1)
+}
+
+func (p *poolClient) release() {
+	p.inflight.Add(-1)
+}
+
+// pickClient selects a client for a download. A preferred CDN bot index is
+// honoured while that bot is healthy; otherwise the pool strategy decides.
+// Clients in exclude are skipped unless nothing else is left. When every
+// client is cooling down it waits for the first to recover, up to
+// maxPoolWait, and returns a BusyError after that.
+func pickClient(ctx context.Context, preferred int, exclude ...*poolClient) (*poolClient, error) {
+	now := time.Now()
+
+	candidates := make([]*poolClient, 0, len(clientPool))
+	for _, p := range clientPool {
+		excluded := false
+		for _, e := range exclude {
+			if p == e {
+				excluded = true
+				break
+			}
+		}
+		if !excluded && p.available(now) {
+			candidates = append(candidates, p)
+		}
+	}
+
+	if len(candidates) == 0 {
+		var best *poolClient
+		for _, p := range clientPool {
+			if best == nil || p.readyAt().Before(best.readyAt()) {
+				best = p
+			}
+		}
+		wait := time.Until(best.readyAt())
+		if wait > maxPoolWait {
+			return nil, &BusyError{RetryAfter: wait}
+		}
+		if wait > 0 {
+			if err := sleepCtx(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+		return best, nil
+	}
+
+	if preferred > 0 {
+		for _, p := range candidates {
+			if p.index == preferred {
+				return p, nil
+			}
+		}
+	}
+
+	if config.CDNStrategy == "round-robin" {
+		return candidates[poolCursor.Add(1)%uint64(len(candidates))], nil
+	}
+
+	best := candidates[0]
+	for _, p := range candidates[1:] {
+		if p.inflight.Load() < best.inflight.Load() {
+			best = p
+		}
+	}
+	return best, nil
+}
+
+// preferredBot is a message's CDN bot as stored in the library, cached for
+// as long as its document metadata.
+type preferredBot struct {
+	index    int
+	cachedAt time.Time
+}
+
+var preferredBotCache sync.Map
+
+func preferredBotIndex(chatID int64, messageID int) int {
+	key := fmt.Sprintf("%d:%d", chatID, messageID)
+	if cached, ok := preferredBotCache.Load(key); ok && time.Since(cached.(preferredBot).cachedAt) < documentTTL() {
+		return cached.(preferredBot).index
+	}
+
+	idx := 0
+	if media, err := db.GetMediaByChatMessage(chatID, messageID); err == nil && media != nil {
+		idx = media.CDNBotIndex
+	}
+	preferredBotCache.Store(key, preferredBot{index: idx, cachedAt: time.Now()})
+	return idx
+}
+
+func cleanPreferredBots() {
+	ttl := documentTTL()
+	preferredBotCache.Range(func(key, value any) bool {
+		if time.Since(value.(preferredBot).cachedAt) > ttl {
+			preferredBotCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// fetchMessage loads a message through the pool, failing over to other
+// clients when one is flood-waited or cannot see the chat.
+func fetchMessage(ctx context.Context, chatID int64, messageID int, exclude ...*poolClient) (*poolClient, *tg.NewMessage, error) {
+	preferred := preferredBotIndex(chatID, messageID)
+	tried := append([]*poolClient{}, exclude...)
+
+	var lastErr error
+	for range clientPool {
+		p, err := pickClient(ctx, preferred, tried...)
+		if err != nil {
+			return nil, nil, err
+		}
+		message, err := p.client.GetMessageByID(chatID, int32(messageID))
+		if err == nil {
+			p.markSuccess()
+			return p, message, nil
+		}
+		lastErr = err
+		p.handleError(err)
+		tried = append(tried, p)
+	}
+
+	return nil, nil, fmt.Errorf("failed to get message: %w", lastErr)
+}
+
+func poolStatus() string {
+	var b strings.Builder
+	now := time.Now()
+	for _, p := range clientPool {
+		name := "Main bot"
+		if p.index > 0 {
+			name = fmt.Sprintf("CDN bot %d", p.index)
+		}
+		state := "healthy"
+		if !p.available(now) {
+			state = fmt.Sprintf("cooling down %s", time.Until(p.readyAt()).Round(time.Second))
+		}
+		b.WriteString(fmt.Sprintf("→ %s: <code>%s</code> • active <code>%d</code> • served <code>%d</code>\n",
+			name, state, p.inflight.Load(), p.served.Load()))
+	}
+	return b.String()
+}
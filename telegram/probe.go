@@ -0,0 +1,288 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"strix/database"
+	"strix/probe"
+	"strix/source"
+)
+
+const (
+	probeBatch    = 50
+	probeInterval = 10 * time.Minute
+	probeTimeout  = 2 * time.Minute
+
+	// A file whose reads keep failing is tried this many times, waiting
+	// twice as long after each failure.
+	probeAttempts   = 5
+	probeRetryDelay = time.Hour
+)
+
+var probeWake = make(chan struct{}, 1)
+
+// startProber reads the container headers of files added to the library,
+// so their quality and tracks come from the file rather than its name.
+// Files indexed before probing existed are worked through a batch at a
+// time.
+func startProber() {
+	if !config.ProbeMedia {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(probeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-probeWake:
+			}
+			for probePending() == probeBatch {
+			}
+		}
+	}()
+}
+
+// wakeProber has newly added files probed now instead of at the next tick.
+func wakeProber() {
+	select {
+	case probeWake <- struct{}{}:
+	default:
+	}
+}
+
+// probePending probes one batch of files and returns how many results,
+// successful or not, were saved.
+func probePending() int {
+	files, err := db.GetUnprobedMedia(probeBatch)
+	if err != nil {
+		log.Printf("[PROBE] Failed to list files to probe: %v", err)
+		return 0
+	}
+
+	saved := 0
+	for i := range files {
+		if err := ProbeMedia(&files[i]); err != nil {
+			log.Printf("[PROBE] Failed to save probe of %s: %v", files[i].FileName, err)
+			continue
+		}
+		saved++
+	}
+
+	if len(files) > 0 {
+		log.Printf("[PROBE] Probed %d/%d files", saved, len(files))
+	}
+	return saved
+}
+
+// ProbeMedia probes a file and stores the result, failed or not, in
+// m.Probe as well as the database. The error is only for failing to save.
+func ProbeMedia(m *database.MediaFile) error {
+	err := probeFile(m)
+	if err != nil {
+		log.Printf("[PROBE] Failed to probe %s: %v", m.FileName, err)
+		err = saveProbeFailure(m, err)
+	}
+	return err
+}
+
+// probeFile reads a file's headers and stores what they say. Files that
+// aren't MKV or MP4, or are gone, are stored with the error so they aren't
+// tried again; read failures are returned for saveProbeFailure.
+func probeFile(m *database.MediaFile) error {
+	var src source.MediaSource
+	if m.Source == database.SourceLocal {
+		if LocalFiles == nil {
+			return fmt.Errorf("local files are not configured")
+		}
+		local, err := LocalFiles.Open(m.FilePath)
+		if err != nil {
+			return err
+		}
+		src = local
+	} else {
+		src = NewSource(m.ChatID, m.MessageID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	info, err := probe.Probe(ctx, src)
+	if err != nil && !errors.Is(err, probe.ErrUnsupported) && !errors.Is(err, source.ErrNotFound) {
+		return err
+	}
+
+	var quality string
+	var p *database.MediaProbe
+	if err != nil {
+		p = &database.MediaProbe{Error: err.Error(), ProbedAt: time.Now()}
+	} else {
+		quality, p = info.Resolution(), mediaProbe(info)
+	}
+	if err := db.SetMediaProbe(m.ID.Hex(), quality, p); err != nil {
+		return err
+	}
+	m.Probe = p
+	return nil
+}
+
+// saveProbeFailure stores a failed read so the file makes way for others
+// until its retry is due. After the last attempt the error stays for good.
+func saveProbeFailure(m *database.MediaFile, err error) error {
+	failed := &database.MediaProbe{Error: err.Error(), Attempts: 1, ProbedAt: time.Now()}
+	if m.Probe != nil {
+		failed.Attempts = m.Probe.Attempts + 1
+	}
+	if failed.Attempts < probeAttempts {
+		failed.RetryAt = time.Now().Add(probeRetryDelay << (failed.Attempts - 1)).Unix()
+	}
+	if err := db.SetMediaProbe(m.ID.Hex(), "", failed); err != nil {
+		return err
+	}
+	m.Probe = failed
+	return nil
+}
+
+func mediaProbe(info *probe.Info) *database.MediaProbe {
+	p := &database.MediaProbe{
+		Container:  info.Container,
+		Width:      info.Width,
+		Height:     info.Height,
+		Duration:   int(info.Duration.Seconds()),
+		VideoCodec: info.VideoCodec,
+		HDR:        info.HDR,
+		ProbedAt:   time.Now(),
+	}
+	for _, t := range info.Audio {
+		p.Audio = append(p.Audio, database.MediaTrack(t))
+	}
+	for _, t := range info.Subtitles {
+		p.Subtitles = append(p.Subtitles, database.MediaTrack(t))
+	}
+	return p
+}
+
+// channelLayout writes a channel count the way release names do: 6 is
+// "5.1", 8 is "7.1".
+func channelLayout(channels int) string {
+	switch {
+	case channels <= 0:
+		return ""
+	case channels >= 6:
+		return fmt.Sprintf("%d.1", channels-1)
+	}
+	return fmt.Sprintf("%d.0", channels)
+}
+
+// versionLabel describes a file on a quality button. Probed files show
+// their codec and HDR format; others only show the codec from the release
+// name, and only when needed to tell apart versions of the same quality.
+func versionLabel(m *database.MediaFile, needCodec bool) string {
+	parts := []string{m.Quality}
+	if p := m.Probe; p != nil && p.Error == "" {
+		parts = append(parts, p.VideoCodec)
+		if p.HDR != "" {
+			parts = append(parts, p.HDR)
+		}
+		if len(p.Audio) > 1 {
+			parts = append(parts, fmt.Sprintf("%d audio", len(p.Audio)))
+		}
+	} else if needCodec {
+		if codec := m.Release.Codec(); codec != "" {
+			parts = append(parts, codec)
+		}
+	}
+	return fmt.Sprintf("%s (%.2f GB)", strings.Join(parts, " "), float64(m.FileSize)/(1024*1024*1024))
+}
+
+// fileDetails lists a file's release tags and probed streams for its
+// detail message.
+func fileDetails(m *database.MediaFile) string {
+	var text strings.Builder
+	if tags := releaseTags(m.Release); len(tags) > 0 {
+		text.WriteString(fmt.Sprintf("→ <b>Release:</b> %s\n", strings.Join(tags, " • ")))
+	}
+
+	p := m.Probe
+	if p == nil || p.Error != "" {
+		if text.Len() > 0 {
+			text.WriteString("\n")
+		}
+		return text.String()
+	}
+
+	video := []string{fmt.Sprintf("%dx%d", p.Width, p.Height), p.VideoCodec}
+	if p.HDR != "" {
+		video = append(video, p.HDR)
+	}
+	if p.Duration > 0 {
+		video = append(video, formatDuration(p.Duration))
+	}
+	text.WriteString(fmt.Sprintf("→ <b>Video:</b> %s\n", strings.Join(video, " • ")))
+
+	if len(p.Audio) > 0 {
+		var tracks []string
+		for _, t := range p.Audio {
+			tracks = append(tracks, strings.TrimSpace(fmt.Sprintf("%s %s %s", trackLanguage(t), t.Codec, channelLayout(t.Channels))))
+		}
+		text.WriteString(fmt.Sprintf("→ <b>Audio:</b> %s\n", strings.Join(tracks, ", ")))
+	}
+
+	if len(p.Subtitles) > 0 {
+		var tracks []string
+		for _, t := range p.Subtitles {
+			tracks = append(tracks, trackLanguage(t))
+		}
+		text.WriteString(fmt.Sprintf("→ <b>Subtitles:</b> %s\n", strings.Join(tracks, ", ")))
+	}
+
+	text.WriteString("\n")
+	return text.String()
+}
+
+// releaseTags lists what the release name says, in the order release
+// names usually give it.
+func releaseTags(r *database.ReleaseInfo) []string {
+	if r == nil {
+		return nil
+	}
+
+	var tags []string
+	add := func(tag string) {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	add(strings.TrimSpace(r.Service + " " + r.Source))
+	add(r.Codec())
+	add(strings.Join(r.HDR, ", "))
+	add(strings.TrimSpace(r.AudioCodec + " " + r.AudioChannels))
+	add(strings.Join(r.Languages, ", "))
+	if r.MultiAudio {
+		add("Multi audio")
+	}
+	add(r.Edition)
+	add(r.Group)
+	return tags
+}
+
+func trackLanguage(t database.MediaTrack) string {
+	if t.Language == "" {
+		return "und"
+	}
+	return t.Language
+}
+
+func formatDuration(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%dh %dm", seconds/3600, seconds%3600/60)
+	}
+	return fmt.Sprintf("%dm", seconds/60)
+}
@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"strix/source"
+)
+
+type telegramSource struct {
+	chatID    int64
+	messageID int
+}
+
+// NewSource returns a MediaSource reading the document attached to a
+// Telegram message.
+func NewSource(chatID int64, messageID int) source.MediaSource {
+	return &telegramSource{chatID: chatID, messageID: messageID}
+}
+
+func (t *telegramSource) Stat(ctx context.Context) (*source.Info, error) {
+	info, err := GetMediaInfo(ctx, t.chatID, t.messageID)
+	if err != nil {
+		// A busy pool or a cancelled request says nothing about whether
+		// the message exists.
+		var busy *BusyError
+		if errors.As(err, &busy) || ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", source.ErrNotFound, err)
+	}
+
+	return &source.Info{
+		ETag:     fmt.Sprintf(`"tg-%d"`, info.DocID),
+		Size:     info.Size,
+		FileName: info.FileName,
+		MimeType: info.MimeType,
+	}, nil
+}
+
+func (t *telegramSource) ReadRange(ctx context.Context, start, length int64, fn func([]byte) error) error {
+	startChunk := start / ChunkSize
+	endChunk := (start + length - 1) / ChunkSize
+	skip := start % ChunkSize
+	remaining := length
+	stopped := false
+
+	err := StreamMediaChunks(ctx, t.chatID, t.messageID, startChunk, endChunk, func(chunk []byte) error {
+		if skip > 0 {
+			if int64(len(chunk)) <= skip {
+				skip -= int64(len(chunk))
+				return nil
+			}
+			chunk = chunk[skip:]
+			skip = 0
+		}
+
+		if int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		remaining -= int64(len(chunk))
+		if err := fn(chunk); err != nil {
+			stopped = err == io.EOF
+			return err
+		}
+
+		if remaining <= 0 {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if remaining > 0 && !stopped {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
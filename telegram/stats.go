@@ -1,17 +1,18 @@
 package telegram
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"strix/database"
+	"strix/tmdb"
+
 	tg "github.com/amarnathcjd/gogram/telegram"
 )
 
@@ -91,6 +92,19 @@ func HandleStats(m *tg.NewMessage) error {
 		stats.FreeSpaceMB,
 	)
 
+	message += "\n\n<b>Streaming Pool:</b>\n" + poolStatus()
+
+	if ChunkCache != nil {
+		cs := ChunkCache.Stats()
+		message += fmt.Sprintf(
+			"\n<b>Chunk Cache:</b>\n"+
+				"→ Hits: <code>%d</code> • Misses: <code>%d</code> (<code>%.1f%%</code>)\n"+
+				"→ Chunks: <code>%d</code>\n"+
+				"→ Size: <code>%.1f / %.0f MB</code>",
+			cs.Hits, cs.Misses, cs.HitRatio, cs.Entries, cs.SizeMB, cs.MaxMB,
+		)
+	}
+
 	m.Reply(message)
 	return nil
 }
@@ -133,14 +147,14 @@ func HandleSearch(m *tg.NewMessage) error {
 		if media.MediaType == "movie" {
 			response.WriteString(fmt.Sprintf("<b>%s</b> [Movie]\n", media.Title))
 		} else {
-			response.WriteString(fmt.Sprintf("<b>%s</b> [S%02dE%02d]\n", media.Title, media.Season, media.Episode))
+			response.WriteString(fmt.Sprintf("<b>%s</b> [%s]\n", media.Title, episodeLabel(media.Season, media.Episode, media.LastEpisode)))
 		}
 
 		response.WriteString(fmt.Sprintf("   → Quality: <code>%s</code>\n", media.Quality))
 		response.WriteString(fmt.Sprintf("   → Size: <code>%.2f GB</code>\n", float64(media.FileSize)/(1024*1024*1024)))
 		response.WriteString(fmt.Sprintf("   → File: <code>%s</code>\n", media.FileName))
 
-		token := GenerateStreamToken(media.ChatID, media.MessageID)
+		token := MediaStreamToken(&media, m.Sender.ID)
 		streamURL := fmt.Sprintf("%s/play?token=%s&file=%s", config.BaseURL, token, media.FileName)
 		response.WriteString(fmt.Sprintf("   → Stream: %s\n\n", streamURL))
 	}
@@ -173,6 +187,7 @@ func HandleSearchByTitle(m *tg.NewMessage) error {
 	}
 
 	titleMap := make(map[string]*titleInfo)
+	titlesList := []*titleInfo{}
 
 	for _, media := range results {
 		key := fmt.Sprintf("%s_%s_%d", media.Title, media.MediaType, media.TMDBID)
@@ -185,6 +200,7 @@ func HandleSearchByTitle(m *tg.NewMessage) error {
 				Qualities: make(map[string]bool),
 				Files:     []string{},
 			}
+			titlesList = append(titlesList, titleMap[key])
 		}
 
 		info := titleMap[key]
@@ -210,10 +226,6 @@ func HandleSearchByTitle(m *tg.NewMessage) error {
 	response.WriteString(fmt.Sprintf("Found <b>%d</b> title(s). Select one to view details:\n", len(titleMap)))
 
 	count := 0
-	titlesList := make([]*titleInfo, 0, len(titleMap))
-	for _, info := range titleMap {
-		titlesList = append(titlesList, info)
-	}
 
 	for i := 0; i < len(titlesList) && i < 10; i++ {
 		info := titlesList[i]
@@ -258,6 +270,11 @@ func HandleCallback(c *tg.CallbackQuery) error {
 	data := c.DataString()
 	msgID := int(c.MessageID)
 	senderID := c.OriginalUpdate.UserID
+
+	if strings.HasPrefix(data, "rv_") {
+		return handleReviewCallback(c)
+	}
+
 	searchContextMapMutex.RLock()
 	ctx, hasContext := searchContextMap[msgID]
 	searchContextMapMutex.RUnlock()
@@ -304,8 +321,10 @@ func HandleCallback(c *tg.CallbackQuery) error {
 				if seasonMap[media.Season] == nil {
 					seasonMap[media.Season] = []int{}
 				}
-				if !slices.Contains(seasonMap[media.Season], media.Episode) {
-					seasonMap[media.Season] = append(seasonMap[media.Season], media.Episode)
+				for _, episode := range media.Episodes() {
+					if !slices.Contains(seasonMap[media.Season], episode) {
+						seasonMap[media.Season] = append(seasonMap[media.Season], episode)
+					}
 				}
 			}
 
@@ -331,6 +350,8 @@ func HandleCallback(c *tg.CallbackQuery) error {
 			c.Edit(response.String(), opts)
 		} else {
 
+			database.SortVersions(results)
+
 			qualityMap := make(map[string]int)
 			for _, media := range results {
 				qualityMap[media.Quality]++
@@ -339,21 +360,8 @@ func HandleCallback(c *tg.CallbackQuery) error {
 			keyboard := tg.NewKeyboard()
 
 			for _, media := range results {
-				hasDuplicates := qualityMap[media.Quality] > 1
-
-				var buttonText string
-				if hasDuplicates {
-					codec := ExtractCodecFunc(media.FileName)
-					if codec != "" {
-						buttonText = fmt.Sprintf("%s %s (%.2f GB)", media.Quality, codec, float64(media.FileSize)/(1024*1024*1024))
-					} else {
-						buttonText = fmt.Sprintf("%s (%.2f GB)", media.Quality, float64(media.FileSize)/(1024*1024*1024))
-					}
-				} else {
-					buttonText = fmt.Sprintf("%s (%.2f GB)", media.Quality, float64(media.FileSize)/(1024*1024*1024))
-				}
-
-				callbackData := fmt.Sprintf("movie_%d_%s", tmdbID, media.Quality)
+				buttonText := versionLabel(&media, qualityMap[media.Quality] > 1)
+				callbackData := fmt.Sprintf("movie_%s", media.ID.Hex())
 				keyboard.AddRow(tg.Button.Data(buttonText, callbackData))
 			}
 
@@ -396,30 +404,25 @@ func HandleCallback(c *tg.CallbackQuery) error {
 		response.WriteString(fmt.Sprintf("<b>%s - Season %d</b>\n\n", episodes[0].Title, season))
 		response.WriteString("Select episode:\n")
 
+		// A file holding several episodes gets one button covering its
+		// whole range.
 		episodeQualityMap := make(map[string]int)
 		for _, ep := range episodes {
-			key := fmt.Sprintf("%d_%s", ep.Episode, ep.Quality)
+			key := fmt.Sprintf("%d_%d_%s", ep.Episode, ep.LastEpisode, ep.Quality)
 			episodeQualityMap[key]++
 		}
 
 		keyboard := tg.NewKeyboard()
 		for _, ep := range episodes {
-			key := fmt.Sprintf("%d_%s", ep.Episode, ep.Quality)
-			hasDuplicates := episodeQualityMap[key] > 1
-
-			var buttonText string
-			if hasDuplicates {
-				codec := ExtractCodecFunc(ep.FileName)
-				if codec != "" {
-					buttonText = fmt.Sprintf("E%02d - %s %s (%.2f GB)", ep.Episode, ep.Quality, codec, float64(ep.FileSize)/(1024*1024*1024))
-				} else {
-					buttonText = fmt.Sprintf("E%02d - %s (%.2f GB)", ep.Episode, ep.Quality, float64(ep.FileSize)/(1024*1024*1024))
-				}
-			} else {
-				buttonText = fmt.Sprintf("E%02d - %s (%.2f GB)", ep.Episode, ep.Quality, float64(ep.FileSize)/(1024*1024*1024))
+			key := fmt.Sprintf("%d_%d_%s", ep.Episode, ep.LastEpisode, ep.Quality)
+
+			label := fmt.Sprintf("E%02d", ep.Episode)
+			if ep.LastEpisode > ep.Episode {
+				label = fmt.Sprintf("E%02d-E%02d", ep.Episode, ep.LastEpisode)
 			}
+			buttonText := fmt.Sprintf("%s - %s", label, versionLabel(&ep, episodeQualityMap[key] > 1))
 
-			callbackData := fmt.Sprintf("ep_%d_%d", ep.ChatID, ep.MessageID)
+			callbackData := fmt.Sprintf("ep_%s", ep.ID.Hex())
 			keyboard.AddRow(tg.Button.Data(buttonText, callbackData))
 		}
 
@@ -433,31 +436,23 @@ func HandleCallback(c *tg.CallbackQuery) error {
 	}
 
 	if strings.HasPrefix(data, "ep_") {
-		parts := strings.Split(data, "_")
-		if len(parts) != 3 {
-			c.Answer("Invalid selection")
-			return nil
-		}
-
-		chatID, _ := strconv.ParseInt(parts[1], 10, 64)
-		messageID, _ := strconv.Atoi(parts[2])
-
-		media, err := db.GetMediaByChatMessage(chatID, messageID)
+		media, err := db.GetMediaByID(strings.TrimPrefix(data, "ep_"))
 		if err != nil || media == nil {
 			c.Answer("File not found")
 			return nil
 		}
 
-		token := GenerateStreamToken(media.ChatID, media.MessageID)
+		token := MediaStreamToken(media, senderID)
 		streamURL := fmt.Sprintf("%s/play?token=%s", config.BaseURL, token)
 		var response strings.Builder
 		response.WriteString(fmt.Sprintf("<b>%s</b>\n\n", media.Title))
-		response.WriteString(fmt.Sprintf("S%02dE%02d • <code>%s</code> • <code>%.2f GB</code>\n\n", media.Season, media.Episode, media.Quality, float64(media.FileSize)/(1024*1024*1024)))
+		response.WriteString(fmt.Sprintf("%s • <code>%s</code> • <code>%.2f GB</code>\n\n", episodeLabel(media.Season, media.Episode, media.LastEpisode), media.Quality, float64(media.FileSize)/(1024*1024*1024)))
+		response.WriteString(fileDetails(media))
 		response.WriteString(fmt.Sprintf("<b>File:</b> <code>%s</code>", media.FileName))
 
 		keyboard := tg.NewKeyboard()
 		keyboard.AddRow(tg.Button.URL("Stream File", streamURL))
-		keyboard.AddRow(tg.Button.Data("Get File", fmt.Sprintf("filedata_%d_%d", chatID, messageID)))
+		keyboard.AddRow(tg.Button.Data("Get File", fmt.Sprintf("filedata_%s", media.ID.Hex())))
 		keyboard.AddRow(tg.Button.Data("« Back to Episodes", fmt.Sprintf("season_%d_%d_tv", media.TMDBID, media.Season)))
 
 		c.Edit(response.String(), &tg.SendOptions{
@@ -467,33 +462,25 @@ func HandleCallback(c *tg.CallbackQuery) error {
 	}
 
 	if strings.HasPrefix(data, "movie_") {
-		parts := strings.Split(data, "_")
-		if len(parts) < 3 {
-			c.Answer("Invalid selection")
-			return nil
-		}
-
-		tmdbID, _ := strconv.Atoi(parts[1])
-		quality := strings.Join(parts[2:], "_")
-
-		media, err := db.GetMediaByQuality(tmdbID, "movie", 0, 0, quality)
+		media, err := db.GetMediaByID(strings.TrimPrefix(data, "movie_"))
 		if err != nil || media == nil {
 			c.Answer("File not found")
 			return nil
 		}
 
-		token := GenerateStreamToken(media.ChatID, media.MessageID)
+		token := MediaStreamToken(media, senderID)
 		streamURL := fmt.Sprintf("%s/play?token=%s&file=%s", config.BaseURL, token, media.FileName)
 
 		var response strings.Builder
 		response.WriteString(fmt.Sprintf("<b>%s</b>\n\n", media.Title))
-		response.WriteString(fmt.Sprintf("<code>%s</code> • <code>%.2f GB</code>\n\n", quality, float64(media.FileSize)/(1024*1024*1024)))
+		response.WriteString(fmt.Sprintf("<code>%s</code> • <code>%.2f GB</code>\n\n", media.Quality, float64(media.FileSize)/(1024*1024*1024)))
+		response.WriteString(fileDetails(media))
 		response.WriteString(fmt.Sprintf("<b>File:</b> <code>%s</code>", media.FileName))
 
 		keyboard := tg.NewKeyboard()
 		keyboard.AddRow(tg.Button.URL("Stream File", streamURL))
-		keyboard.AddRow(tg.Button.Data("Get File", fmt.Sprintf("filedata_%d_movie_%s", tmdbID, quality)))
-		keyboard.AddRow(tg.Button.Data("« Back to Qualities", fmt.Sprintf("title_%d_movie", tmdbID)))
+		keyboard.AddRow(tg.Button.Data("Get File", fmt.Sprintf("filedata_%s", media.ID.Hex())))
+		keyboard.AddRow(tg.Button.Data("« Back to Qualities", fmt.Sprintf("title_%d_movie", media.TMDBID)))
 
 		c.Edit(response.String(), &tg.SendOptions{
 			ReplyMarkup: keyboard.Build(),
@@ -566,39 +553,19 @@ func HandleNewMessage(m *tg.NewMessage) error {
 	return nil
 }
 
+// getTMDBPoster prefers the stored title metadata and only asks TMDB for
+// titles that have not been fetched yet.
 func getTMDBPoster(tmdbID int, mediaType string) string {
-	endpoint := "movie"
-	if mediaType == "tv" {
-		endpoint = "tv"
+	if title, err := db.GetTitle(tmdbID, mediaType); err == nil && title != nil {
+		return tmdb.ImageURL(title.PosterPath, "w500")
 	}
+	ensureTitle(tmdbID, mediaType, 0)
 
-	url := fmt.Sprintf("https://api.themoviedb.org/3/%s/%d?api_key=%s", endpoint, tmdbID, config.TMDBAPIKey)
-
-	resp, err := http.Get(url)
+	details, err := TMDB.Details(context.Background(), mediaType, tmdbID)
 	if err != nil {
 		log.Printf("[TMDB] Error fetching poster: %v", err)
 		return ""
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[TMDB] Error reading response: %v", err)
-		return ""
-	}
-
-	var result struct {
-		PosterPath string `json:"poster_path"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		log.Printf("[TMDB] Error parsing JSON: %v", err)
-		return ""
-	}
-
-	if result.PosterPath != "" {
-		return "https://image.tmdb.org/t/p/w500" + result.PosterPath
-	}
 
-	return ""
+	return tmdb.ImageURL(details.PosterPath, "w500")
 }
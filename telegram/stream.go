@@ -2,12 +2,9 @@ package telegram
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"io"
-	"math/rand"
-	"strconv"
-	"strings"
+	"log"
 	"sync"
 	"time"
 
@@ -15,247 +12,345 @@ import (
 	tg "github.com/amarnathcjd/gogram/telegram"
 )
 
-var (
-	exportedSenderCache = make(map[int]*gogram.MTProto)
-	exportedSenderMutex sync.RWMutex
-)
-
-type StreamRequest struct {
-	ChatID    int64
-	MessageID int
-	Start     int64
-	End       int64
-}
-
-func ParseStreamToken(token string) (*StreamRequest, error) {
-	decoded, err := base64.URLEncoding.DecodeString(token)
+func GetFileInfo(ctx context.Context, chatID int64, messageID int) (int64, string, error) {
+	info, err := resolveDocument(ctx, chatID, messageID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid token")
+		return 0, "", err
 	}
 
-	parts := strings.Split(string(decoded), ":")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid token format")
+	mimeType := info.MimeType
+	if mimeType == "application/octet-stream" {
+		mimeType = "video/mp4"
 	}
 
-	chatID, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid chat ID")
-	}
+	return info.Size, mimeType, nil
+}
+
+type MediaInfo struct {
+	DocID    int64
+	Size     int64
+	FileName string
+	MimeType string
+}
 
-	messageID, err := strconv.Atoi(parts[1])
+func GetMediaInfo(ctx context.Context, chatID int64, messageID int) (*MediaInfo, error) {
+	info, err := resolveDocument(ctx, chatID, messageID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid message ID")
+		return nil, err
 	}
 
-	return &StreamRequest{
-		ChatID:    chatID,
-		MessageID: messageID,
+	return &MediaInfo{
+		DocID:    info.DocID,
+		Size:     info.Size,
+		FileName: info.FileName,
+		MimeType: info.MimeType,
 	}, nil
 }
 
-func GenerateStreamToken(chatID int64, messageID int) string {
-	data := fmt.Sprintf("%d:%d", chatID, messageID)
-	return base64.URLEncoding.EncodeToString([]byte(data))
+type streamSession struct {
+	docID     int64
+	client    *poolClient
+	location  *tg.InputDocumentFileLocation
+	requester *gogram.MTProto
+	fileSize  int64
 }
 
-func getRandomBot() *tg.Client {
-	if len(config.CDNBots) == 0 {
-		return bot
-	}
-	idx := rand.Intn(len(config.CDNBots))
-	if idx == 0 || config.CDNBots[idx] == "" {
-		return bot
+func openStreamSession(ctx context.Context, chatID int64, messageID int, exclude ...*poolClient) (*streamSession, error) {
+	info, err := resolveDocument(ctx, chatID, messageID, exclude...)
+	if err != nil {
+		return nil, err
 	}
-	return bot
+	return newStreamSession(info)
 }
 
-func GetFileInfo(chatID int64, messageID int) (int64, string, error) {
-	client := getRandomBot()
-	message, err := client.GetMessageByID(chatID, int32(messageID))
+func newStreamSession(info *documentInfo) (*streamSession, error) {
+	requester, err := info.client.sender(info.DcID)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to get message: %w", err)
+		info.client.markFailure()
+		return nil, err
 	}
 
-	doc := message.Document()
-	if doc == nil {
-		return 0, "", fmt.Errorf("message has no document")
-	}
+	return &streamSession{
+		docID:  info.DocID,
+		client: info.client,
+		location: &tg.InputDocumentFileLocation{
+			ID:            info.DocID,
+			AccessHash:    info.AccessHash,
+			FileReference: info.FileReference,
+			ThumbSize:     "",
+		},
+		requester: requester,
+		fileSize:  info.Size,
+	}, nil
+}
 
-	mimeType := doc.MimeType
-	if mimeType == "" {
-		mimeType = "video/mp4"
-	}
+// ChunkSize is the size of a single upload.getFile part. StreamMediaChunks
+// delivers parts of exactly this size except for the last one.
+const ChunkSize = int64(1024 * 1024)
 
-	return doc.Size, mimeType, nil
+type chunkResult struct {
+	data []byte
+	err  error
 }
 
-type MediaInfo struct {
-	Size     int64
-	FileName string
-	MimeType string
-}
+// chunkStreamer shares one stream session between the read-ahead workers
+// and swaps it out when the current client has to be abandoned.
+type chunkStreamer struct {
+	chatID    int64
+	messageID int
 
-func GetMediaInfo(chatID int64, messageID int) (*MediaInfo, error) {
-	client := getRandomBot()
-	message, err := client.GetMessageByID(chatID, int32(messageID))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get message: %w", err)
-	}
+	mu      sync.Mutex
+	session *streamSession
+}
 
-	var fileSize int64
-	var fileName string
-	var mimeType string
+func (s *chunkStreamer) current() *streamSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session
+}
 
-	doc := message.Document()
-	if doc != nil {
-		fileSize = doc.Size
-		mimeType = doc.MimeType
+// failover replaces failed with a session on another client. If a
+// concurrent worker already replaced it, the newer session is kept.
+func (s *chunkStreamer) failover(ctx context.Context, failed *streamSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		for _, attr := range doc.Attributes {
-			if fileNameAttr, ok := attr.(*tg.DocumentAttributeFilename); ok {
-				fileName = fileNameAttr.FileName
-				break
-			}
-		}
+	if s.session != failed {
+		return nil
 	}
 
-	if fileSize == 0 {
-		return nil, fmt.Errorf("no media found in message")
+	next, err := openStreamSession(ctx, s.chatID, s.messageID, failed.client)
+	if err != nil {
+		return err
 	}
+	failed.client.release()
+	next.client.acquire()
+	s.session = next
+	return nil
+}
 
-	if fileName == "" {
-		fileName = fmt.Sprintf("file_%d_%d", chatID, messageID)
-	}
+// refreshReference re-fetches the message behind the stream to replace an
+// expired file reference, keeping the same client and offset.
+func (s *chunkStreamer) refreshReference(stale *streamSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	if s.session != stale {
+		return nil
 	}
 
-	return &MediaInfo{
-		Size:     fileSize,
-		FileName: fileName,
-		MimeType: mimeType,
-	}, nil
-}
-
-func StreamMediaChunks(chatID int64, messageID int, startChunk int64, callback func([]byte) error) error {
-	client := getRandomBot()
-	var requester = client.MTProto
-
-	message, err := client.GetMessageByID(chatID, int32(messageID))
+	info, err := refreshDocument(s.chatID, s.messageID, stale.client)
 	if err != nil {
-		return fmt.Errorf("failed to get message: %w", err)
+		return err
 	}
 
-	doc := message.Document()
-	if doc == nil {
-		return fmt.Errorf("message has no document")
+	if info.DocID != stale.docID {
+		return fmt.Errorf("media was removed or replaced")
 	}
 
-	location := &tg.InputDocumentFileLocation{
-		ID:            doc.ID,
-		AccessHash:    doc.AccessHash,
-		FileReference: doc.FileReference,
+	refreshed := *stale
+	refreshed.location = &tg.InputDocumentFileLocation{
+		ID:            info.DocID,
+		AccessHash:    info.AccessHash,
+		FileReference: info.FileReference,
 		ThumbSize:     "",
 	}
+	s.session = &refreshed
 
-	if doc.DcID != int32(client.GetDC()) {
-		dcID := int(doc.DcID)
+	log.Printf("[STREAM] Refreshed file reference for %d:%d", s.chatID, s.messageID)
+	return nil
+}
 
-		exportedSenderMutex.RLock()
-		cached, exists := exportedSenderCache[dcID]
-		exportedSenderMutex.RUnlock()
+func isFileReferenceError(err error) bool {
+	return tg.MatchError(err, "FILE_REFERENCE_EXPIRED") || tg.MatchError(err, "FILE_REFERENCE_INVALID")
+}
 
-		if exists {
-			requester = cached
-		} else {
-			exported, err := client.CreateExportedSender(dcID, false)
-			if err != nil {
-				return fmt.Errorf("failed to create exported sender: %w", err)
-			}
-			requester = exported
+func (s *chunkStreamer) close() {
+	s.mu.Lock()
+	s.session.client.release()
+	s.mu.Unlock()
+}
 
-			exportedSenderMutex.Lock()
-			exportedSenderCache[dcID] = exported
-			exportedSenderMutex.Unlock()
+// fetchPart serves a part from the chunk cache when possible and falls
+// back to Telegram, caching whatever it downloads.
+func (s *chunkStreamer) fetchPart(ctx context.Context, part int64) ([]byte, error) {
+	docID := s.current().docID
+	if ChunkCache != nil {
+		if data, ok := ChunkCache.Get(docID, part); ok {
+			return data, nil
 		}
 	}
 
-	chunkSize := int64(1024 * 1024)
-	offset := startChunk * chunkSize
-	fileSize := doc.Size
-	maxRetries := 5
-	currentRetries := 0
-
-	for offset < fileSize {
-		limit := chunkSize
-		remaining := fileSize - offset
+	data, err := s.downloadPart(ctx, part)
+	if err != nil {
+		return nil, err
+	}
 
-		if remaining < limit {
-			limit = remaining
+	if ChunkCache != nil && len(data) > 0 {
+		if err := ChunkCache.Put(docID, part, data); err != nil {
+			log.Printf("[CACHE] Failed to store part %d of %d: %v", part, docID, err)
 		}
+	}
+	return data, nil
+}
 
-		alignedOffset := (offset / 1024) * 1024
-		alignedLimit := max(min(((limit+1023)/1024)*1024, 1048576), 1024)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-
-		result, err := requester.MakeRequestCtx(ctx, &tg.UploadGetFileParams{
-			Location:     location,
-			Offset:       alignedOffset,
-			Limit:        int32(alignedLimit),
+func (s *chunkStreamer) downloadPart(ctx context.Context, part int64) ([]byte, error) {
+	offset := part * ChunkSize
+	maxRetries := 5
+	currentRetries := 0
+	maxRefreshes := 3
+	refreshes := 0
+
+	for {
+		session := s.current()
+		limit := min(ChunkSize, session.fileSize-offset)
+
+		reqCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		result, err := session.requester.MakeRequestCtx(reqCtx, &tg.UploadGetFileParams{
+			Location:     session.location,
+			Offset:       offset,
+			Limit:        int32(ChunkSize),
 			Precise:      true,
 			CdnSupported: false,
 		})
-
 		cancel()
 
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if err != nil && isFileReferenceError(err) {
+			refreshes++
+			if refreshes > maxRefreshes {
+				return nil, fmt.Errorf("file reference still expired after %d refreshes: %w", maxRefreshes, err)
+			}
+			if rerr := s.refreshReference(session); rerr != nil {
+				return nil, fmt.Errorf("failed to refresh file reference: %w", rerr)
+			}
+			continue
+		}
+
 		if err != nil {
-			if handleIfFlood(err) {
+			if session.client.handleError(err) && len(clientPool) > 1 && s.failover(ctx, session) == nil {
+				continue
+			}
+			if wait := time.Duration(tg.GetFloodWait(err)) * time.Second; wait > 0 {
+				if wait > maxPoolWait {
+					return nil, &BusyError{RetryAfter: wait}
+				}
+				if err := sleepCtx(ctx, wait); err != nil {
+					return nil, err
+				}
 				continue
 			}
 			currentRetries++
 			if currentRetries > maxRetries {
-				return fmt.Errorf("telegram fetch failed after %d retries at offset %d: %w", maxRetries, offset, err)
+				return nil, fmt.Errorf("telegram fetch failed after %d retries at offset %d: %w", maxRetries, offset, err)
+			}
+			if err := sleepCtx(ctx, time.Duration(100*(1<<(currentRetries-1)))*time.Millisecond); err != nil {
+				return nil, err
 			}
-			backoffDuration := time.Duration(100*(1<<(currentRetries-1))) * time.Millisecond
-			time.Sleep(backoffDuration)
 			continue
 		}
 
-		currentRetries = 0
+		session.client.markSuccess()
 
 		file, ok := result.(*tg.UploadFileObj)
 		if !ok {
-			return fmt.Errorf("unexpected response type: %T", result)
+			return nil, fmt.Errorf("unexpected response type: %T", result)
 		}
 
-		if len(file.Bytes) == 0 {
-			break
+		data := file.Bytes
+		if int64(len(data)) > limit {
+			data = data[:limit]
 		}
+		return data, nil
+	}
+}
+
+// StreamMediaChunks downloads parts startChunk..endChunk (inclusive, or up
+// to the end of the file when endChunk is negative) and hands them to
+// callback in order. Up to STREAM_PREFETCH parts are requested ahead of the
+// one being written. Returning io.EOF from callback stops the stream
+// without an error; cancelling ctx aborts all in-flight requests.
+func StreamMediaChunks(ctx context.Context, chatID int64, messageID int, startChunk, endChunk int64, callback func([]byte) error) error {
+	session, err := openStreamSession(ctx, chatID, messageID)
+	if err != nil {
+		return err
+	}
+	session.client.acquire()
+
+	streamer := &chunkStreamer{
+		chatID:    chatID,
+		messageID: messageID,
+		session:   session,
+	}
+	defer streamer.close()
 
-		chunkData := file.Bytes
-		offsetDiff := offset - alignedOffset
-		if offsetDiff > 0 && offsetDiff < int64(len(chunkData)) {
-			chunkData = chunkData[offsetDiff:]
+	lastChunk := (session.fileSize - 1) / ChunkSize
+	if endChunk < 0 || endChunk > lastChunk {
+		endChunk = lastChunk
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each queued channel is one in-flight part; the queue capacity plus the
+	// part the consumer is waiting on bounds the read-ahead window.
+	workers := max(config.StreamPrefetch, 1)
+	queue := make(chan chan chunkResult, workers-1)
+
+	go func() {
+		defer close(queue)
+		for part := startChunk; part <= endChunk; part++ {
+			result := make(chan chunkResult, 1)
+			select {
+			case queue <- result:
+			case <-ctx.Done():
+				return
+			}
+			go func(part int64) {
+				data, err := streamer.fetchPart(ctx, part)
+				result <- chunkResult{data: data, err: err}
+			}(part)
+		}
+	}()
+
+	for result := range queue {
+		var res chunkResult
+		select {
+		case res = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 
-		if int64(len(chunkData)) > limit {
-			chunkData = chunkData[:limit]
+		if res.err != nil {
+			return res.err
+		}
+		if len(res.data) == 0 {
+			return nil
 		}
 
-		if err := callback(chunkData); err != nil {
+		if err := callback(res.data); err != nil {
 			if err == io.EOF {
 				return nil
 			}
 			return err
 		}
-
-		offset += int64(len(chunkData))
 	}
 
-	return nil
+	return ctx.Err()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func handleIfFlood(err error) bool {
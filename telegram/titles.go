@@ -0,0 +1,202 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"strix/database"
+)
+
+const titleRefreshBatch = 100
+
+var titleFetches sync.Map
+
+// refreshTitle fetches a title's TMDB metadata, plus episode names for the
+// seasons in the library, and stores it.
+func refreshTitle(tmdbID int, mediaType string) (*database.Title, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	details, err := TMDB.Details(ctx, mediaType, tmdbID)
+	if err != nil {
+		return nil, err
+	}
+
+	title := &database.Title{
+		TMDBID:       tmdbID,
+		MediaType:    mediaType,
+		Title:        details.DisplayTitle(),
+		ReleaseDate:  details.ReleaseDateOrAirDate(),
+		Overview:     details.Overview,
+		PosterPath:   details.PosterPath,
+		BackdropPath: details.BackdropPath,
+		Runtime:      details.RuntimeMinutes(),
+		IMDbID:       details.IMDb(),
+		VoteAverage:  details.VoteAverage,
+		FetchedAt:    time.Now(),
+	}
+
+	title.OriginalTitle = details.OriginalTitle
+	if title.OriginalTitle == "" {
+		title.OriginalTitle = details.OriginalName
+	}
+	title.Year, _ = strconv.Atoi(details.Year())
+	for _, g := range details.Genres {
+		title.Genres = append(title.Genres, g.Name)
+	}
+
+	if mediaType == "tv" {
+		seasons, err := db.GetAvailableSeasons(tmdbID)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range seasons {
+			season, err := TMDB.Season(ctx, tmdbID, n)
+			if err != nil {
+				log.Printf("[TITLES] Failed to fetch season %d of %d: %v", n, tmdbID, err)
+				continue
+			}
+			for _, e := range season.Episodes {
+				title.Episodes = append(title.Episodes, database.EpisodeMeta{
+					Season:  e.SeasonNumber,
+					Episode: e.EpisodeNumber,
+					Name:    e.Name,
+					AirDate: e.AirDate,
+				})
+			}
+		}
+	}
+
+	if err := db.UpsertTitle(title); err != nil {
+		return nil, err
+	}
+	return title, nil
+}
+
+// ensureTitle fetches metadata in the background for a file just added,
+// unless it is already stored. A new season of a known show refetches it to
+// pick up the episode names.
+func ensureTitle(tmdbID int, mediaType string, season int) {
+	if tmdbID == 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s_%d", mediaType, tmdbID)
+	if _, busy := titleFetches.LoadOrStore(key, true); busy {
+		return
+	}
+
+	go func() {
+		defer titleFetches.Delete(key)
+
+		stored, err := db.GetTitle(tmdbID, mediaType)
+		if err != nil {
+			log.Printf("[TITLES] Failed to load %s: %v", key, err)
+			return
+		}
+		if stored != nil && (mediaType != "tv" || season == 0 || stored.HasSeason(season)) {
+			return
+		}
+
+		if _, err := refreshTitle(tmdbID, mediaType); err != nil {
+			log.Printf("[TITLES] Failed to fetch %s: %v", key, err)
+		}
+	}()
+}
+
+// episodeRange resolves the episodes a file covers. A season pack, parsed
+// with a season but no episode, covers every episode TMDB lists for the
+// season; it stays at episode 0 when the season can't be looked up.
+func episodeRange(tmdbID, season, episode, lastEpisode int) (int, int) {
+	if lastEpisode <= episode {
+		lastEpisode = 0
+	}
+	if season == 0 || episode > 0 {
+		return episode, lastEpisode
+	}
+
+	switch n := seasonLength(tmdbID, season); n {
+	case 0:
+		return 0, 0
+	case 1:
+		return 1, 0
+	default:
+		return 1, n
+	}
+}
+
+// seasonLength returns the last episode number of a season, from stored
+// metadata when it has the season and from TMDB otherwise.
+func seasonLength(tmdbID, season int) int {
+	last := 0
+
+	stored, err := db.GetTitle(tmdbID, "tv")
+	if err == nil && stored != nil && stored.HasSeason(season) {
+		for _, e := range stored.Episodes {
+			if e.Season == season {
+				last = max(last, e.Episode)
+			}
+		}
+		return last
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s, err := TMDB.Season(ctx, tmdbID, season)
+	if err != nil {
+		log.Printf("[TITLES] Failed to fetch season %d of %d: %v", season, tmdbID, err)
+		return 0
+	}
+	for _, e := range s.Episodes {
+		last = max(last, e.EpisodeNumber)
+	}
+	return last
+}
+
+// episodeLabel formats an episode as S01E02, or a range as S01E02-E04.
+func episodeLabel(season, episode, lastEpisode int) string {
+	if lastEpisode > episode {
+		return fmt.Sprintf("S%02dE%02d-E%02d", season, episode, lastEpisode)
+	}
+	return fmt.Sprintf("S%02dE%02d", season, episode)
+}
+
+// startTitleRefresher fills in titles that have no metadata yet and
+// refetches ones older than TITLE_REFRESH_INTERVAL.
+func startTitleRefresher() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			refreshStaleTitles()
+			<-ticker.C
+		}
+	}()
+}
+
+func refreshStaleTitles() {
+	keys, err := db.GetTitlesToRefresh(time.Now().Add(-config.TitleRefreshInterval), titleRefreshBatch)
+	if err != nil {
+		log.Printf("[TITLES] Failed to list titles to refresh: %v", err)
+		return
+	}
+
+	refreshed := 0
+	for _, k := range keys {
+		if _, err := refreshTitle(k.TMDBID, k.MediaType); err != nil {
+			log.Printf("[TITLES] Failed to refresh %s_%d: %v", k.MediaType, k.TMDBID, err)
+			continue
+		}
+		refreshed++
+	}
+
+	if len(keys) > 0 {
+		log.Printf("[TITLES] Refreshed %d/%d titles", refreshed, len(keys))
+	}
+}
@@ -0,0 +1,263 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"strix/database"
+)
+
+// localTokenKind marks tokens that point at a file in FILES_DIR instead
+// of a Telegram message.
+const localTokenKind = "f"
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+	ErrRevokedToken = errors.New("token revoked")
+)
+
+// legacyTokenGrace is how long unsigned links keep working after the first
+// start with signed tokens, unless LEGACY_TOKENS_UNTIL says otherwise.
+const legacyTokenGrace = 30 * 24 * time.Hour
+
+const legacyDeadlineKey = "legacy_tokens_until"
+
+// legacyTokenUntil is when unsigned links stop working; zero when they
+// don't work at all.
+var legacyTokenUntil time.Time
+
+type streamKey struct {
+	ID     string
+	Secret []byte
+}
+
+// streamKeys holds the signing keys; the first one signs new tokens and
+// the rest are only accepted for verification while they are rotated out.
+var streamKeys []streamKey
+
+type StreamRequest struct {
+	ChatID    int64
+	MessageID int
+	Path      string
+	UserID    int64
+	ExpiresAt time.Time
+	Legacy    bool
+	Start     int64
+	End       int64
+}
+
+func loadStreamKeys() {
+	secrets := config.StreamSecrets
+	if len(secrets) == 0 {
+		log.Println("STREAM_SECRET not set, deriving stream token key from BOT_TOKEN")
+		secrets = []string{"strix-stream:" + config.BotToken}
+	}
+
+	streamKeys = streamKeys[:0]
+	for _, secret := range secrets {
+		sum := sha256.Sum256([]byte(secret))
+		streamKeys = append(streamKeys, streamKey{
+			ID:     hex.EncodeToString(sum[:4]),
+			Secret: []byte(secret),
+		})
+	}
+}
+
+// loadLegacyDeadline sets when unsigned links stop working. Without a date
+// in the config, the grace period starts on the first start and is saved
+// so restarts don't extend it.
+func loadLegacyDeadline() {
+	switch {
+	case config.LegacyTokensOff:
+		legacyTokenUntil = time.Time{}
+		return
+	case !config.LegacyTokenUntil.IsZero():
+		legacyTokenUntil = config.LegacyTokenUntil
+		return
+	}
+
+	value, err := db.GetSetting(legacyDeadlineKey)
+	if err != nil {
+		log.Printf("Failed to load legacy stream link deadline: %v", err)
+		legacyTokenUntil = time.Now().Add(legacyTokenGrace)
+		return
+	}
+	if saved, ok := value.(string); ok {
+		if deadline, err := time.Parse(time.RFC3339, saved); err == nil {
+			legacyTokenUntil = deadline
+			return
+		}
+	}
+
+	legacyTokenUntil = time.Now().Add(legacyTokenGrace)
+	if err := db.SetSetting(legacyDeadlineKey, legacyTokenUntil.Format(time.RFC3339), config.OwnerID); err != nil {
+		log.Printf("Failed to save legacy stream link deadline: %v", err)
+	}
+	log.Printf("Unsigned stream links are accepted until %s", legacyTokenUntil.Format("2006-01-02"))
+}
+
+func signStreamPayload(key streamKey, payload string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(key.ID + "." + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParseStreamToken verifies a signed stream token and returns the media it
+// grants access to. Unsigned "chat:message" tokens issued before signing
+// was introduced are accepted until the legacy grace period ends. Tokens
+// bound to a user stop working once that user loses access to the bot.
+func ParseStreamToken(token string) (*StreamRequest, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) == 1 {
+		return parseLegacyStreamToken(token)
+	}
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	payload, keyID, signature := parts[0], parts[1], parts[2]
+
+	var key *streamKey
+	for i := range streamKeys {
+		if streamKeys[i].ID == keyID {
+			key = &streamKeys[i]
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: unknown key", ErrInvalidToken)
+	}
+
+	expected := signStreamPayload(*key, payload)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	fields := strings.Split(string(decoded), ":")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("%w: bad payload", ErrInvalidToken)
+	}
+
+	req := &StreamRequest{}
+	if fields[0] == localTokenKind {
+		path, err := base64.RawURLEncoding.DecodeString(fields[1])
+		if err != nil || len(path) == 0 {
+			return nil, fmt.Errorf("%w: bad path", ErrInvalidToken)
+		}
+		req.Path = string(path)
+	} else {
+		req.ChatID, err = strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad chat ID", ErrInvalidToken)
+		}
+		req.MessageID, err = strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad message ID", ErrInvalidToken)
+		}
+	}
+
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad expiry", ErrInvalidToken)
+	}
+	userID, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad user ID", ErrInvalidToken)
+	}
+
+	req.ExpiresAt = time.Unix(expiry, 0)
+	if time.Now().After(req.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+	if userID != 0 && !canSearch(userID) {
+		return nil, ErrRevokedToken
+	}
+	req.UserID = userID
+
+	return req, nil
+}
+
+func parseLegacyStreamToken(token string) (*StreamRequest, error) {
+	decoded, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	parts := strings.Split(string(decoded), ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: bad legacy format", ErrInvalidToken)
+	}
+
+	chatID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad chat ID", ErrInvalidToken)
+	}
+
+	messageID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad message ID", ErrInvalidToken)
+	}
+
+	if legacyTokenUntil.IsZero() || time.Now().After(legacyTokenUntil) {
+		return nil, ErrExpiredToken
+	}
+
+	return &StreamRequest{
+		ChatID:    chatID,
+		MessageID: messageID,
+		ExpiresAt: legacyTokenUntil,
+		Legacy:    true,
+	}, nil
+}
+
+// GenerateStreamToken issues a token for a message that anyone holding the
+// link can use until it expires.
+func GenerateStreamToken(chatID int64, messageID int) string {
+	return GenerateUserStreamToken(chatID, messageID, 0)
+}
+
+// GenerateUserStreamToken issues a token bound to the Telegram user it was
+// handed to. Leaked links can be traced back to their owner, and stop
+// working if the owner's access is removed.
+func GenerateUserStreamToken(chatID int64, messageID int, userID int64) string {
+	return signStreamToken(fmt.Sprintf("%d:%d", chatID, messageID), userID)
+}
+
+// GenerateLocalStreamToken issues a token for a file below FILES_DIR.
+func GenerateLocalStreamToken(path string, userID int64) string {
+	ref := localTokenKind + ":" + base64.RawURLEncoding.EncodeToString([]byte(path))
+	return signStreamToken(ref, userID)
+}
+
+// MediaStreamToken issues a token for a library entry, whichever source
+// it is stored in.
+func MediaStreamToken(media *database.MediaFile, userID int64) string {
+	if media.Source == database.SourceLocal {
+		return GenerateLocalStreamToken(media.FilePath, userID)
+	}
+	return GenerateUserStreamToken(media.ChatID, media.MessageID, userID)
+}
+
+func signStreamToken(ref string, userID int64) string {
+	key := streamKeys[0]
+	expiry := time.Now().Add(config.StreamTokenTTL).Unix()
+
+	data := fmt.Sprintf("%s:%d:%d", ref, expiry, userID)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(data))
+
+	return payload + "." + key.ID + "." + signStreamPayload(key, payload)
+}
@@ -0,0 +1,213 @@
+package tmdb
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Result is one entry of a search or trending list. Movies fill Title and
+// ReleaseDate, shows Name and FirstAirDate.
+type Result struct {
+	ID           int     `json:"id"`
+	MediaType    string  `json:"media_type"`
+	Title        string  `json:"title"`
+	Name         string  `json:"name"`
+	Overview     string  `json:"overview"`
+	PosterPath   string  `json:"poster_path"`
+	BackdropPath string  `json:"backdrop_path"`
+	ReleaseDate  string  `json:"release_date"`
+	FirstAirDate string  `json:"first_air_date"`
+	VoteAverage  float64 `json:"vote_average"`
+	Popularity   float64 `json:"popularity"`
+}
+
+// DisplayTitle returns the movie title or show name.
+func (r Result) DisplayTitle() string {
+	if r.Title != "" {
+		return r.Title
+	}
+	return r.Name
+}
+
+// Year returns the release or first air year, or "".
+func (r Result) Year() string {
+	return year(r.ReleaseDate, r.FirstAirDate)
+}
+
+type SearchResponse struct {
+	Page         int      `json:"page"`
+	TotalPages   int      `json:"total_pages"`
+	TotalResults int      `json:"total_results"`
+	Results      []Result `json:"results"`
+}
+
+type FindResponse struct {
+	MovieResults []Result `json:"movie_results"`
+	TVResults    []Result `json:"tv_results"`
+}
+
+type Genre struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type SeasonSummary struct {
+	SeasonNumber int    `json:"season_number"`
+	Name         string `json:"name"`
+	EpisodeCount int    `json:"episode_count"`
+	AirDate      string `json:"air_date"`
+	PosterPath   string `json:"poster_path"`
+}
+
+// Details covers the fields shared by /movie/{id} and /tv/{id}.
+type Details struct {
+	ID               int             `json:"id"`
+	Title            string          `json:"title"`
+	Name             string          `json:"name"`
+	OriginalTitle    string          `json:"original_title"`
+	OriginalName     string          `json:"original_name"`
+	Overview         string          `json:"overview"`
+	PosterPath       string          `json:"poster_path"`
+	BackdropPath     string          `json:"backdrop_path"`
+	ReleaseDate      string          `json:"release_date"`
+	FirstAirDate     string          `json:"first_air_date"`
+	VoteAverage      float64         `json:"vote_average"`
+	Popularity       float64         `json:"popularity"`
+	Genres           []Genre         `json:"genres"`
+	Runtime          int             `json:"runtime"`
+	EpisodeRunTime   []int           `json:"episode_run_time"`
+	Status           string          `json:"status"`
+	IMDbID           string          `json:"imdb_id"`
+	NumberOfSeasons  int             `json:"number_of_seasons"`
+	NumberOfEpisodes int             `json:"number_of_episodes"`
+	Seasons          []SeasonSummary `json:"seasons"`
+	ExternalIDs      struct {
+		IMDbID string `json:"imdb_id"`
+	} `json:"external_ids"`
+}
+
+func (d Details) DisplayTitle() string {
+	if d.Title != "" {
+		return d.Title
+	}
+	return d.Name
+}
+
+func (d Details) Year() string {
+	return year(d.ReleaseDate, d.FirstAirDate)
+}
+
+// ReleaseDateOrAirDate returns the movie release date or the show's first
+// air date.
+func (d Details) ReleaseDateOrAirDate() string {
+	if d.ReleaseDate != "" {
+		return d.ReleaseDate
+	}
+	return d.FirstAirDate
+}
+
+// IMDb returns the IMDb ID, which shows only carry in external_ids.
+func (d Details) IMDb() string {
+	if d.IMDbID != "" {
+		return d.IMDbID
+	}
+	return d.ExternalIDs.IMDbID
+}
+
+// RuntimeMinutes returns the movie runtime or a typical episode length.
+func (d Details) RuntimeMinutes() int {
+	if d.Runtime > 0 {
+		return d.Runtime
+	}
+	if len(d.EpisodeRunTime) > 0 {
+		return d.EpisodeRunTime[0]
+	}
+	return 0
+}
+
+type Episode struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	Overview      string  `json:"overview"`
+	StillPath     string  `json:"still_path"`
+	SeasonNumber  int     `json:"season_number"`
+	EpisodeNumber int     `json:"episode_number"`
+	AirDate       string  `json:"air_date"`
+	Runtime       int     `json:"runtime"`
+	VoteAverage   float64 `json:"vote_average"`
+}
+
+type Season struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Overview     string    `json:"overview"`
+	PosterPath   string    `json:"poster_path"`
+	SeasonNumber int       `json:"season_number"`
+	AirDate      string    `json:"air_date"`
+	Episodes     []Episode `json:"episodes"`
+}
+
+// DetailsAppend is the append_to_response used by the detail pages.
+const DetailsAppend = "credits,videos,recommendations,external_ids"
+
+// SearchMulti searches movies, shows and people at once.
+func (c *Client) SearchMulti(ctx context.Context, query string) (*SearchResponse, error) {
+	var resp SearchResponse
+	if err := c.Get(ctx, "/search/multi", url.Values{"query": {query}}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FindByIMDb looks up a movie or show by its IMDb ID (tt…).
+func (c *Client) FindByIMDb(ctx context.Context, imdbID string) (*FindResponse, error) {
+	var resp FindResponse
+	params := url.Values{"external_source": {"imdb_id"}}
+	if err := c.Get(ctx, "/find/"+url.PathEscape(imdbID), params, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Details fetches a movie or show with its external IDs; mediaType is
+// "movie" or "tv".
+func (c *Client) Details(ctx context.Context, mediaType string, id int) (*Details, error) {
+	if mediaType != "movie" && mediaType != "tv" {
+		return nil, fmt.Errorf("tmdb: unknown media type %q", mediaType)
+	}
+
+	var details Details
+	params := url.Values{"append_to_response": {"external_ids"}}
+	if err := c.Get(ctx, fmt.Sprintf("/%s/%d", mediaType, id), params, &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+func (c *Client) Season(ctx context.Context, tvID, season int) (*Season, error) {
+	var resp Season
+	if err := c.Get(ctx, fmt.Sprintf("/tv/%d/season/%d", tvID, season), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Trending lists trending items; mediaType is "all", "movie" or "tv" and
+// window "day" or "week".
+func (c *Client) Trending(ctx context.Context, mediaType, window string) (*SearchResponse, error) {
+	var resp SearchResponse
+	if err := c.Get(ctx, fmt.Sprintf("/trending/%s/%s", mediaType, window), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func year(dates ...string) string {
+	for _, date := range dates {
+		if len(date) >= 4 {
+			return date[:4]
+		}
+	}
+	return ""
+}
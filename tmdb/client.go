@@ -0,0 +1,260 @@
+package tmdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://api.themoviedb.org/3"
+	imageBaseURL   = "https://image.tmdb.org/t/p/"
+
+	maxRetries      = 3
+	maxCacheEntries = 2000
+	// TMDB allows roughly 50 requests a second per IP.
+	minRequestGap = 20 * time.Millisecond
+)
+
+// Error is a non-2xx response from TMDB.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("tmdb: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("tmdb: %s (%d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is a TMDB 404.
+func IsNotFound(err error) bool {
+	var tmdbErr *Error
+	return errors.As(err, &tmdbErr) && tmdbErr.StatusCode == http.StatusNotFound
+}
+
+type Options struct {
+	// BaseURL defaults to DefaultBaseURL; point it at a local server to
+	// test without the real API.
+	BaseURL string
+	// Language is sent with every request, e.g. "en-US". Empty uses TMDB's
+	// default.
+	Language string
+	// CacheTTL is how long successful responses are reused. Zero disables
+	// the cache.
+	CacheTTL   time.Duration
+	HTTPClient *http.Client
+}
+
+type cacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// Client talks to the TMDB v3 API. It is safe for concurrent use.
+type Client struct {
+	apiKey   string
+	baseURL  string
+	language string
+	cacheTTL time.Duration
+	http     *http.Client
+
+	cacheMu sync.Mutex
+	cache   map[string]cacheEntry
+
+	rateMu   sync.Mutex
+	nextSlot time.Time
+}
+
+func New(apiKey string, opts Options) *Client {
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	return &Client{
+		apiKey:   apiKey,
+		baseURL:  opts.BaseURL,
+		language: opts.Language,
+		cacheTTL: opts.CacheTTL,
+		http:     opts.HTTPClient,
+		cache:    make(map[string]cacheEntry),
+	}
+}
+
+// ImageURL builds a poster or backdrop URL; size is a TMDB size like
+// "w500" or "original". An empty path gives an empty URL.
+func ImageURL(path, size string) string {
+	if path == "" {
+		return ""
+	}
+	return imageBaseURL + size + path
+}
+
+// Raw fetches path (e.g. "/movie/603") and returns the response body
+// unchanged, for handlers that pass TMDB JSON through to the browser.
+func (c *Client) Raw(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	if c.language != "" && query.Get("language") == "" {
+		query.Set("language", c.language)
+	}
+
+	key := path + "?" + query.Encode()
+	if body, ok := c.cached(key); ok {
+		return body, nil
+	}
+
+	query.Set("api_key", c.apiKey)
+	body, err := c.fetch(ctx, c.baseURL+path+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(key, body)
+	return body, nil
+}
+
+// Get fetches path and decodes the JSON response into out.
+func (c *Client) Get(ctx context.Context, path string, params url.Values, out any) error {
+	body, err := c.Raw(ctx, path, params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// fetch performs the request, backing off and retrying when TMDB answers
+// 429 Too Many Requests.
+func (c *Client) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxRetries {
+			delay := retryAfter(resp.Header.Get("Retry-After"), attempt)
+			log.Printf("[TMDB] Rate limited, retrying in %v", delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			var status struct {
+				Message string `json:"status_message"`
+			}
+			json.Unmarshal(body, &status)
+			return nil, &Error{StatusCode: resp.StatusCode, Message: status.Message}
+		}
+
+		return body, nil
+	}
+}
+
+func retryAfter(header string, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Second << attempt
+}
+
+// wait spaces requests out so bursts stay under TMDB's rate limit.
+func (c *Client) wait(ctx context.Context) error {
+	c.rateMu.Lock()
+	now := time.Now()
+	slot := c.nextSlot
+	if slot.Before(now) {
+		slot = now
+	}
+	c.nextSlot = slot.Add(minRequestGap)
+	c.rateMu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) cached(key string) ([]byte, bool) {
+	if c.cacheTTL <= 0 {
+		return nil, false
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	entry, ok := c.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *Client) store(key string, body []byte) {
+	if c.cacheTTL <= 0 {
+		return
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	now := time.Now()
+	if len(c.cache) >= maxCacheEntries {
+		for k, entry := range c.cache {
+			if now.After(entry.expires) {
+				delete(c.cache, k)
+			}
+		}
+	}
+	// Still full of live entries: drop arbitrary ones rather than grow.
+	for k := range c.cache {
+		if len(c.cache) < maxCacheEntries {
+			break
+		}
+		delete(c.cache, k)
+	}
+
+	c.cache[key] = cacheEntry{body: body, expires: now.Add(c.cacheTTL)}
+}
@@ -131,3 +131,20 @@ type SearchResponse struct {
 	Query   string      `json:"query"`
 	Results []MediaItem `json:"results"`
 }
+
+type TracksResponse struct {
+	Container string      `json:"container"`
+	Audio     []TrackInfo `json:"audio"`
+	Subtitles []TrackInfo `json:"subtitles"`
+}
+
+type TrackInfo struct {
+	Number   int    `json:"number"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Channels int    `json:"channels,omitempty"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+	URL      string `json:"url,omitempty"`
+}